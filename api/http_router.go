@@ -1,15 +1,29 @@
 package api
 
 import (
+	"expvar"
+	"math"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/decentralize-everything/indexer/builder"
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/extract/rawblock"
+	"github.com/decentralize-everything/indexer/pending"
+	"github.com/decentralize-everything/indexer/protocol"
 	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/types"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
-func SetupRouter(db store.Database) *gin.Engine {
+// SetupRouter sets up the HTTP API, unconfirmed activity is only served if the watcher is not nil. The network params
+// are used to derive addresses of transactions to validate.
+func SetupRouter(db store.Database, watcher *pending.Watcher, params *chaincfg.Params) *gin.Engine {
 	r := gin.Default()
 
 	r.GET("/api/v1/status", func(c *gin.Context) {
@@ -21,6 +35,29 @@ func SetupRouter(db store.Database) *gin.Engine {
 		ci, _ := db.GetCoinInfoById(id)
 		c.JSON(http.StatusOK, gin.H{"result": ci != nil, "data": ci})
 	})
+	r.GET("/api/v1/coins/:id/transactions", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		cursor := c.DefaultQuery("cursor", strconv.Itoa(math.MaxInt))
+		limit := c.DefaultQuery("limit", "10")
+		txType := c.Query("type")
+		listCoinTxs(db, id, cursor, limit, txType, c)
+	})
+	r.GET("/api/v1/coins/:id/holders", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		page := c.DefaultQuery("page", "1")
+		pageSize := c.DefaultQuery("page_size", "10")
+		listHolders(db, id, page, pageSize, c)
+	})
+	r.GET("/api/v1/coins/:id/holders/count", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		minBalance, err := types.ParseAmount(c.DefaultQuery("min_balance", "1"))
+		if err != nil || minBalance.Sign() <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid min_balance, should be positive"})
+			return
+		}
+		count, _ := db.CountHoldersAbove(id, minBalance)
+		c.JSON(http.StatusOK, gin.H{"result": true, "data": map[string]interface{}{"count": count}})
+	})
 	r.GET("/api/v1/coins", func(c *gin.Context) {
 		page := c.DefaultQuery("page", "1")
 		pageSize := c.DefaultQuery("page_size", "10")
@@ -38,13 +75,122 @@ func SetupRouter(db store.Database) *gin.Engine {
 		coins, _ := db.GetCoinsByAddress(address)
 		c.JSON(http.StatusOK, gin.H{"result": coins != nil, "data": coins})
 	})
+	r.GET("/api/v1/addresses/:address/history", func(c *gin.Context) {
+		address := c.Params.ByName("address")
+		page := c.DefaultQuery("page", "1")
+		pageSize := c.DefaultQuery("page_size", "10")
+		coin := c.Query("coin")
+		fromHeight := c.DefaultQuery("from_height", "0")
+		toHeight := c.DefaultQuery("to_height", strconv.Itoa(math.MaxInt))
+		listHistory(db, address, page, pageSize, coin, fromHeight, toHeight, c)
+	})
+	r.GET("/api/v1/utxos/:txid/:vout", func(c *gin.Context) {
+		if _, err := strconv.Atoi(c.Params.ByName("vout")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vout"})
+			return
+		}
+		utxo := c.Params.ByName("txid") + ":" + c.Params.ByName("vout")
+		c.JSON(http.StatusOK, gin.H{"result": true, "data": lookupUtxos(db, []string{utxo})[0]})
+	})
+	r.POST("/api/v1/utxos/lookup", func(c *gin.Context) {
+		var req struct {
+			Utxos []string `json:"utxos"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Utxos) < 1 || len(req.Utxos) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid utxos, should be 1 to 100 outpoints as txid:vout"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"result": true, "data": lookupUtxos(db, req.Utxos)})
+	})
+	r.POST("/api/v1/tx/validate", func(c *gin.Context) {
+		var req struct {
+			Hex string `json:"hex"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Hex) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid hex, should be a serialized transaction"})
+			return
+		}
+		tx, err := rawblock.DecodeTransaction(req.Hex, params)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"result": true, "data": validateTx(db, tx)})
+	})
+	r.GET("/api/v1/tx/:txid/status", func(c *gin.Context) {
+		txid := c.Params.ByName("txid")
+		c.JSON(http.StatusOK, gin.H{"result": true, "data": txStatus(db, txid)})
+	})
+	txBuilder := builder.NewBuilder(db, params)
+	r.POST("/api/v1/build/deploy", func(c *gin.Context) {
+		var req struct {
+			Id            string          `json:"id"`
+			Max           uint64          `json:"max"`
+			Sats          uint64          `json:"sats"`
+			Limit         uint64          `json:"limit"`
+			Utxos         []*builder.Utxo `json:"utxos"`
+			ChangeAddress string          `json:"change_address"`
+			FeeRate       int64           `json:"fee_rate"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		packet, fee, err := txBuilder.Deploy(req.Id, req.Max, req.Sats, req.Limit, req.Utxos, req.ChangeAddress, req.FeeRate)
+		respondPsbt(packet, fee, err, c)
+	})
+	r.POST("/api/v1/build/mint", func(c *gin.Context) {
+		var req struct {
+			Id            string          `json:"id"`
+			Amount        types.Amount    `json:"amount"`
+			Recipient     string          `json:"recipient"`
+			Utxos         []*builder.Utxo `json:"utxos"`
+			ChangeAddress string          `json:"change_address"`
+			FeeRate       int64           `json:"fee_rate"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		packet, fee, err := txBuilder.Mint(req.Id, req.Amount, req.Recipient, req.Utxos, req.ChangeAddress, req.FeeRate)
+		respondPsbt(packet, fee, err, c)
+	})
+	r.POST("/api/v1/build/transfer", func(c *gin.Context) {
+		var req struct {
+			Id            string            `json:"id"`
+			Outputs       []*builder.Output `json:"outputs"`
+			Utxos         []*builder.Utxo   `json:"utxos"`
+			ChangeAddress string            `json:"change_address"`
+			FeeRate       int64             `json:"fee_rate"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		packet, fee, err := txBuilder.Transfer(req.Id, req.Outputs, req.Utxos, req.ChangeAddress, req.FeeRate)
+		respondPsbt(packet, fee, err, c)
+	})
+	if watcher != nil {
+		r.GET("/api/v1/addresses/:address/pending", func(c *gin.Context) {
+			address := c.Params.ByName("address")
+			activity := watcher.GetActivity(address)
+			c.JSON(http.StatusOK, gin.H{"result": activity != nil, "data": activity})
+		})
+		r.GET("/api/v1/utxos/:txid/:vout/pending", func(c *gin.Context) {
+			utxo := c.Params.ByName("txid") + ":" + c.Params.ByName("vout")
+			spend := watcher.GetSpend(utxo)
+			c.JSON(http.StatusOK, gin.H{"result": spend != nil, "data": spend})
+		})
+	}
+
+	r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
 
 	return r
 }
 
 func listCoins(db store.Database, page string, pageSize string, sortedBy string, dir string, c *gin.Context) {
 	p, err := strconv.Atoi(page)
-	if err != nil {
+	if err != nil || p < 1 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
 		return
 	}
@@ -65,17 +211,17 @@ func listCoins(db store.Database, page string, pageSize string, sortedBy string,
 		return
 	}
 
-	coins, _ := db.GetCoinInfos()
-	start := (p - 1) * size
-	end := start + size
-	// Check if start and end are within bounds
-	if start >= len(coins) {
-		c.JSON(http.StatusOK, gin.H{"result": true, "data": map[string]interface{}{"total": len(coins), "list": nil}})
+	coins, err := db.GetCoinInfos()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if end > len(coins) {
-		end = len(coins)
+	start := pageStart(p, size)
+	if start >= len(coins) {
+		c.JSON(http.StatusOK, gin.H{"result": true, "data": map[string]interface{}{"total": len(coins), "list": []*types.CoinInfo{}}})
+		return
 	}
+	end := min(start+size, len(coins))
 
 	var sortFunc func(i, j int) bool
 	switch sortedBy {
@@ -108,3 +254,250 @@ func listCoins(db store.Database, page string, pageSize string, sortedBy string,
 	sort.Slice(coins, sortFunc)
 	c.JSON(http.StatusOK, gin.H{"result": true, "data": map[string]interface{}{"total": len(coins), "list": coins[start:end]}})
 }
+
+// listHistory lists the history of the address, newest first.
+func listHistory(db store.Database, address string, page string, pageSize string, coin string, fromHeight string, toHeight string, c *gin.Context) {
+	p, err := strconv.Atoi(page)
+	if err != nil || p < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return
+	}
+
+	size, err := strconv.Atoi(pageSize)
+	if err != nil || size < 1 || size > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page_size, should be between 1 and 100"})
+		return
+	}
+
+	from, err := strconv.Atoi(fromHeight)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from_height"})
+		return
+	}
+
+	to, err := strconv.Atoi(toHeight)
+	if err != nil || to < from {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to_height, should not be less than from_height"})
+		return
+	}
+
+	entries, total, err := db.GetHistoryByAddress(address, coin, from, to, pageStart(p, size), size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if entries == nil {
+		entries = []*types.HistoryEntry{}
+	}
+	c.JSON(http.StatusOK, gin.H{"result": true, "data": map[string]interface{}{"total": total, "list": entries}})
+}
+
+// listCoinTxs lists the transactions of the coin before the cursor, newest first. The cursor of the next page is the
+// sequence of the last transaction listed.
+func listCoinTxs(db store.Database, id string, cursor string, limit string, txType string, c *gin.Context) {
+	before, err := strconv.Atoi(cursor)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+		return
+	}
+
+	size, err := strconv.Atoi(limit)
+	if err != nil || size < 1 || size > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit, should be between 1 and 100"})
+		return
+	}
+
+	if len(txType) > 0 && txType != "deploy" && txType != "mint" && txType != "transfer" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid type, should be deploy, mint or transfer"})
+		return
+	}
+
+	list, err := db.GetCoinTxs(id, before, size, txType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if list == nil {
+		list = []*types.CoinTx{}
+	}
+
+	// No more pages if the oldest transaction is listed.
+	var nextCursor interface{}
+	if len(list) == size && list[size-1].Seq > 0 {
+		nextCursor = list[size-1].Seq
+	}
+	c.JSON(http.StatusOK, gin.H{"result": true, "data": map[string]interface{}{"list": list, "next_cursor": nextCursor}})
+}
+
+// listHolders lists the holders of the coin, largest balance first.
+func listHolders(db store.Database, id string, page string, pageSize string, c *gin.Context) {
+	p, err := strconv.Atoi(page)
+	if err != nil || p < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return
+	}
+
+	size, err := strconv.Atoi(pageSize)
+	if err != nil || size < 1 || size > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page_size, should be between 1 and 100"})
+		return
+	}
+
+	ci, err := db.GetCoinInfoById(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if ci == nil {
+		c.JSON(http.StatusOK, gin.H{"result": false, "data": nil})
+		return
+	}
+
+	start := pageStart(p, size)
+	holders, total, err := db.GetHolders(id, start, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(holders))
+	for i, holder := range holders {
+		percentage := 0.0
+		if ci.TotalSupply.Sign() > 0 {
+			percentage = holder.Balance.Float64() * 100 / ci.TotalSupply.Float64()
+		}
+		list = append(list, map[string]interface{}{
+			"rank":       start + i + 1,
+			"address":    holder.Address,
+			"balance":    holder.Balance,
+			"percentage": percentage,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"result": true, "data": map[string]interface{}{"total": total, "list": list}})
+}
+
+// pageStart returns the offset of the first item of the page, past the end of any list if the page is too large to
+// have an offset.
+func pageStart(p int, size int) int {
+	if p-1 > math.MaxInt/size {
+		return math.MaxInt
+	}
+	return (p - 1) * size
+}
+
+// lookupUtxos returns the coins carried by the UTXOs, and whether they were spent, in the order given. A UTXO may
+// carry coins of several protocols, spent ones keep the spending transaction.
+func lookupUtxos(db store.Database, utxos []string) []map[string]interface{} {
+	unspent, _ := db.GetCoinsInUtxos(utxos)
+	spent, _ := db.GetSpentCoins(utxos)
+	coins := make(map[string][]map[string]interface{})
+	for _, uc := range unspent {
+		coins[uc.Utxo] = append(coins[uc.Utxo], utxoCoin(&types.SpentCoin{UnspentCoin: *uc}))
+	}
+	for _, sc := range spent {
+		coins[sc.Utxo] = append(coins[sc.Utxo], utxoCoin(sc))
+	}
+
+	results := make([]map[string]interface{}, 0, len(utxos))
+	for _, utxo := range utxos {
+		if _, ok := coins[utxo]; !ok {
+			coins[utxo] = []map[string]interface{}{}
+		}
+		results = append(results, map[string]interface{}{
+			"utxo":  utxo,
+			"coins": coins[utxo],
+		})
+	}
+	return results
+}
+
+func utxoCoin(coin *types.SpentCoin) map[string]interface{} {
+	return map[string]interface{}{
+		"coin_id":      coin.CoinId,
+		"protocol":     coin.Protocol,
+		"amount":       coin.Amount,
+		"owner":        coin.Owner,
+		"spent":        len(coin.SpentTxid) > 0,
+		"spent_txid":   coin.SpentTxid,
+		"spent_height": coin.SpentHeight,
+	}
+}
+
+// validateTx parses the transaction as the Carv protocol would if it were confirmed in the next block, against the
+// indexed state. Parsing only reads the store, nothing is committed.
+func validateTx(db store.Database, tx extract.Transaction) map[string]interface{} {
+	newCoinEvents, balanceChangeEvents, err := protocol.NewCarvProtocol(db, zap.NewNop()).Parse(tx)
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+	}
+	if newCoinEvents == nil {
+		newCoinEvents = []*types.NewCoinEvent{}
+	}
+	if balanceChangeEvents == nil {
+		balanceChangeEvents = []*types.BalanceChangeEvent{}
+	}
+	return map[string]interface{}{
+		"txid":                  tx.GetTxid(),
+		"valid":                 err == nil,
+		"reason":                reason,
+		"new_coin_events":       newCoinEvents,
+		"balance_change_events": balanceChangeEvents,
+	}
+}
+
+func respondPsbt(packet *psbt.Packet, fee int64, err error, c *gin.Context) {
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": true, "data": map[string]interface{}{
+		"psbt": encoded,
+		"txid": packet.UnsignedTx.TxHash().String(),
+		"fee":  fee,
+	}})
+}
+
+// txStatus tells if the transaction was indexed as a valid Carv operation, rejected with a reason, or neither. A valid
+// transaction may have some of its coins burnt, with the reasons. Coins indexed before protocols were recorded are all
+// Carv.
+func txStatus(db store.Database, txid string) map[string]interface{} {
+	height, _, _ := db.GetStatus()
+	status := map[string]interface{}{
+		"txid":           txid,
+		"status":         "not-carv",
+		"indexed_height": height,
+	}
+
+	ops, _ := db.GetInvalidOperations(txid)
+	var reasons []string
+	for _, op := range ops {
+		if op.Protocol == "" || op.Protocol == "carv" {
+			if len(reasons) == 0 {
+				status["status"] = "invalid"
+				status["height"] = op.Height
+				status["coin_id"] = op.CoinId
+			}
+			reasons = append(reasons, op.Reason)
+		}
+	}
+	if len(reasons) > 0 {
+		status["reason"] = strings.Join(reasons, "; ")
+	}
+
+	txs, _ := db.GetCoinTxsByTxid(txid)
+	for _, tx := range txs {
+		if tx.Protocol == "" || tx.Protocol == "carv" {
+			status["status"] = "valid"
+			status["height"] = tx.Height
+			status["coin_id"] = tx.CoinId
+			status["type"] = tx.Type
+			break
+		}
+	}
+	return status
+}
@@ -0,0 +1,296 @@
+package builder
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/decentralize-everything/indexer/extract/rawblock"
+	"github.com/decentralize-everything/indexer/protocol"
+	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/decentralize-everything/indexer/utils"
+	"go.uber.org/zap"
+)
+
+var (
+	DUST_LIMIT = int64(546) // Change below it goes to the fee.
+
+	// Weights for fee estimation, inputs are assumed to spend their address type with a single signature.
+	TX_OVERHEAD_WEIGHT  = int64(42) // Version, locktime, input and output counts, segwit marker and flag.
+	P2PKH_INPUT_WEIGHT  = int64(592)
+	P2SH_INPUT_WEIGHT   = int64(364) // Nested P2WPKH.
+	P2WPKH_INPUT_WEIGHT = int64(272)
+	P2TR_INPUT_WEIGHT   = int64(230)
+)
+
+// Utxo is a UTXO funding a transaction to build.
+type Utxo struct {
+	Txid    string `json:"txid"`
+	Vout    int    `json:"vout"`
+	Value   int64  `json:"value"`   // In satoshis.
+	Address string `json:"address"` // The owner, which tells the script of the witness UTXO and the input size.
+}
+
+// Output is a transfer of coins to an address.
+type Output struct {
+	Address string       `json:"address"`
+	Amount  types.Amount `json:"amount"`
+}
+
+// Builder builds unsigned Carv transactions as PSBTs. Every transaction built is parsed by the Carv protocol against
+// the store before it's returned, so that it's accepted if confirmed in the next block. Inputs holding coins which the
+// transaction would burn are refused.
+type Builder struct {
+	db     store.Database
+	params *chaincfg.Params
+}
+
+func NewBuilder(db store.Database, params *chaincfg.Params) *Builder {
+	return &Builder{
+		db:     db,
+		params: params,
+	}
+}
+
+// Deploy builds a deployment of the coin, with the metadata as the first output. Returns the PSBT and the fee.
+func (b *Builder) Deploy(id string, max uint64, sats uint64, limit uint64, utxos []*Utxo, changeAddress string, feeRate int64) (*psbt.Packet, int64, error) {
+	if utils.Base26Decode(utils.Base26Encode(id)) != id {
+		return nil, 0, fmt.Errorf("invalid coin ID: %s", id)
+	}
+	if _, err := b.inputCoins(utxos, ""); err != nil {
+		return nil, 0, err
+	}
+
+	outputs := []*wire.TxOut{metadata(utils.Base26Encode(id), max, sats, limit)}
+	return b.build(utxos, outputs, changeAddress, feeRate)
+}
+
+// Mint builds a mint of the amount of coins to the recipient, followed by the metadata. Returns the PSBT and the fee.
+func (b *Builder) Mint(id string, amount types.Amount, recipient string, utxos []*Utxo, changeAddress string, feeRate int64) (*psbt.Packet, int64, error) {
+	sats, err := b.sats(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := b.inputCoins(utxos, ""); err != nil {
+		return nil, 0, err
+	}
+
+	out, err := b.coinOutput(recipient, amount, sats)
+	if err != nil {
+		return nil, 0, err
+	}
+	outputs := []*wire.TxOut{out, metadata(utils.Base26Encode(id))}
+	return b.build(utxos, outputs, changeAddress, feeRate)
+}
+
+// Transfer builds a transfer of the coins held by the UTXOs, to the outputs followed by the metadata. Coins not
+// transferred are sent back to the change address, before the metadata, rather than burnt. Returns the PSBT and the
+// fee.
+func (b *Builder) Transfer(id string, transfers []*Output, utxos []*Utxo, changeAddress string, feeRate int64) (*psbt.Packet, int64, error) {
+	if len(transfers) == 0 {
+		return nil, 0, fmt.Errorf("no outputs to transfer to")
+	}
+	sats, err := b.sats(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	totalInput, err := b.inputCoins(utxos, id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var outputs []*wire.TxOut
+	totalOutput := types.Amount{}
+	for _, transfer := range transfers {
+		out, err := b.coinOutput(transfer.Address, transfer.Amount, sats)
+		if err != nil {
+			return nil, 0, err
+		}
+		outputs = append(outputs, out)
+		if totalOutput, err = totalOutput.Add(transfer.Amount); err != nil {
+			return nil, 0, err
+		}
+	}
+	change, err := totalInput.Sub(totalOutput)
+	if err != nil {
+		return nil, 0, err
+	}
+	if change.Sign() < 0 {
+		return nil, 0, fmt.Errorf("insufficient coins in inputs, input = %s, output = %s", totalInput, totalOutput)
+	}
+	if change.Sign() > 0 {
+		out, err := b.coinOutput(changeAddress, change, sats)
+		if err != nil {
+			return nil, 0, err
+		}
+		outputs = append(outputs, out)
+	}
+	outputs = append(outputs, metadata(utils.Base26Encode(id)))
+	return b.build(utxos, outputs, changeAddress, feeRate)
+}
+
+func (b *Builder) sats(id string) (uint64, error) {
+	ci, err := b.db.GetCoinInfoById(id)
+	if err != nil || ci == nil {
+		return 0, fmt.Errorf("coin ID not found: %s", id)
+	}
+	if ci.Args.Carv == nil {
+		return 0, fmt.Errorf("coin %s is not a Carv coin", id)
+	}
+	return ci.Args.Carv.Sats, nil
+}
+
+// inputCoins returns the amount of the coin held by the inputs, which must hold no other coins. The coin ID is "" if
+// the inputs must hold no coins at all.
+func (b *Builder) inputCoins(utxos []*Utxo, id string) (types.Amount, error) {
+	if len(utxos) == 0 {
+		return types.Amount{}, fmt.Errorf("no UTXOs to fund the transaction")
+	}
+	coins, err := b.db.GetCoinsInUtxos(outpoints(utxos))
+	if err != nil {
+		return types.Amount{}, err
+	}
+	total := types.Amount{}
+	for _, coin := range coins {
+		if coin.CoinId != id {
+			return types.Amount{}, fmt.Errorf("UTXO %s holds %s %s, which would be burnt", coin.Utxo, coin.Amount, coin.CoinId)
+		}
+		if total, err = total.Add(coin.Amount); err != nil {
+			return types.Amount{}, err
+		}
+	}
+	return total, nil
+}
+
+func (b *Builder) coinOutput(address string, amount types.Amount, sats uint64) (*wire.TxOut, error) {
+	n, ok := amount.Uint64()
+	if !ok || n < 1 || n > uint64(math.MaxInt64)/sats {
+		return nil, fmt.Errorf("invalid amount %s for %s", amount, address)
+	}
+	script, err := b.script(address)
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewTxOut(int64(n*sats), script), nil
+}
+
+func (b *Builder) script(address string) ([]byte, error) {
+	addr, err := btcutil.DecodeAddress(address, b.params)
+	if err != nil || !addr.IsForNet(b.params) {
+		return nil, fmt.Errorf("invalid address: %s", address)
+	}
+	return txscript.PayToAddrScript(addr)
+}
+
+// build adds the inputs and the change output after the outputs, then checks the transaction with the Carv protocol.
+func (b *Builder) build(utxos []*Utxo, outputs []*wire.TxOut, changeAddress string, feeRate int64) (*psbt.Packet, int64, error) {
+	if feeRate < 1 {
+		return nil, 0, fmt.Errorf("invalid fee rate %d, should be at least 1 sat/vB", feeRate)
+	}
+	changeScript, err := b.script(changeAddress)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tx := wire.NewMsgTx(2)
+	weight := TX_OVERHEAD_WEIGHT
+	totalInput := int64(0)
+	scripts := make([][]byte, len(utxos))
+	for i, utxo := range utxos {
+		hash, err := chainhash.NewHashFromStr(utxo.Txid)
+		if err != nil || utxo.Vout < 0 || utxo.Value <= 0 {
+			return nil, 0, fmt.Errorf("invalid UTXO %s:%d", utxo.Txid, utxo.Vout)
+		}
+		if scripts[i], err = b.script(utxo.Address); err != nil {
+			return nil, 0, err
+		}
+		w, err := inputWeight(scripts[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, uint32(utxo.Vout)), nil, nil))
+		weight += w
+		totalInput += utxo.Value
+	}
+	totalOutput := int64(0)
+	for _, out := range outputs {
+		tx.AddTxOut(out)
+		weight += outputWeight(out.PkScript)
+		totalOutput += out.Value
+	}
+
+	fee := vsize(weight) * feeRate
+	if totalInput < totalOutput+fee {
+		return nil, 0, fmt.Errorf("insufficient funds, input = %d, output = %d, fee = %d", totalInput, totalOutput, fee)
+	}
+	changeFee := vsize(weight+outputWeight(changeScript)) * feeRate
+	if change := totalInput - totalOutput - changeFee; change >= DUST_LIMIT {
+		tx.AddTxOut(wire.NewTxOut(change, changeScript))
+		fee = changeFee
+	} else {
+		fee = totalInput - totalOutput
+	}
+
+	parsed := rawblock.NewTransaction(tx, b.params)
+	if _, _, err := protocol.NewCarvProtocol(b.db, zap.NewNop()).Parse(&parsed); err != nil {
+		return nil, 0, fmt.Errorf("transaction rejected by the Carv protocol: %v", err)
+	}
+
+	packet, err := psbt.NewFromUnsignedTx(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, utxo := range utxos {
+		// Legacy inputs need the full previous transaction instead, which is left to the wallet.
+		if txscript.IsWitnessProgram(scripts[i]) || txscript.IsPayToScriptHash(scripts[i]) {
+			packet.Inputs[i].WitnessUtxo = wire.NewTxOut(utxo.Value, scripts[i])
+		}
+	}
+	return packet, fee, nil
+}
+
+// metadata builds the Carv protocol metadata output of the arguments.
+func metadata(args ...uint64) *wire.TxOut {
+	meta := utils.VarintEncodeArray(args)
+	// Pushed as is, the script builder would turn single bytes into small integer opcodes.
+	script := append([]byte{txscript.OP_RETURN, txscript.OP_DATA_1, 0x43, byte(len(meta))}, meta...)
+	return wire.NewTxOut(0, script)
+}
+
+func inputWeight(script []byte) (int64, error) {
+	switch txscript.GetScriptClass(script) {
+	case txscript.PubKeyHashTy:
+		return P2PKH_INPUT_WEIGHT, nil
+	case txscript.ScriptHashTy:
+		return P2SH_INPUT_WEIGHT, nil
+	case txscript.WitnessV0PubKeyHashTy:
+		return P2WPKH_INPUT_WEIGHT, nil
+	case txscript.WitnessV1TaprootTy:
+		return P2TR_INPUT_WEIGHT, nil
+	default:
+		return 0, fmt.Errorf("unsupported input script: %x", script)
+	}
+}
+
+func outputWeight(script []byte) int64 {
+	return int64(4 * (8 + wire.VarIntSerializeSize(uint64(len(script))) + len(script)))
+}
+
+func vsize(weight int64) int64 {
+	return (weight + 3) / 4
+}
+
+func outpoints(utxos []*Utxo) []string {
+	results := make([]string, 0, len(utxos))
+	for _, utxo := range utxos {
+		results = append(results, utxo.Txid+":"+strconv.Itoa(utxo.Vout))
+	}
+	return results
+}
@@ -0,0 +1,151 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/extract/rawblock"
+	"github.com/decentralize-everything/indexer/protocol"
+	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var (
+	params = &chaincfg.TestNet3Params
+	txidA  = strings.Repeat("a", 64)
+	txidB  = strings.Repeat("b", 64)
+	txidC  = strings.Repeat("c", 64)
+)
+
+func address(b byte) string {
+	hash := make([]byte, 20)
+	hash[0] = b
+	addr, _ := btcutil.NewAddressWitnessPubKeyHash(hash, params)
+	return addr.EncodeAddress()
+}
+
+// setup indexes coin TESTC, with 10 coins in txidA:0 and 1 coin of TESTD in txidB:0.
+func setup(t *testing.T) (store.Database, *Builder) {
+	db := store.NewMemDb("", "testnet", 10, false, zap.NewNop())
+	args := types.CoinArgs{Carv: &types.CarvArgs{Max: 100, Sats: 10000, Limit: 5}}
+	assert.NoError(t, db.ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		Hash:   "hash1",
+		CoinInfos: map[string]*types.CoinInfo{
+			"TESTC": {Id: "TESTC", Protocol: "carv", TotalSupply: types.NewAmount(11), Args: args},
+			"TESTD": {Id: "TESTD", Protocol: "carv", TotalSupply: types.NewAmount(1), Args: args},
+		},
+		Balances: map[string]map[string]types.Amount{
+			"TESTC": {address(1): types.NewAmount(10)},
+			"TESTD": {address(1): types.NewAmount(1)},
+		},
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			txidA + ":0": {"TESTC": {CoinId: "TESTC", Protocol: "carv", Owner: address(1), Amount: types.NewAmount(10), Utxo: txidA + ":0"}},
+			txidB + ":0": {"TESTD": {CoinId: "TESTD", Protocol: "carv", Owner: address(1), Amount: types.NewAmount(1), Utxo: txidB + ":0"}},
+		},
+	}))
+	return db, NewBuilder(db, params)
+}
+
+func parse(t *testing.T, db store.Database, packet *psbt.Packet) ([]*types.NewCoinEvent, []*types.BalanceChangeEvent) {
+	tx := rawblock.NewTransaction(packet.UnsignedTx, params)
+	newCoinEvents, balanceChangeEvents, err := protocol.NewCarvProtocol(db, zap.NewNop()).Parse(extract.Transaction(&tx))
+	assert.NoError(t, err)
+	return newCoinEvents, balanceChangeEvents
+}
+
+func TestDeploy(t *testing.T) {
+	db, builder := setup(t)
+	funding := []*Utxo{{Txid: txidC, Vout: 1, Value: 100000, Address: address(2)}}
+
+	packet, fee, err := builder.Deploy("NEWC", 1000, 10000, 10, funding, address(2), 2)
+	assert.NoError(t, err)
+	outs := packet.UnsignedTx.TxOut
+	assert.Len(t, outs, 2)
+	assert.Equal(t, int64(0), outs[0].Value)
+	assert.Equal(t, int64(100000)-fee, outs[1].Value)
+	// 1 P2WPKH input, the metadata and the P2WPKH change.
+	assert.Equal(t, int64(2*((42+272+4*(8+1+len(outs[0].PkScript))+4*(8+1+22)+3)/4)), fee)
+	assert.Equal(t, int64(100000), packet.Inputs[0].WitnessUtxo.Value)
+	newCoinEvents, _ := parse(t, db, packet)
+	assert.Len(t, newCoinEvents, 1)
+	assert.Equal(t, "NEWC", newCoinEvents[0].CoinId)
+	_, err = packet.B64Encode()
+	assert.NoError(t, err)
+
+	_, _, err = builder.Deploy("TESTC", 1000, 10000, 10, funding, address(2), 2)
+	assert.ErrorContains(t, err, "coin ID already taken: TESTC")
+	_, _, err = builder.Deploy("newc", 1000, 10000, 10, funding, address(2), 2)
+	assert.ErrorContains(t, err, "invalid coin ID")
+	_, _, err = builder.Deploy("NEWC", 1000, 10000, 10, funding, address(2), 2000)
+	assert.ErrorContains(t, err, "insufficient funds")
+}
+
+func TestMint(t *testing.T) {
+	db, builder := setup(t)
+	funding := []*Utxo{{Txid: txidC, Vout: 1, Value: 100000, Address: address(2)}}
+
+	packet, fee, err := builder.Mint("TESTC", types.NewAmount(3), address(3), funding, address(2), 1)
+	assert.NoError(t, err)
+	outs := packet.UnsignedTx.TxOut
+	assert.Len(t, outs, 3)
+	assert.Equal(t, int64(30000), outs[0].Value)
+	assert.Equal(t, int64(0), outs[1].Value)
+	assert.Equal(t, int64(70000)-fee, outs[2].Value)
+	_, balanceChangeEvents := parse(t, db, packet)
+	assert.Len(t, balanceChangeEvents, 1)
+	assert.Equal(t, address(3), balanceChangeEvents[0].Address)
+	assert.Equal(t, types.NewAmount(3), balanceChangeEvents[0].Delta)
+	assert.True(t, balanceChangeEvents[0].IsMint)
+
+	_, _, err = builder.Mint("TESTC", types.NewAmount(6), address(3), funding, address(2), 1)
+	assert.ErrorContains(t, err, "exceed mint limit")
+	_, _, err = builder.Mint("NOPE", types.NewAmount(1), address(3), funding, address(2), 1)
+	assert.ErrorContains(t, err, "coin ID not found: NOPE")
+	_, _, err = builder.Mint("TESTC", types.NewAmount(1), address(3), append(funding, &Utxo{Txid: txidB, Vout: 0, Value: 10000, Address: address(1)}), address(2), 1)
+	assert.ErrorContains(t, err, "which would be burnt")
+	_, _, err = builder.Mint("TESTC", types.NewAmount(1), "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", funding, address(2), 1)
+	assert.ErrorContains(t, err, "invalid address")
+}
+
+func TestTransfer(t *testing.T) {
+	db, builder := setup(t)
+	utxos := []*Utxo{
+		{Txid: txidA, Vout: 0, Value: 100000, Address: address(1)},
+		{Txid: txidC, Vout: 1, Value: 50000, Address: address(2)},
+	}
+
+	packet, fee, err := builder.Transfer("TESTC", []*Output{{Address: address(3), Amount: types.NewAmount(3)}, {Address: address(4), Amount: types.NewAmount(1)}}, utxos, address(2), 1)
+	assert.NoError(t, err)
+	outs := packet.UnsignedTx.TxOut
+	assert.Len(t, outs, 5)
+	assert.Equal(t, int64(30000), outs[0].Value)
+	assert.Equal(t, int64(10000), outs[1].Value)
+	assert.Equal(t, int64(60000), outs[2].Value) // Coins not transferred.
+	assert.Equal(t, int64(0), outs[3].Value)
+	assert.Equal(t, int64(50000)-fee, outs[4].Value)
+	_, balanceChangeEvents := parse(t, db, packet)
+	deltas := make(map[string]types.Amount)
+	for _, event := range balanceChangeEvents {
+		deltas[event.Address], _ = deltas[event.Address].Add(event.Delta)
+	}
+	assert.Equal(t, map[string]types.Amount{address(1): types.NewAmount(-10), address(2): types.NewAmount(6), address(3): types.NewAmount(3), address(4): types.NewAmount(1)}, deltas)
+
+	// All coins transferred, no coin change.
+	packet, _, err = builder.Transfer("TESTC", []*Output{{Address: address(3), Amount: types.NewAmount(10)}}, utxos, address(2), 1)
+	assert.NoError(t, err)
+	assert.Len(t, packet.UnsignedTx.TxOut, 3)
+
+	_, _, err = builder.Transfer("TESTC", []*Output{{Address: address(3), Amount: types.NewAmount(11)}}, utxos, address(2), 1)
+	assert.ErrorContains(t, err, "insufficient coins in inputs")
+	_, _, err = builder.Transfer("TESTC", []*Output{{Address: address(3), Amount: types.NewAmount(0)}}, utxos, address(2), 1)
+	assert.ErrorContains(t, err, "invalid amount")
+	_, _, err = builder.Transfer("TESTD", []*Output{{Address: address(3), Amount: types.NewAmount(1)}}, utxos, address(2), 1)
+	assert.ErrorContains(t, err, "which would be burnt")
+}
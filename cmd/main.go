@@ -6,18 +6,45 @@ import (
 	"github.com/alecthomas/kong"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/decentralize-everything/indexer/api"
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/extract/blkfile"
+	"github.com/decentralize-everything/indexer/extract/getblock"
 	"github.com/decentralize-everything/indexer/extract/mempool"
+	"github.com/decentralize-everything/indexer/extract/rawblock"
+	"github.com/decentralize-everything/indexer/extract/zmq"
 	"github.com/decentralize-everything/indexer/load"
+	"github.com/decentralize-everything/indexer/pending"
+	"github.com/decentralize-everything/indexer/pipeline"
 	"github.com/decentralize-everything/indexer/store"
 	"github.com/decentralize-everything/indexer/transform"
 	"go.uber.org/zap"
 )
 
 var cli struct {
-	Height     int    `help:"Starting block height" default:"823122"`
-	Network    string `help:"Network working on, support 'testnet' or 'mainnet'" default:"mainnet"`
-	Debug      bool   `help:"Enable debug mode"`
-	DbFilePath string `help:"Database file path, disable persistent store by using --db-file-path=\"\"" default:"./indexer.db"`
+	Height       int      `help:"Starting block height" default:"823122"`
+	Network      string   `help:"Network working on, support 'testnet' or 'mainnet'" default:"mainnet"`
+	Debug        bool     `help:"Enable debug mode"`
+	DbFilePath   string   `help:"Database file path, disable persistent store by using --db-file-path=\"\"" default:"./indexer.db"`
+	JournalDepth int      `help:"Number of latest blocks kept revertible for chain reorganizations" default:"100"`
+	Store        string   `help:"State store, 'memory' loads the whole state from the database file into memory, 'disk' reads it from the database file through a cache" enum:"memory,disk" default:"memory"`
+	CacheSize    int      `help:"Number of values cached by the 'disk' store" default:"100000"`
+	Protocols    []string `help:"Protocols to index, support 'carv', 'runes' and 'brc20'" default:"carv"`
+
+	Source          string        `help:"Block source, support 'mempool' (mempool.space API), 'bitcoind' (Bitcoin Core JSON-RPC), 'rest' (Bitcoin Core REST) or 'blkfile' (Bitcoin Core blocks directory)" enum:"mempool,bitcoind,rest,blkfile" default:"mempool"`
+	RawBlocks       bool          `help:"Fetch serialized blocks from 'mempool' or 'bitcoind' sources and decode them locally"`
+	RpcUrl          string        `help:"Bitcoin Core JSON-RPC URL" default:"http://127.0.0.1:8332"`
+	RpcUser         string        `help:"Bitcoin Core JSON-RPC user"`
+	RpcPassword     string        `help:"Bitcoin Core JSON-RPC password"`
+	RpcCookieFile   string        `help:"Bitcoin Core cookie file for JSON-RPC auth, used instead of user and password"`
+	RpcTimeout      time.Duration `help:"Timeout of each Bitcoin Core JSON-RPC or REST call" default:"30s"`
+	RestUrl         string        `help:"Bitcoin Core REST URL" default:"http://127.0.0.1:8332/rest"`
+	ZmqUrl          string        `help:"Bitcoin Core zmqpubhashblock endpoint notifying new blocks, e.g. tcp://127.0.0.1:28332"`
+	PollInterval    time.Duration `help:"Maximum interval of polling the block source for new blocks" default:"30s"`
+	PrefetchWindow  int           `help:"Number of blocks fetched ahead of the indexed height" default:"16"`
+	PrefetchWorkers int           `help:"Number of concurrent block fetches" default:"4"`
+	Mempool         bool          `help:"Track unconfirmed Carv activity, with 'mempool' or 'bitcoind' sources"`
+	MempoolInterval time.Duration `help:"Interval of fetching the mempool" default:"10s"`
+	BlocksDir       string        `help:"Bitcoin Core blocks directory, bitcoind must be stopped while indexing from it" default:"~/.bitcoin/blocks" type:"path"`
 }
 
 func main() {
@@ -39,9 +66,41 @@ func main() {
 	}
 
 	logger, _ := zap.NewDevelopment()
-	db := store.NewMemDb(cli.DbFilePath, cli.Network, cli.Debug, logger.Named("store"))
-	btcClient := mempool.NewBitcoinClient(params)
-	btcTransformer := transform.NewBitcoinTransformer(db, logger.Named("transform"))
+	var db store.Database
+	switch cli.Store {
+	case "memory":
+		db = store.NewMemDb(cli.DbFilePath, cli.Network, cli.JournalDepth, cli.Debug, logger.Named("store"))
+	case "disk":
+		if len(cli.DbFilePath) == 0 {
+			panic("the 'disk' store needs a database file path")
+		}
+		db = store.NewDiskDb(cli.DbFilePath, cli.Network, cli.JournalDepth, cli.CacheSize, logger.Named("store"))
+	}
+	var btcSource extract.BlockSource
+	var mempoolSource extract.MempoolSource
+	switch cli.Source {
+	case "bitcoind":
+		client := getblock.NewBitcoinClient(cli.RpcUrl, cli.RpcUser, cli.RpcPassword, cli.RpcCookieFile, cli.RpcTimeout)
+		btcSource, mempoolSource = client, client
+		if cli.RawBlocks {
+			btcSource = rawblock.NewBitcoinClient(client, params)
+		}
+	case "mempool":
+		client := mempool.NewBitcoinClient(params)
+		btcSource, mempoolSource = client, client
+		if cli.RawBlocks {
+			btcSource = rawblock.NewBitcoinClient(client, params)
+		}
+	case "rest":
+		btcSource = rawblock.NewBitcoinClient(rawblock.NewRestClient(cli.RestUrl, cli.RpcTimeout), params)
+	case "blkfile":
+		btcSource = blkfile.NewBitcoinClient(cli.BlocksDir, params)
+	}
+	var notifier extract.Notifier
+	if len(cli.ZmqUrl) > 0 {
+		notifier = zmq.NewNotifier(cli.ZmqUrl, logger.Named("zmq"))
+	}
+	btcTransformer := transform.NewBitcoinTransformer(db, cli.Protocols, logger.Named("transform"))
 	updater := load.NewDbUpdater(db, logger.Named("load"))
 
 	height, network, err := db.GetStatus()
@@ -55,36 +114,18 @@ func main() {
 		height = cli.Height
 	}
 
-	// Start http service.
-	router := api.SetupRouter(db)
-	go router.Run(":8080")
-
-	for {
-		blockHash, err := btcClient.GetBlockHash(height)
-		if err != nil {
-			logger.Warn("btcClient.GetBlockHash", zap.Error(err))
-			continue
-		}
-
-		block, err := btcClient.GetBlock(blockHash)
-		if err != nil {
-			// logger.Warn("btcClient.GetBlock", zap.Error(err))
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		batchUpdate, err := btcTransformer.Transform(block)
-		if err != nil {
-			logger.Warn("btcTransformer.Transform", zap.Error(err))
-			continue
+	var watcher *pending.Watcher
+	if cli.Mempool {
+		if mempoolSource == nil {
+			panic("mempool tracking needs the 'mempool' or 'bitcoind' source")
 		}
+		watcher = pending.NewWatcher(mempoolSource, db, cli.MempoolInterval, logger.Named("pending"))
+		go watcher.Run()
+	}
 
-		if err := updater.Update(batchUpdate); err != nil {
-			logger.Warn("updater.Update", zap.Error(err))
-			continue
-		}
+	// Start http service.
+	router := api.SetupRouter(db, watcher, params)
+	go router.Run(":8080")
 
-		logger.Debug("Block processed", zap.Int("height", height))
-		height++
-	}
+	pipeline.NewPipeline(btcSource, notifier, cli.PrefetchWindow, cli.PrefetchWorkers, cli.PollInterval, db, btcTransformer, updater, logger.Named("pipeline")).Run(height)
 }
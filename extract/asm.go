@@ -0,0 +1,78 @@
+package extract
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/txscript"
+)
+
+var opcodeNames = make(map[byte]string)
+
+func init() {
+	for name, op := range txscript.OpcodeByName {
+		switch name {
+		case "OP_FALSE", "OP_TRUE", "OP_NOP2", "OP_NOP3": // Aliases.
+			continue
+		}
+		opcodeNames[op] = name
+	}
+
+	// Names differing from btcd.
+	for op := byte(txscript.OP_1); op <= txscript.OP_16; op++ {
+		opcodeNames[op] = fmt.Sprintf("OP_PUSHNUM_%d", op-txscript.OP_1+1)
+	}
+	opcodeNames[txscript.OP_1NEGATE] = "OP_PUSHNUM_NEG1"
+	opcodeNames[txscript.OP_CHECKLOCKTIMEVERIFY] = "OP_CLTV"
+	opcodeNames[txscript.OP_CHECKSEQUENCEVERIFY] = "OP_CSV"
+	for op := byte(txscript.OP_CHECKSIGADD + 1); op < txscript.OP_INVALIDOPCODE; op++ {
+		opcodeNames[op] = fmt.Sprintf("OP_RETURN_%d", op)
+	}
+}
+
+// DisassembleScript formats the script the way mempool.space (esplora) does, e.g.
+// "OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405", which the protocol parsers match against.
+func DisassembleScript(script []byte) string {
+	var tokens []string
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+
+		var length, size int
+		switch op {
+		case txscript.OP_PUSHDATA1:
+			size = 1
+		case txscript.OP_PUSHDATA2:
+			size = 2
+		case txscript.OP_PUSHDATA4:
+			size = 4
+		}
+
+		switch {
+		case op >= txscript.OP_DATA_1 && op <= txscript.OP_DATA_75:
+			tokens = append(tokens, fmt.Sprintf("OP_PUSHBYTES_%d", op))
+			length = int(op)
+		case size > 0:
+			tokens = append(tokens, opcodeNames[op])
+			if i+size > len(script) {
+				return strings.Join(append(tokens, "<unexpected end>"), " ")
+			}
+			var bs [4]byte
+			copy(bs[:], script[i:i+size])
+			length = int(binary.LittleEndian.Uint32(bs[:]))
+			i += size
+		default:
+			tokens = append(tokens, opcodeNames[op])
+			continue
+		}
+
+		if length > len(script)-i {
+			return strings.Join(append(tokens, "<push past end>"), " ")
+		}
+		tokens = append(tokens, hex.EncodeToString(script[i:i+length]))
+		i += length
+	}
+	return strings.Join(tokens, " ")
+}
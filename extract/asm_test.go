@@ -0,0 +1,28 @@
+package extract
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDisassembleScript(t *testing.T) {
+	tests := []struct {
+		script string
+		asm    string
+	}{
+		{"6a01430382a405", "OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405"},
+		{"76a914751e76e8199196d454941c45d1b3a323f1433bd688ac", "OP_DUP OP_HASH160 OP_PUSHBYTES_20 751e76e8199196d454941c45d1b3a323f1433bd6 OP_EQUALVERIFY OP_CHECKSIG"},
+		{"5120" + "751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45", "OP_PUSHNUM_1 OP_PUSHBYTES_32 751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45"},
+		{"6a4c0100", "OP_RETURN OP_PUSHDATA1 00"},
+		{"00b1b2bb4f", "OP_0 OP_CLTV OP_CSV OP_RETURN_187 OP_PUSHNUM_NEG1"},
+		{"6a0301", "OP_RETURN OP_PUSHBYTES_3 <push past end>"},
+		{"6a4d01", "OP_RETURN OP_PUSHDATA2 <unexpected end>"},
+	}
+
+	for _, tt := range tests {
+		script, _ := hex.DecodeString(tt.script)
+		if asm := DisassembleScript(script); asm != tt.asm {
+			t.Fatalf("unexpected asm of %s: %s, expected: %s", tt.script, asm, tt.asm)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package blkfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/extract/rawblock"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+Layout of the Bitcoin Core blocks directory:
+  - index/ is a LevelDB, "b" + block hash -> CDiskBlockIndex, i.e. varints of client version, height, status,
+    number of transactions, file number, data position and undo position, then the 80-byte block header. File and
+    positions are only present if the status has the related flag.
+  - blk?????.dat files are sequences of network magic, 4-byte little endian size and serialized block. The data
+    position of the index points to the serialized block.
+  - xor.dat holds the 8-byte key, which all blk?????.dat bytes are XORed with by their offset. It doesn't exist before
+    Bitcoin Core v28, where nothing is XORed.
+
+The block index is loaded once, so blocks connected afterwards are not seen until the client is created again. The
+index can't be opened while bitcoind is running, stop it or index a copy of the blocks directory. Pruned blocks
+directories are not supported, since the chain must be complete from the genesis block.
+*/
+var (
+	BLOCK_VALID_SCRIPTS = uint64(5)
+	BLOCK_VALID_MASK    = uint64(7)
+	BLOCK_HAVE_DATA     = uint64(8)
+	BLOCK_HAVE_UNDO     = uint64(16)
+	BLOCK_FAILED_MASK   = uint64(32 | 64)
+)
+
+type blockIndex struct {
+	hash     chainhash.Hash
+	prevHash chainhash.Hash
+	height   int
+	bits     uint32
+	status   uint64
+	file     int
+	dataPos  int64
+}
+
+type BitcoinClient struct {
+	dir    string
+	params *chaincfg.Params
+	xorKey []byte
+	chain  []*blockIndex // Best chain, by height.
+	blocks map[string]*blockIndex
+}
+
+var _ extract.BlockSource = (*BitcoinClient)(nil)
+
+// NewBitcoinClient loads the block index of the Bitcoin Core blocks directory, and picks the fully validated chain
+// with the most work.
+func NewBitcoinClient(dir string, params *chaincfg.Params) *BitcoinClient {
+	xorKey, err := os.ReadFile(filepath.Join(dir, "xor.dat"))
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+
+	db, err := leveldb.OpenFile(filepath.Join(dir, "index"), &opt.Options{ReadOnly: true})
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	indexes := make(map[chainhash.Hash]*blockIndex)
+	iter := db.NewIterator(util.BytesPrefix([]byte{'b'}), nil)
+	for iter.Next() {
+		index, err := decodeBlockIndex(iter.Value())
+		if err != nil {
+			panic(fmt.Errorf("failed to decode block index %x: %v", iter.Key(), err))
+		}
+		if index.status&BLOCK_HAVE_DATA == 0 || index.status&BLOCK_FAILED_MASK != 0 || index.status&BLOCK_VALID_MASK < BLOCK_VALID_SCRIPTS {
+			continue
+		}
+		indexes[index.hash] = index
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+
+	chain := bestChain(indexes)
+	blocks := make(map[string]*blockIndex, len(chain))
+	for _, index := range chain {
+		blocks[index.hash.String()] = index
+	}
+
+	return &BitcoinClient{
+		dir:    dir,
+		params: params,
+		xorKey: xorKey,
+		chain:  chain,
+		blocks: blocks,
+	}
+}
+
+func (c *BitcoinClient) GetLatestBlockHeight() (int, error) {
+	if len(c.chain) == 0 {
+		return 0, fmt.Errorf("no block found in %s", c.dir)
+	}
+	return len(c.chain) - 1, nil
+}
+
+func (c *BitcoinClient) GetBlockHash(blockHeight int) (string, error) {
+	if blockHeight < 0 || blockHeight >= len(c.chain) {
+		return "", fmt.Errorf("block height %d out of range, latest = %d", blockHeight, len(c.chain)-1)
+	}
+	return c.chain[blockHeight].hash.String(), nil
+}
+
+func (c *BitcoinClient) GetBlock(blockHash string) (extract.Block, error) {
+	index, ok := c.blocks[blockHash]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found in the best chain", blockHash)
+	}
+
+	f, err := os.Open(filepath.Join(c.dir, fmt.Sprintf("blk%05d.dat", index.file)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Network magic and size come before the block.
+	prefix, err := c.readAt(f, index.dataPos-8, 8)
+	if err != nil {
+		return nil, err
+	}
+	if magic := wire.BitcoinNet(binary.LittleEndian.Uint32(prefix[:4])); magic != c.params.Net {
+		return nil, fmt.Errorf("unexpected network magic %v of block %s, expected %v", magic, blockHash, c.params.Net)
+	}
+	data, err := c.readAt(f, index.dataPos, int(binary.LittleEndian.Uint32(prefix[4:])))
+	if err != nil {
+		return nil, err
+	}
+
+	var msg wire.MsgBlock
+	if err := msg.Deserialize(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to deserialize block %s: %v", blockHash, err)
+	}
+	return rawblock.NewBlock(&msg, index.height, c.params), nil
+}
+
+func (c *BitcoinClient) readAt(f *os.File, offset int64, size int) ([]byte, error) {
+	bs := make([]byte, size)
+	if _, err := f.ReadAt(bs, offset); err != nil {
+		return nil, err
+	}
+	if len(c.xorKey) > 0 {
+		for i := range bs {
+			bs[i] ^= c.xorKey[(offset+int64(i))%int64(len(c.xorKey))]
+		}
+	}
+	return bs, nil
+}
+
+func decodeBlockIndex(bs []byte) (*blockIndex, error) {
+	r := bytes.NewReader(bs)
+	index := &blockIndex{}
+
+	var values [4]uint64 // Client version, height, status and number of transactions.
+	for i := range values {
+		v, err := readVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	index.height, index.status = int(values[1]), values[2]
+
+	if index.status&(BLOCK_HAVE_DATA|BLOCK_HAVE_UNDO) != 0 {
+		file, err := readVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+		index.file = int(file)
+	}
+	if index.status&BLOCK_HAVE_DATA != 0 {
+		pos, err := readVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+		index.dataPos = int64(pos)
+	}
+	if index.status&BLOCK_HAVE_UNDO != 0 {
+		if _, err := readVarInt(r); err != nil {
+			return nil, err
+		}
+	}
+
+	var header wire.BlockHeader
+	if err := header.Deserialize(r); err != nil {
+		return nil, err
+	}
+	index.hash = header.BlockHash()
+	index.prevHash = header.PrevBlock
+	index.bits = header.Bits
+	return index, nil
+}
+
+// readVarInt reads the variable length integer of Bitcoin Core's serialize.h, which differs from the CompactSize of
+// the P2P protocol.
+func readVarInt(r *bytes.Reader) (uint64, error) {
+	n := uint64(0)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<7 | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			return n, nil
+		}
+		n++
+	}
+}
+
+// bestChain returns the chain ending at the block with the most accumulated work.
+func bestChain(indexes map[chainhash.Hash]*blockIndex) []*blockIndex {
+	sorted := make([]*blockIndex, 0, len(indexes))
+	for _, index := range indexes {
+		sorted = append(sorted, index)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].height < sorted[j].height
+	})
+
+	// Parents come first, the work of blocks not connected to the genesis block is ignored.
+	work := make(map[chainhash.Hash]*big.Int)
+	var tip *blockIndex
+	for _, index := range sorted {
+		w := blockchain.CalcWork(index.bits)
+		if index.height > 0 {
+			parent, ok := work[index.prevHash]
+			if !ok {
+				continue
+			}
+			w.Add(w, parent)
+		}
+		work[index.hash] = w
+		if tip == nil || w.Cmp(work[tip.hash]) > 0 {
+			tip = index
+		}
+	}
+	if tip == nil {
+		return nil
+	}
+
+	chain := make([]*blockIndex, tip.height+1)
+	for index := tip; index != nil; index = indexes[index.prevHash] {
+		chain[index.height] = index
+		if index.height == 0 {
+			break
+		}
+	}
+	return chain
+}
@@ -0,0 +1,168 @@
+package blkfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func writeVarInt(buf *bytes.Buffer, n uint64) {
+	var tmp []byte
+	for i := 0; ; i++ {
+		b := byte(n & 0x7f)
+		if i > 0 {
+			b |= 0x80
+		}
+		tmp = append([]byte{b}, tmp...)
+		if n <= 0x7f {
+			break
+		}
+		n = n>>7 - 1
+	}
+	buf.Write(tmp)
+}
+
+func newTestBlock(prev *wire.MsgBlock, txs ...*wire.MsgTx) *wire.MsgBlock {
+	block := wire.NewMsgBlock(wire.NewBlockHeader(1, &chainhash.Hash{}, &chainhash.Hash{}, prev.Header.Bits, 0))
+	block.Header.PrevBlock = prev.BlockHash()
+	block.Header.Timestamp = prev.Header.Timestamp.Add(10 * time.Minute)
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), []byte{byte(len(prev.Transactions)), 0}, nil))
+	coinbase.AddTxOut(wire.NewTxOut(5000000000, []byte{0x51}))
+	block.AddTransaction(coinbase)
+	for _, tx := range txs {
+		block.AddTransaction(tx)
+	}
+	merkles := blockchain.BuildMerkleTreeStore(btcutil.NewBlock(block).Transactions(), false)
+	block.Header.MerkleRoot = *merkles[len(merkles)-1]
+	return block
+}
+
+// writeBlocksDir writes the blocks into blk00000.dat and the block index, with the given status and height of each.
+// All the statuses should have undo data.
+func writeBlocksDir(t *testing.T, xorKey []byte, blocks []*wire.MsgBlock, heights []int, statuses []uint64) string {
+	dir := t.TempDir()
+	if len(xorKey) > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "xor.dat"), xorKey, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := leveldb.OpenFile(filepath.Join(dir, "index"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file bytes.Buffer
+	for i, block := range blocks {
+		var data bytes.Buffer
+		if err := block.Serialize(&data); err != nil {
+			t.Fatal(err)
+		}
+		binary.Write(&file, binary.LittleEndian, uint32(chaincfg.RegressionNetParams.Net))
+		binary.Write(&file, binary.LittleEndian, uint32(data.Len()))
+		dataPos := file.Len()
+		file.Write(data.Bytes())
+
+		var index bytes.Buffer
+		for _, v := range []uint64{259900, uint64(heights[i]), statuses[i], uint64(len(block.Transactions)), 0, uint64(dataPos), 0} {
+			writeVarInt(&index, v)
+		}
+		if err := block.Header.Serialize(&index); err != nil {
+			t.Fatal(err)
+		}
+		hash := block.BlockHash()
+		if err := db.Put(append([]byte{'b'}, hash[:]...), index.Bytes(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	bs := file.Bytes()
+	for i := range bs {
+		if len(xorKey) > 0 {
+			bs[i] ^= xorKey[i%len(xorKey)]
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "blk00000.dat"), bs, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReadVarInt(t *testing.T) {
+	for _, n := range []uint64{0, 127, 128, 255, 16511, 16512, 259900, 1 << 40} {
+		var buf bytes.Buffer
+		writeVarInt(&buf, n)
+		v, err := readVarInt(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, n, v)
+	}
+
+	// 0x80 0x00 is 128 for Bitcoin Core.
+	v, _ := readVarInt(bytes.NewReader([]byte{0x80, 0x00}))
+	assert.Equal(t, uint64(128), v)
+}
+
+func TestBitcoinClient(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	genesis := params.GenesisBlock
+
+	prevTxid := genesis.Transactions[0].TxHash()
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevTxid, 0), nil, wire.TxWitness{{0x01, 0x02}, {0x03}}))
+	tx.AddTxOut(wire.NewTxOut(10000, append([]byte{0x00, 0x14}, bytes.Repeat([]byte{0x75}, 20)...)))
+	tx.AddTxOut(wire.NewTxOut(0, []byte{0x6a, 0x01, 0x43, 0x03, 0x82, 0xa4, 0x05}))
+	block1 := newTestBlock(genesis, tx)
+	block2 := newTestBlock(block1)
+	stale2 := newTestBlock(block1, tx)
+	stale3 := newTestBlock(stale2)
+
+	validScripts := BLOCK_VALID_SCRIPTS | BLOCK_HAVE_DATA | BLOCK_HAVE_UNDO
+	blocks := []*wire.MsgBlock{genesis, block1, block2, stale2, stale3}
+	heights := []int{0, 1, 2, 2, 3}
+	// The stale chain is longer, but its first block is invalid.
+	statuses := []uint64{validScripts, validScripts, validScripts, validScripts | 32, validScripts}
+
+	for _, xorKey := range [][]byte{nil, {0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}} {
+		client := NewBitcoinClient(writeBlocksDir(t, xorKey, blocks, heights, statuses), params)
+
+		height, err := client.GetLatestBlockHeight()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, height)
+
+		hash, err := client.GetBlockHash(1)
+		assert.Nil(t, err)
+		assert.Equal(t, block1.BlockHash().String(), hash)
+		_, err = client.GetBlockHash(3)
+		assert.NotNil(t, err)
+		_, err = client.GetBlock(stale2.BlockHash().String())
+		assert.NotNil(t, err)
+
+		block, err := client.GetBlock(hash)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, block.GetHeight())
+		assert.Equal(t, genesis.BlockHash().String(), block.GetPrevHash())
+		assert.Equal(t, int(block1.Header.Timestamp.Unix()), block.GetTime())
+		assert.Equal(t, 2, len(block.GetTxs()))
+
+		parsed := block.GetTxs()[1]
+		assert.Equal(t, tx.TxHash().String(), parsed.GetTxid())
+		assert.Equal(t, genesis.Transactions[0].TxHash().String(), parsed.GetVin()[0].GetTxid())
+		assert.Equal(t, []string{"0102", "03"}, parsed.GetVin()[0].GetWitness())
+		assert.Equal(t, float64(10000), parsed.GetVout()[0].GetValue())
+		assert.Equal(t, "bcrt1qw46h2at4w46h2at4w46h2at4w46h2at4zdn3gn", parsed.GetVout()[0].GetAddress())
+		assert.Equal(t, "OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405", parsed.GetVout()[1].GetAsm())
+		assert.Equal(t, "", parsed.GetVout()[1].GetAddress())
+	}
+}
@@ -2,36 +2,121 @@ package getblock
 
 import (
 	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/extract/rawblock"
 	"github.com/ybbus/jsonrpc/v3"
 )
 
 type BitcoinClient struct {
-	client jsonrpc.RPCClient
+	client  jsonrpc.RPCClient
+	timeout time.Duration
 }
 
-func NewBitcoinClient(url string) *BitcoinClient {
+var _ extract.BlockSource = (*BitcoinClient)(nil)
+var _ rawblock.RawBlockSource = (*BitcoinClient)(nil)
+var _ extract.MempoolSource = (*BitcoinClient)(nil)
+
+// NewBitcoinClient creates a JSON-RPC client of Bitcoin Core, authenticated by user and password, or by the cookie
+// file if given. A zero timeout means no timeout.
+func NewBitcoinClient(url, user, password, cookieFile string, timeout time.Duration) *BitcoinClient {
 	return &BitcoinClient{
-		client: jsonrpc.NewClient(url),
+		client: jsonrpc.NewClientWithOpts(url, &jsonrpc.RPCClientOpts{
+			HTTPClient: &http.Client{
+				Transport: &authTransport{
+					user:       user,
+					password:   password,
+					cookieFile: cookieFile,
+					base:       http.DefaultTransport,
+				},
+			},
+		}),
+		timeout: timeout,
+	}
+}
+
+// authTransport sets the basic auth of every request. The cookie file is read every time, since Bitcoin Core
+// rewrites it on each restart.
+type authTransport struct {
+	user       string
+	password   string
+	cookieFile string
+	base       http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	user, password := t.user, t.password
+	if len(t.cookieFile) > 0 {
+		cookie, err := os.ReadFile(t.cookieFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cookie file: %v", err)
+		}
+		user, password, _ = strings.Cut(strings.TrimSpace(string(cookie)), ":")
+	}
+
+	if len(user) > 0 || len(password) > 0 {
+		req = req.Clone(req.Context())
+		req.SetBasicAuth(user, password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (c *BitcoinClient) call(result interface{}, method string, params ...interface{}) error {
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
 	}
+	return c.client.CallFor(ctx, result, method, params...)
 }
 
 func (c *BitcoinClient) GetLatestBlockHeight() (int, error) {
 	var result int
-	err := c.client.CallFor(context.Background(), &result, "getblockcount")
+	err := c.call(&result, "getblockcount")
 	return result, err
 }
 
 func (c *BitcoinClient) GetBlockHash(blockHeight int) (string, error) {
 	var result string
-	err := c.client.CallFor(context.Background(), &result, "getblockhash", blockHeight)
+	err := c.call(&result, "getblockhash", blockHeight)
 	return result, err
 }
 
-func (c *BitcoinClient) GetBlock(blockHash string) (*Block, error) {
-	// func (c *BitcoinClient) GetBlock(blockHash string) (map[string]interface{}, error) {
+func (c *BitcoinClient) GetBlock(blockHash string) (extract.Block, error) {
 	var result Block
-	// var result map[string]interface{}
-	err := c.client.CallFor(context.Background(), &result, "getblock", blockHash, 2)
-	return &result, err
+	if err := c.call(&result, "getblock", blockHash, 2); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetRawBlock returns the serialized block, i.e. getblock with verbosity 0.
+func (c *BitcoinClient) GetRawBlock(blockHash string) ([]byte, error) {
+	var result string
+	if err := c.call(&result, "getblock", blockHash, 0); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(result)
+}
+
+func (c *BitcoinClient) GetMempoolTxids() ([]string, error) {
+	var result []string
+	err := c.call(&result, "getrawmempool")
+	return result, err
+}
+
+// GetTransaction needs -txindex for confirmed transactions, unconfirmed ones are always found.
+func (c *BitcoinClient) GetTransaction(txid string) (extract.Transaction, error) {
+	var result Transaction
+	if err := c.call(&result, "getrawtransaction", txid, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
 }
@@ -1,9 +1,18 @@
 package getblock
 
-import "testing"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestBitcoinClient(t *testing.T) {
-	client := NewBitcoinClient("https://go.getblock.io/3c4caebe755b4838838044df4cb08a99")
+	client := NewBitcoinClient("https://go.getblock.io/3c4caebe755b4838838044df4cb08a99", "", "", "", 30*time.Second)
 	// blockCount, err := client.GetBlockCount()
 	// if err != nil {
 	// 	t.Fatal(err)
@@ -25,10 +34,135 @@ func TestBitcoinClient(t *testing.T) {
 }
 
 func TestGetLatestBlockHeight(t *testing.T) {
-	client := NewBitcoinClient("https://go.getblock.io/3c4caebe755b4838838044df4cb08a99")
+	client := NewBitcoinClient("https://go.getblock.io/3c4caebe755b4838838044df4cb08a99", "", "", "", 30*time.Second)
 	blockCount, err := client.GetLatestBlockHeight()
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Log(blockCount)
 }
+
+// newTestServer serves getblockcount and a single block, checking the basic auth of each request.
+func newTestServer(t *testing.T, user string, password string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Id     int    `json:"id"`
+			Method string `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var result string
+		switch req.Method {
+		case "getblockcount":
+			result = `823122`
+		case "getrawmempool":
+			result = `["1234"]`
+		case "getrawtransaction":
+			result = `{
+				"txid": "1234",
+				"vin": [{"txid": "5678", "vout": 1}],
+				"vout": [{"value": 0.0001, "scriptPubKey": {"address": "bc1q", "hex": "0014751e76e8199196d454941c45d1b3a323f1433bd6"}}]
+			}`
+		case "getblock":
+			result = `{
+				"hash": "h2",
+				"previousblockhash": "h1",
+				"time": 1700000000,
+				"height": 823122,
+				"tx": [{
+					"txid": "1234",
+					"vin": [{"txid": "5678", "vout": 1, "txinwitness": ["00"]}],
+					"vout": [
+						{"value": 0.0001, "scriptPubKey": {"address": "bc1q", "hex": "0014751e76e8199196d454941c45d1b3a323f1433bd6"}},
+						{"value": 0, "scriptPubKey": {"hex": "6a01430382a405"}}
+					]
+				}]
+			}`
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":` + fmt.Sprint(req.Id) + `,"result":` + result + `}`))
+	}))
+}
+
+func TestBitcoinClientAuth(t *testing.T) {
+	server := newTestServer(t, "user", "password")
+	defer server.Close()
+
+	height, err := NewBitcoinClient(server.URL, "user", "password", "", time.Second).GetLatestBlockHeight()
+	if err != nil || height != 823122 {
+		t.Fatalf("unexpected result: %d, %v", height, err)
+	}
+
+	if _, err := NewBitcoinClient(server.URL, "user", "wrong", "", time.Second).GetLatestBlockHeight(); err == nil {
+		t.Fatal("wrong password should be rejected")
+	}
+}
+
+func TestBitcoinClientCookieFile(t *testing.T) {
+	server := newTestServer(t, "__cookie__", "secret")
+	defer server.Close()
+
+	cookieFile := filepath.Join(t.TempDir(), ".cookie")
+	client := NewBitcoinClient(server.URL, "", "", cookieFile, time.Second)
+	if _, err := client.GetLatestBlockHeight(); err == nil {
+		t.Fatal("missing cookie file should fail")
+	}
+
+	// The cookie is read again after Bitcoin Core restarts.
+	if err := os.WriteFile(cookieFile, []byte("__cookie__:secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if height, err := client.GetLatestBlockHeight(); err != nil || height != 823122 {
+		t.Fatalf("unexpected result: %d, %v", height, err)
+	}
+}
+
+func TestBitcoinClientGetBlockFormat(t *testing.T) {
+	server := newTestServer(t, "user", "password")
+	defer server.Close()
+
+	block, err := NewBitcoinClient(server.URL, "user", "password", "", time.Second).GetBlock("h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block.GetHash() != "h2" || block.GetPrevHash() != "h1" || block.GetHeight() != 823122 || len(block.GetTxs()) != 1 {
+		t.Fatalf("unexpected block: %v", block)
+	}
+	tx := block.GetTxs()[0]
+	if tx.GetVin()[0].GetWitness()[0] != "00" {
+		t.Fatalf("unexpected witness: %v", tx.GetVin()[0].GetWitness())
+	}
+	vouts := tx.GetVout()
+	if vouts[0].GetValue() != 10000 || vouts[0].GetAsm() != "OP_0 OP_PUSHBYTES_20 751e76e8199196d454941c45d1b3a323f1433bd6" {
+		t.Fatalf("unexpected output: %v, %s", vouts[0].GetValue(), vouts[0].GetAsm())
+	}
+	if vouts[1].GetAsm() != "OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405" {
+		t.Fatalf("unexpected output: %s", vouts[1].GetAsm())
+	}
+}
+
+func TestBitcoinClientMempool(t *testing.T) {
+	server := newTestServer(t, "user", "password")
+	defer server.Close()
+	client := NewBitcoinClient(server.URL, "user", "password", "", time.Second)
+
+	txids, err := client.GetMempoolTxids()
+	if err != nil || len(txids) != 1 || txids[0] != "1234" {
+		t.Fatalf("unexpected txids: %v, %v", txids, err)
+	}
+
+	tx, err := client.GetTransaction("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.GetTxid() != "1234" || tx.GetVin()[0].GetTxid() != "5678" || tx.GetVout()[0].GetValue() != 10000 || tx.GetVout()[0].GetAddress() != "bc1q" {
+		t.Fatalf("unexpected tx: %v", tx)
+	}
+}
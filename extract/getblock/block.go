@@ -1,12 +1,16 @@
 package getblock
 
 import (
+	"encoding/hex"
+	"math"
+
 	"github.com/decentralize-everything/indexer/extract"
 )
 
 type Vin struct {
-	Txid string `json:"txid"`
-	Vout int    `json:"vout"`
+	Txid    string   `json:"txid"`
+	Vout    int      `json:"vout"`
+	Witness []string `json:"txinwitness"`
 }
 
 var _ extract.Vin = (*Vin)(nil)
@@ -19,26 +23,36 @@ func (v *Vin) GetVout() int {
 	return v.Vout
 }
 
+func (v *Vin) GetWitness() []string {
+	return v.Witness
+}
+
 type Vout struct {
-	Value        float64 `json:"value"`
+	Value        float64 `json:"value"` // In BTC.
 	ScriptPubKey struct {
 		Address string `json:"address"`
-		Asm     string `json:"asm"`
+		Hex     string `json:"hex"`
 	} `json:"scriptPubKey"`
 }
 
 var _ extract.Vout = (*Vout)(nil)
 
 func (v *Vout) GetValue() float64 {
-	return v.Value
+	return math.Round(v.Value * 1e8)
 }
 
 func (v *Vout) GetAddress() string {
 	return v.ScriptPubKey.Address
 }
 
+// GetAsm disassembles the script locally, since the ASM of Bitcoin Core is formatted differently from the one the
+// protocol parsers expect.
 func (v *Vout) GetAsm() string {
-	return v.ScriptPubKey.Asm
+	script, err := hex.DecodeString(v.ScriptPubKey.Hex)
+	if err != nil {
+		return ""
+	}
+	return extract.DisassembleScript(script)
 }
 
 type Transaction struct {
@@ -70,10 +84,11 @@ func (t *Transaction) GetVout() []extract.Vout {
 }
 
 type Block struct {
-	Hash   string        `json:"hash"`
-	Time   int           `json:"time"`
-	Height int           `json:"height"`
-	Tx     []Transaction `json:"tx"`
+	Hash     string        `json:"hash"`
+	PrevHash string        `json:"previousblockhash"`
+	Time     int           `json:"time"`
+	Height   int           `json:"height"`
+	Tx       []Transaction `json:"tx"`
 }
 
 var _ extract.Block = (*Block)(nil)
@@ -82,6 +97,10 @@ func (b *Block) GetHash() string {
 	return b.Hash
 }
 
+func (b *Block) GetPrevHash() string {
+	return b.PrevHash
+}
+
 func (b *Block) GetTime() int {
 	return b.Time
 }
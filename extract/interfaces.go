@@ -3,10 +3,11 @@ package extract
 type Vin interface {
 	GetTxid() string
 	GetVout() int
+	GetWitness() []string // Hex encoded witness items.
 }
 
 type Vout interface {
-	GetValue() float64
+	GetValue() float64 // In satoshis.
 	GetAddress() string
 	GetAsm() string
 }
@@ -19,7 +20,27 @@ type Transaction interface {
 
 type Block interface {
 	GetHash() string
+	GetPrevHash() string
 	GetTime() int
 	GetHeight() int
 	GetTxs() []Transaction
 }
+
+// BlockSource fetches blocks from a Bitcoin data source.
+type BlockSource interface {
+	GetLatestBlockHeight() (int, error)
+	GetBlockHash(height int) (string, error)
+	GetBlock(hash string) (Block, error)
+}
+
+// Notifier signals that a new block may be available, so that the tip doesn't have to be polled often. Signals may be
+// dropped or spurious, the block source is the source of truth.
+type Notifier interface {
+	Notify() <-chan struct{}
+}
+
+// MempoolSource fetches unconfirmed transactions.
+type MempoolSource interface {
+	GetMempoolTxids() ([]string, error)
+	GetTransaction(txid string) (Transaction, error)
+}
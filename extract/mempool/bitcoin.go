@@ -2,30 +2,82 @@ package mempool
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/extract/rawblock"
+	"github.com/vincentdebug/go-ord-tx/pkg/btcapi"
 	"github.com/vincentdebug/go-ord-tx/pkg/btcapi/mempool"
 )
 
 type BitcoinClient struct {
-	client *mempool.MempoolClient
+	client  *mempool.MempoolClient
+	baseURL string
 }
 
+var _ extract.BlockSource = (*BitcoinClient)(nil)
+var _ rawblock.RawBlockSource = (*BitcoinClient)(nil)
+var _ extract.MempoolSource = (*BitcoinClient)(nil)
+
 func NewBitcoinClient(params *chaincfg.Params) *BitcoinClient {
 	return &BitcoinClient{
-		client: mempool.NewClient(params),
+		client:  mempool.NewClient(params),
+		baseURL: baseURL(params),
+	}
+}
+
+// baseURL mirrors the endpoint selection of mempool.NewClient, which doesn't expose it.
+func baseURL(params *chaincfg.Params) string {
+	switch params.Net {
+	case wire.MainNet:
+		return "https://mempool.space/api"
+	case wire.TestNet3:
+		return "https://mempool.space/testnet/api"
+	case chaincfg.SigNetParams.Net:
+		return "https://mempool.space/signet/api"
+	default:
+		return ""
 	}
 }
 
 func (c *BitcoinClient) GetLatestBlockHeight() (int, error) {
-	panic("implement me")
+	res, err := btcapi.Request(http.MethodGet, c.baseURL, "/blocks/tip/height", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	height, err := strconv.Atoi(strings.TrimSpace(string(res)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode tip height: %s", string(res))
+	}
+	return height, nil
 }
 
 func (c *BitcoinClient) GetBlockHash(blockHeight int) (string, error) {
 	return c.client.GetBlockHash(blockHeight)
 }
 
-func (c *BitcoinClient) GetBlock(blockHash string) (*Block, error) {
+func (c *BitcoinClient) GetBlock(blockHash string) (extract.Block, error) {
+	res, err := btcapi.Request(http.MethodGet, c.baseURL, fmt.Sprintf("/block/%s", blockHash), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		Id                string `json:"id"`
+		Height            int    `json:"height"`
+		Timestamp         int    `json:"timestamp"`
+		PreviousBlockHash string `json:"previousblockhash"`
+	}
+	if err := json.Unmarshal(res, &header); err != nil {
+		return nil, fmt.Errorf("failed to decode block %s: %s", blockHash, string(res))
+	}
+
 	transactions, err := c.client.GetTransactions(blockHash)
 	if err != nil {
 		return nil, err
@@ -36,15 +88,42 @@ func (c *BitcoinClient) GetBlock(blockHash string) (*Block, error) {
 		return nil, err
 	}
 
-	var j []map[string]interface{}
-	if err := json.Unmarshal([]byte(transactions), &j); err != nil {
+	return &Block{
+		Hash:     header.Id,
+		PrevHash: header.PreviousBlockHash,
+		Time:     header.Timestamp,
+		Height:   header.Height,
+		Tx:       txs,
+	}, nil
+}
+
+// GetRawBlock returns the serialized block.
+func (c *BitcoinClient) GetRawBlock(blockHash string) ([]byte, error) {
+	return btcapi.Request(http.MethodGet, c.baseURL, fmt.Sprintf("/block/%s/raw", blockHash), nil)
+}
+
+func (c *BitcoinClient) GetMempoolTxids() ([]string, error) {
+	res, err := btcapi.Request(http.MethodGet, c.baseURL, "/mempool/txids", nil)
+	if err != nil {
 		return nil, err
 	}
 
-	return &Block{
-		Hash:   j[0]["status"].(map[string]interface{})["block_hash"].(string),
-		Time:   int(j[0]["status"].(map[string]interface{})["block_time"].(float64)),
-		Height: int(j[0]["status"].(map[string]interface{})["block_height"].(float64)),
-		Tx:     txs,
-	}, nil
+	var txids []string
+	if err := json.Unmarshal(res, &txids); err != nil {
+		return nil, fmt.Errorf("failed to decode mempool txids: %v", err)
+	}
+	return txids, nil
+}
+
+func (c *BitcoinClient) GetTransaction(txid string) (extract.Transaction, error) {
+	res, err := btcapi.Request(http.MethodGet, c.baseURL, fmt.Sprintf("/tx/%s", txid), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var tx Transaction
+	if err := json.Unmarshal(res, &tx); err != nil {
+		return nil, fmt.Errorf("failed to decode tx %s: %s", txid, string(res))
+	}
+	return &tx, nil
 }
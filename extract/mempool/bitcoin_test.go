@@ -20,3 +20,12 @@ func TestBitcoinClient(t *testing.T) {
 	}
 	t.Log(block)
 }
+
+func TestGetLatestBlockHeight(t *testing.T) {
+	client := NewBitcoinClient(&chaincfg.MainNetParams)
+	height, err := client.GetLatestBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(height)
+}
@@ -5,8 +5,9 @@ import (
 )
 
 type Vin struct {
-	Txid string `json:"txid"`
-	Vout int    `json:"vout"`
+	Txid    string   `json:"txid"`
+	Vout    int      `json:"vout"`
+	Witness []string `json:"witness"`
 }
 
 var _ extract.Vin = (*Vin)(nil)
@@ -19,6 +20,10 @@ func (v *Vin) GetVout() int {
 	return v.Vout
 }
 
+func (v *Vin) GetWitness() []string {
+	return v.Witness
+}
+
 type Vout struct {
 	Value   float64 `json:"value"`
 	Address string  `json:"scriptpubkey_address"`
@@ -68,10 +73,11 @@ func (t *Transaction) GetVout() []extract.Vout {
 }
 
 type Block struct {
-	Hash   string
-	Time   int
-	Height int
-	Tx     []Transaction
+	Hash     string
+	PrevHash string
+	Time     int
+	Height   int
+	Tx       []Transaction
 }
 
 var _ extract.Block = (*Block)(nil)
@@ -80,6 +86,10 @@ func (b *Block) GetHash() string {
 	return b.Hash
 }
 
+func (b *Block) GetPrevHash() string {
+	return b.PrevHash
+}
+
 func (b *Block) GetTime() int {
 	return b.Time
 }
@@ -0,0 +1,87 @@
+package rawblock
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/decentralize-everything/indexer/extract"
+)
+
+// RawBlockSource fetches serialized blocks, e.g. getblock with verbosity 0 or the REST .bin endpoint.
+type RawBlockSource interface {
+	GetLatestBlockHeight() (int, error)
+	GetBlockHash(height int) (string, error)
+	GetRawBlock(hash string) ([]byte, error)
+}
+
+// BitcoinClient deserializes the blocks of a RawBlockSource locally, so that ASM and addresses of outputs don't
+// depend on the formatting of the data provider.
+type BitcoinClient struct {
+	source  RawBlockSource
+	params  *chaincfg.Params
+	mu      sync.Mutex
+	heights map[string]int // Heights of the hashes returned by GetBlockHash, until the blocks are fetched.
+}
+
+var _ extract.BlockSource = (*BitcoinClient)(nil)
+
+func NewBitcoinClient(source RawBlockSource, params *chaincfg.Params) *BitcoinClient {
+	return &BitcoinClient{
+		source:  source,
+		params:  params,
+		heights: make(map[string]int),
+	}
+}
+
+func (c *BitcoinClient) GetLatestBlockHeight() (int, error) {
+	return c.source.GetLatestBlockHeight()
+}
+
+func (c *BitcoinClient) GetBlockHash(blockHeight int) (string, error) {
+	hash, err := c.source.GetBlockHash(blockHeight)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.heights[hash] = blockHeight
+	c.mu.Unlock()
+	return hash, nil
+}
+
+func (c *BitcoinClient) GetBlock(blockHash string) (extract.Block, error) {
+	raw, err := c.source.GetRawBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg wire.MsgBlock
+	if err := msg.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("failed to deserialize block %s: %v", blockHash, err)
+	}
+	if hash := msg.BlockHash().String(); hash != blockHash {
+		return nil, fmt.Errorf("unexpected block %s, expected %s", hash, blockHash)
+	}
+
+	// A serialized block has no height, take it from GetBlockHash, or from the coinbase since BIP34.
+	c.mu.Lock()
+	height, ok := c.heights[blockHash]
+	delete(c.heights, blockHash)
+	c.mu.Unlock()
+	if !ok {
+		if msg.Header.Version < 2 || len(msg.Transactions) == 0 {
+			return nil, fmt.Errorf("unknown height of block %s", blockHash)
+		}
+		h, err := blockchain.ExtractCoinbaseHeight(btcutil.NewTx(msg.Transactions[0]))
+		if err != nil {
+			return nil, fmt.Errorf("unknown height of block %s: %v", blockHash, err)
+		}
+		height = int(h)
+	}
+	return NewBlock(&msg, height, c.params), nil
+}
@@ -0,0 +1,81 @@
+package rawblock
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRestServer serves a chain of a single block at the given height, which has the BIP34 height in its coinbase.
+func newRestServer(t *testing.T, height int) (*httptest.Server, *wire.MsgBlock) {
+	scriptSig, _ := txscript.NewScriptBuilder().AddInt64(int64(height)).Script()
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), scriptSig, nil))
+	coinbase.AddTxOut(wire.NewTxOut(10000, []byte{0x6a, 0x01, 0x43, 0x03, 0x82, 0xa4, 0x05}))
+	block := wire.NewMsgBlock(wire.NewBlockHeader(2, &chainhash.Hash{0x01}, &chainhash.Hash{}, 0x1d00ffff, 0))
+	block.AddTransaction(coinbase)
+
+	var raw bytes.Buffer
+	if err := block.Serialize(&raw); err != nil {
+		t.Fatal(err)
+	}
+	hash := block.BlockHash().String()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/chaininfo.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"chain":"main","blocks":%d}`, height)
+	})
+	mux.HandleFunc(fmt.Sprintf("/rest/blockhashbyheight/%d.hex", height), func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, hash)
+	})
+	mux.HandleFunc(fmt.Sprintf("/rest/block/%s.bin", hash), func(w http.ResponseWriter, r *http.Request) {
+		w.Write(raw.Bytes())
+	})
+	return httptest.NewServer(mux), block
+}
+
+func TestRestClient(t *testing.T) {
+	server, block := newRestServer(t, 823122)
+	defer server.Close()
+	client := NewBitcoinClient(NewRestClient(server.URL+"/rest/", time.Second), &chaincfg.MainNetParams)
+
+	height, err := client.GetLatestBlockHeight()
+	assert.Nil(t, err)
+	assert.Equal(t, 823122, height)
+
+	hash, err := client.GetBlockHash(823122)
+	assert.Nil(t, err)
+	assert.Equal(t, block.BlockHash().String(), hash)
+	_, err = client.GetBlockHash(823123)
+	assert.NotNil(t, err)
+
+	b, err := client.GetBlock(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, 823122, b.GetHeight())
+	assert.Equal(t, (&chainhash.Hash{0x01}).String(), b.GetPrevHash())
+	assert.Equal(t, float64(10000), b.GetTxs()[0].GetVout()[0].GetValue())
+	assert.Equal(t, "OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405", b.GetTxs()[0].GetVout()[0].GetAsm())
+}
+
+func TestBitcoinClientCoinbaseHeight(t *testing.T) {
+	server, block := newRestServer(t, 823122)
+	defer server.Close()
+	client := NewBitcoinClient(NewRestClient(server.URL+"/rest", time.Second), &chaincfg.MainNetParams)
+
+	// Without GetBlockHash, the height comes from the coinbase.
+	b, err := client.GetBlock(block.BlockHash().String())
+	assert.Nil(t, err)
+	assert.Equal(t, 823122, b.GetHeight())
+
+	_, err = client.GetBlock((&chainhash.Hash{0x02}).String())
+	assert.NotNil(t, err)
+}
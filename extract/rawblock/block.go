@@ -0,0 +1,189 @@
+package rawblock
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/decentralize-everything/indexer/extract"
+)
+
+type Vin struct {
+	Txid    string
+	Vout    int
+	Witness []string
+}
+
+var _ extract.Vin = (*Vin)(nil)
+
+func (v *Vin) GetTxid() string {
+	return v.Txid
+}
+
+func (v *Vin) GetVout() int {
+	return v.Vout
+}
+
+func (v *Vin) GetWitness() []string {
+	return v.Witness
+}
+
+type Vout struct {
+	Value   float64
+	Address string
+	Asm     string
+}
+
+var _ extract.Vout = (*Vout)(nil)
+
+func (v *Vout) GetValue() float64 {
+	return v.Value
+}
+
+func (v *Vout) GetAddress() string {
+	return v.Address
+}
+
+func (v *Vout) GetAsm() string {
+	return v.Asm
+}
+
+type Transaction struct {
+	Txid string
+	Vin  []Vin
+	Vout []Vout
+}
+
+var _ extract.Transaction = (*Transaction)(nil)
+
+func (t *Transaction) GetTxid() string {
+	return t.Txid
+}
+
+func (t *Transaction) GetVin() []extract.Vin {
+	vins := make([]extract.Vin, len(t.Vin))
+	for i := range t.Vin {
+		vins[i] = &t.Vin[i]
+	}
+	return vins
+}
+
+func (t *Transaction) GetVout() []extract.Vout {
+	vouts := make([]extract.Vout, len(t.Vout))
+	for i := range t.Vout {
+		vouts[i] = &t.Vout[i]
+	}
+	return vouts
+}
+
+type Block struct {
+	Hash     string
+	PrevHash string
+	Time     int
+	Height   int
+	Tx       []Transaction
+}
+
+var _ extract.Block = (*Block)(nil)
+
+func (b *Block) GetHash() string {
+	return b.Hash
+}
+
+func (b *Block) GetPrevHash() string {
+	return b.PrevHash
+}
+
+func (b *Block) GetTime() int {
+	return b.Time
+}
+
+func (b *Block) GetHeight() int {
+	return b.Height
+}
+
+func (b *Block) GetTxs() []extract.Transaction {
+	txs := make([]extract.Transaction, len(b.Tx))
+	for i := range b.Tx {
+		txs[i] = &b.Tx[i]
+	}
+	return txs
+}
+
+// NewBlock converts a deserialized block, with ASM and addresses of outputs derived locally for the network.
+func NewBlock(msg *wire.MsgBlock, height int, params *chaincfg.Params) *Block {
+	block := &Block{
+		Hash:     msg.BlockHash().String(),
+		PrevHash: msg.Header.PrevBlock.String(),
+		Time:     int(msg.Header.Timestamp.Unix()),
+		Height:   height,
+		Tx:       make([]Transaction, len(msg.Transactions)),
+	}
+
+	for i, tx := range msg.Transactions {
+		block.Tx[i] = NewTransaction(tx, params)
+	}
+	return block
+}
+
+// NewTransaction converts a deserialized transaction, with ASM and addresses of outputs derived locally for the network.
+func NewTransaction(tx *wire.MsgTx, params *chaincfg.Params) Transaction {
+	t := Transaction{
+		Txid: tx.TxHash().String(),
+		Vin:  make([]Vin, len(tx.TxIn)),
+		Vout: make([]Vout, len(tx.TxOut)),
+	}
+	for i, in := range tx.TxIn {
+		witness := make([]string, len(in.Witness))
+		for j, item := range in.Witness {
+			witness[j] = hex.EncodeToString(item)
+		}
+		t.Vin[i] = Vin{
+			Txid:    in.PreviousOutPoint.Hash.String(),
+			Vout:    int(in.PreviousOutPoint.Index),
+			Witness: witness,
+		}
+	}
+	for i, out := range tx.TxOut {
+		t.Vout[i] = Vout{
+			Value:   float64(out.Value),
+			Address: Address(out.PkScript, params),
+			Asm:     extract.DisassembleScript(out.PkScript),
+		}
+	}
+	return t
+}
+
+// DecodeTransaction converts a hex encoded serialized transaction, with or without witness data.
+func DecodeTransaction(rawHex string, params *chaincfg.Params) (*Transaction, error) {
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transaction hex: %v", err)
+	}
+
+	var msg wire.MsgTx
+	if err := msg.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("failed to deserialize transaction: %v", err)
+	}
+	tx := NewTransaction(&msg, params)
+	return &tx, nil
+}
+
+// Address returns the address of the standard output script, or "" for scripts mempool.space shows no address for,
+// like P2PK, multisig and OP_RETURN.
+func Address(pkScript []byte, params *chaincfg.Params) string {
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, params)
+	if err != nil || len(addrs) != 1 {
+		return ""
+	}
+
+	switch class {
+	case txscript.PubKeyHashTy, txscript.ScriptHashTy, txscript.WitnessV0PubKeyHashTy, txscript.WitnessV0ScriptHashTy, txscript.WitnessV1TaprootTy:
+		return addrs[0].EncodeAddress()
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,72 @@
+package rawblock
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		script  string
+		address string
+	}{
+		// P2PKH.
+		{"76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac", "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"},
+		// P2TR.
+		{"5120a37c3903c8d0db6512e2b40b0dffa05e5a3ab73603ce8c9c4b7771e5412328f9", "bc1p5d7rjq7g6rdk2yhzks9smlaqtedr4dekq08ge8ztwac72sfr9rusxg3297"},
+		// P2PK, shown without address.
+		{"4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac", ""},
+		// OP_RETURN.
+		{"6a01430382a405", ""},
+	}
+
+	for _, tt := range tests {
+		script, _ := hex.DecodeString(tt.script)
+		if address := Address(script, &chaincfg.MainNetParams); address != tt.address {
+			t.Fatalf("unexpected address of %s: %s, expected: %s", tt.script, address, tt.address)
+		}
+	}
+}
+
+func TestDecodeTransaction(t *testing.T) {
+	prev, _ := chainhash.NewHashFromStr("0d3c1a7e8b2f6e0f1f4b5a1c9a2e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b3a2f1e")
+	p2pkh, _ := hex.DecodeString("76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac")
+	opReturn, _ := hex.DecodeString("6a01430382a405")
+
+	msg := wire.NewMsgTx(2)
+	msg.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prev, 1), nil, [][]byte{{0x01, 0x02}}))
+	msg.AddTxOut(wire.NewTxOut(10000, p2pkh))
+	msg.AddTxOut(wire.NewTxOut(0, opReturn))
+	var buf bytes.Buffer
+	if err := msg.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := DecodeTransaction(hex.EncodeToString(buf.Bytes()), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.GetTxid() != msg.TxHash().String() {
+		t.Fatalf("unexpected txid: %s", tx.GetTxid())
+	}
+	if len(tx.Vin) != 1 || tx.Vin[0].Txid != prev.String() || tx.Vin[0].Vout != 1 || tx.Vin[0].Witness[0] != "0102" {
+		t.Fatalf("unexpected vin: %+v", tx.Vin)
+	}
+	if len(tx.Vout) != 2 || tx.Vout[0].Value != 10000 || tx.Vout[0].Address != "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa" {
+		t.Fatalf("unexpected vout: %+v", tx.Vout)
+	}
+	if tx.Vout[1].Address != "" || tx.Vout[1].Asm != "OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405" {
+		t.Fatalf("unexpected metadata vout: %+v", tx.Vout[1])
+	}
+
+	for _, raw := range []string{"zz", "0200"} {
+		if _, err := DecodeTransaction(raw, &chaincfg.MainNetParams); err == nil {
+			t.Fatalf("expected error decoding %s", raw)
+		}
+	}
+}
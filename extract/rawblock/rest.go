@@ -0,0 +1,74 @@
+package rawblock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RestClient fetches serialized blocks from the REST interface of Bitcoin Core, which is enabled by -rest and needs
+// no auth.
+type RestClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+var _ RawBlockSource = (*RestClient)(nil)
+
+// NewRestClient creates a REST client, the base URL is like "http://127.0.0.1:8332/rest". A zero timeout means no
+// timeout.
+func NewRestClient(baseURL string, timeout time.Duration) *RestClient {
+	return &RestClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (c *RestClient) get(subPath string) ([]byte, error) {
+	resp, err := c.client.Get(c.baseURL + subPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s, %s", subPath, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func (c *RestClient) GetLatestBlockHeight() (int, error) {
+	body, err := c.get("/chaininfo.json")
+	if err != nil {
+		return 0, err
+	}
+
+	var info struct {
+		Blocks int `json:"blocks"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return 0, fmt.Errorf("failed to decode chain info: %s", string(body))
+	}
+	return info.Blocks, nil
+}
+
+func (c *RestClient) GetBlockHash(blockHeight int) (string, error) {
+	body, err := c.get(fmt.Sprintf("/blockhashbyheight/%d.hex", blockHeight))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+func (c *RestClient) GetRawBlock(blockHash string) ([]byte, error) {
+	return c.get(fmt.Sprintf("/block/%s.bin", blockHash))
+}
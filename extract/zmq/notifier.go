@@ -0,0 +1,88 @@
+package zmq
+
+import (
+	"context"
+	"time"
+
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/go-zeromq/zmq4"
+	"go.uber.org/zap"
+)
+
+var (
+	HASHBLOCK_TOPIC  = "hashblock"
+	RECONNECT_DELAY  = 5 * time.Second
+	NOTIFY_QUEUE_LEN = 1
+)
+
+// Notifier subscribes to the zmqpubhashblock endpoint of Bitcoin Core. ZeroMQ drops messages while disconnected, so
+// blocks should still be polled, though less often.
+type Notifier struct {
+	endpoint string
+	notify   chan struct{}
+	cancel   context.CancelFunc
+	logger   *zap.Logger
+}
+
+var _ extract.Notifier = (*Notifier)(nil)
+
+// NewNotifier subscribes to the endpoint in the background, e.g. tcp://127.0.0.1:28332, and reconnects on failures.
+func NewNotifier(endpoint string, logger *zap.Logger) *Notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{
+		endpoint: endpoint,
+		notify:   make(chan struct{}, NOTIFY_QUEUE_LEN),
+		cancel:   cancel,
+		logger:   logger,
+	}
+	go n.run(ctx)
+	return n
+}
+
+func (n *Notifier) Notify() <-chan struct{} {
+	return n.notify
+}
+
+// Close stops the subscription.
+func (n *Notifier) Close() {
+	n.cancel()
+}
+
+func (n *Notifier) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := n.subscribe(ctx); err != nil && ctx.Err() == nil {
+			n.logger.Warn("zmq subscription failed", zap.String("endpoint", n.endpoint), zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(RECONNECT_DELAY):
+			}
+		}
+	}
+}
+
+func (n *Notifier) subscribe(ctx context.Context) error {
+	sub := zmq4.NewSub(ctx)
+	defer sub.Close()
+	if err := sub.Dial(n.endpoint); err != nil {
+		return err
+	}
+	if err := sub.SetOption(zmq4.OptionSubscribe, HASHBLOCK_TOPIC); err != nil {
+		return err
+	}
+
+	for {
+		// Frames are topic, block hash and sequence number.
+		msg, err := sub.Recv()
+		if err != nil {
+			return err
+		}
+		if len(msg.Frames) == 0 || string(msg.Frames[0]) != HASHBLOCK_TOPIC {
+			continue
+		}
+		// A pending signal already covers this block.
+		select {
+		case n.notify <- struct{}{}:
+		default:
+		}
+	}
+}
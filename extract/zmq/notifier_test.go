@@ -0,0 +1,58 @@
+package zmq
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNotifier(t *testing.T) {
+	// Stands in for bitcoind publishing on zmqpubhashblock.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := "tcp://" + l.Addr().String()
+	l.Close()
+	pub := zmq4.NewPub(context.Background())
+	defer pub.Close()
+	if err := pub.Listen(endpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	logger, _ := zap.NewDevelopment()
+	notifier := NewNotifier(endpoint, logger)
+	defer notifier.Close()
+
+	// Other topics are ignored.
+	hash := make([]byte, 32)
+	seq := []byte{0, 0, 0, 0}
+	rawtx := zmq4.NewMsgFrom([]byte("rawtx"), []byte{0x01}, seq)
+	hashblock := zmq4.NewMsgFrom([]byte(HASHBLOCK_TOPIC), hash, seq)
+
+	// Messages published before the subscription is connected are dropped, so publish until notified.
+	deadline := time.After(5 * time.Second)
+	for notified := false; !notified; {
+		assert.Nil(t, pub.Send(rawtx))
+		select {
+		case <-notifier.Notify():
+			t.Fatal("notified of rawtx")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		assert.Nil(t, pub.Send(hashblock))
+		select {
+		case <-notifier.Notify():
+			notified = true
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("not notified")
+		}
+	}
+
+}
@@ -1,6 +1,8 @@
 package load
 
 import (
+	"fmt"
+
 	"github.com/decentralize-everything/indexer/store"
 	"github.com/decentralize-everything/indexer/types"
 	"go.uber.org/zap"
@@ -18,89 +20,267 @@ func NewDbUpdater(db store.Database, logger *zap.Logger) *DbUpdater {
 	}
 }
 
+// changes are merged updates, of a whole block or of the transaction being checked. The changes of a transaction are
+// merged into the block only once all its events pass, so that a rejected transaction leaves nothing behind.
+type changes struct {
+	coins      map[string]*types.CoinInfo
+	balances   map[string]map[string]types.Amount       // Balance deltas by coin ID and address.
+	utxos      map[string]map[string]*types.UnspentCoin // Coins by UTXO and coin ID, nil marks a spent coin.
+	spends     map[string]types.SpentCoins
+	history    []*types.HistoryEntry
+	coinTxs    []*types.CoinTx
+	invalidOps []*types.InvalidOperation
+}
+
+func newChanges() *changes {
+	return &changes{
+		coins:    make(map[string]*types.CoinInfo),
+		balances: make(map[string]map[string]types.Amount),
+		utxos:    make(map[string]map[string]*types.UnspentCoin),
+		spends:   make(map[string]types.SpentCoins),
+	}
+}
+
+// setCoin stages a coin of the UTXO, a nil coin spends the one the store holds.
+func (c *changes) setCoin(utxo string, id string, uc *types.UnspentCoin) {
+	if uc == nil && c.utxos[utxo][id] != nil {
+		// Created earlier, the store never sees it.
+		delete(c.utxos[utxo], id)
+		if len(c.utxos[utxo]) == 0 {
+			delete(c.utxos, utxo)
+		}
+		return
+	}
+	if _, ok := c.utxos[utxo]; !ok {
+		c.utxos[utxo] = make(map[string]*types.UnspentCoin)
+	}
+	c.utxos[utxo][id] = uc
+}
+
+// merge adds the changes of a transaction, whose balances were checked not to overflow the block's.
+func (c *changes) merge(tx *changes) {
+	for id, ci := range tx.coins {
+		c.coins[id] = ci
+	}
+	for coin, balances := range tx.balances {
+		if _, ok := c.balances[coin]; !ok {
+			c.balances[coin] = make(map[string]types.Amount)
+		}
+		for address, delta := range balances {
+			c.balances[coin][address], _ = c.balances[coin][address].Add(delta)
+		}
+	}
+	for utxo, coins := range tx.utxos {
+		for id, uc := range coins {
+			c.setCoin(utxo, id, uc)
+		}
+	}
+	for utxo, scs := range tx.spends {
+		c.spends[utxo] = append(c.spends[utxo], scs...)
+	}
+	for _, entry := range tx.history {
+		entry.Index = len(c.history)
+		c.history = append(c.history, entry)
+	}
+	c.coinTxs = append(c.coinTxs, tx.coinTxs...)
+	c.invalidOps = append(c.invalidOps, tx.invalidOps...)
+}
+
 func (u *DbUpdater) Update(batch *types.BatchUpdate) error {
 	// Merge updates for batch operations.
-	coinAddressBalanceUpdates := make(map[string]map[string]int)
-	coinInfoUpdates := make(map[string]*types.CoinInfo)
-	utxoUpdates := make(map[string]*types.UnspentCoin)
+	block := newChanges()
+	block.invalidOps = append([]*types.InvalidOperation(nil), batch.InvalidOps...)
+	invalidOp := func(txid string, id string, protocol string, reason string) *types.InvalidOperation {
+		return &types.InvalidOperation{
+			Txid:     txid,
+			Height:   batch.Block.GetHeight(),
+			CoinId:   id,
+			Protocol: protocol,
+			Reason:   reason,
+		}
+	}
+	// Drops the changes staged for the transaction, keeping the reason only.
+	reject := func(txid string, id string, protocol string, reason string) {
+		block.invalidOps = append(block.invalidOps, invalidOp(txid, id, protocol, reason))
+	}
 
 OUTER:
 	for _, txUpdate := range batch.TxUpdates {
+		tx := newChanges()
 		for _, event := range txUpdate.NewCoinEvents {
-			if _, ok := coinInfoUpdates[event.CoinId]; ok {
+			_, deployedInTx := tx.coins[event.CoinId]
+			if _, ok := block.coins[event.CoinId]; ok || deployedInTx {
 				u.logger.Info("duplicated coin deployment transaction on same block", zap.String("id", event.CoinId), zap.String("tx", txUpdate.Txid))
+				reject(txUpdate.Txid, event.CoinId, event.Protocol, "coin deployed earlier in the same block: "+event.CoinId)
 				continue OUTER // If this is a invalid deployment, than the whole transaction should be skipped.
 			}
 
-			if ci, err := u.db.GetCoinInfoById(event.CoinId); err == nil && ci == nil {
-				coinInfoUpdates[event.CoinId] = &types.CoinInfo{
-					Id:           event.CoinId,
-					TotalSupply:  0,
-					Args:         event.Args,
-					TxCount:      1,
-					CreatedAt:    batch.Block.GetTime(),
-					DeployTx:     txUpdate.Txid,
-					DeployHeight: batch.Block.GetHeight(),
-				}
-			} else {
+			ci, err := u.db.GetCoinInfoById(event.CoinId)
+			if err != nil {
+				return err
+			}
+			if ci != nil {
 				panic("unexpected error: duplicated coin deployment should be identified by transformer")
 			}
+			tx.coins[event.CoinId] = &types.CoinInfo{
+				Id:           event.CoinId,
+				Protocol:     event.Protocol,
+				TotalSupply:  types.Amount{},
+				Args:         event.Args,
+				TxCount:      1,
+				CreatedAt:    batch.Block.GetTime(),
+				DeployTx:     txUpdate.Txid,
+				DeployHeight: batch.Block.GetHeight(),
+			}
+			tx.coinTxs = append(tx.coinTxs, &types.CoinTx{
+				Txid:     txUpdate.Txid,
+				Height:   batch.Block.GetHeight(),
+				Time:     batch.Block.GetTime(),
+				CoinId:   event.CoinId,
+				Protocol: event.Protocol,
+				Type:     "deploy",
+			})
 		}
 
-		for i, event := range txUpdate.BalanceChangeEvents {
-			var ci *types.CoinInfo
-			var err error
-			ci, ok := coinInfoUpdates[event.CoinId]
+		coinTxs := make(map[string]*types.CoinTx) // Balance changes recorded by coin ID.
+		for _, event := range txUpdate.BalanceChangeEvents {
+			ci, ok := tx.coins[event.CoinId]
 			if !ok {
-				ci, err = u.db.GetCoinInfoById(event.CoinId)
-				if err != nil || ci == nil {
-					u.logger.Info("mint or transfer on a non-exist coin", zap.String("id", event.CoinId), zap.String("tx", txUpdate.Txid))
-					continue OUTER
+				if ci, ok = block.coins[event.CoinId]; !ok {
+					var err error
+					if ci, err = u.db.GetCoinInfoById(event.CoinId); err != nil {
+						return err
+					}
+					if ci == nil {
+						u.logger.Info("mint or transfer on a non-exist coin", zap.String("id", event.CoinId), zap.String("tx", txUpdate.Txid))
+						reject(txUpdate.Txid, event.CoinId, event.Protocol, "coin ID not found: "+event.CoinId)
+						continue OUTER
+					}
 				}
-				coinInfoUpdates[event.CoinId] = ci
+				// Work on a copy, so that the coin info stays untouched until the transaction is merged.
+				updated := *ci
+				ci = &updated
+				tx.coins[event.CoinId] = ci
 			}
 
-			// Check total supply.
+			// Check mint limit and total supply. A premine is minted by the deployment, above the limit of later mints.
 			if event.IsMint {
-				if uint64(ci.TotalSupply+event.Delta) > ci.Args["max"].(uint64) {
+				if ci.DeployTx != txUpdate.Txid && event.Delta.Cmp(ci.Args.Limit()) > 0 {
+					u.logger.Info("mint exceed limit", zap.String("id", event.CoinId), zap.String("tx", txUpdate.Txid))
+					reject(txUpdate.Txid, event.CoinId, event.Protocol, fmt.Sprintf("mint %s exceed mint limit, delta = %s, limit = %s", event.CoinId, event.Delta, ci.Args.Limit()))
+					continue OUTER
+				}
+				supply, err := ci.TotalSupply.Add(event.Delta)
+				if err != nil || supply.Cmp(ci.Args.Max()) > 0 {
 					u.logger.Info("mint exceed max supply", zap.String("id", event.CoinId), zap.String("tx", txUpdate.Txid))
+					reject(txUpdate.Txid, event.CoinId, event.Protocol, fmt.Sprintf("mint %s exceed max supply, totalSupply = %s, delta = %s, max = %s", event.CoinId, ci.TotalSupply, event.Delta, ci.Args.Max()))
 					continue OUTER
 				}
-				ci.TotalSupply += event.Delta
+				ci.TotalSupply = supply
 			}
 
-			if _, ok := coinAddressBalanceUpdates[event.CoinId]; !ok {
-				coinAddressBalanceUpdates[event.CoinId] = make(map[string]int)
+			// One record per coin of the transaction, a mint if any of its events mints.
+			txType := "transfer"
+			if event.IsMint {
+				txType = "mint"
 			}
-			coinAddressBalanceUpdates[event.CoinId][event.Address] += event.Delta
-
-			if event.Delta > 0 {
-				utxoUpdates[event.Utxo] = &types.UnspentCoin{
-					CoinId: event.CoinId,
-					Owner:  event.Address,
-					Amount: event.Delta,
-					Utxo:   event.Utxo,
+			if coinTx, ok := coinTxs[event.CoinId]; ok {
+				if event.IsMint {
+					coinTx.Type = txType
+				}
+			} else {
+				ci.TxCount++
+				coinTxs[event.CoinId] = &types.CoinTx{
+					Txid:     txUpdate.Txid,
+					Height:   batch.Block.GetHeight(),
+					Time:     batch.Block.GetTime(),
+					CoinId:   event.CoinId,
+					Protocol: event.Protocol,
+					Type:     txType,
 				}
-			} else { // Mark as delete.
-				utxoUpdates[event.Utxo] = nil
+				tx.coinTxs = append(tx.coinTxs, coinTxs[event.CoinId])
 			}
 
-			// Currently, we suppose one transaction contains one type of coin operation.
-			if i == 0 {
-				ci.TxCount++
+			// Runes count their mints in the supply only, the minted runes are allocated to outputs like input runes.
+			if event.IsMint && len(event.Address) == 0 && len(event.Utxo) == 0 {
+				continue
+			}
+
+			// The balance must stay in range once merged into the block too.
+			if _, ok := tx.balances[event.CoinId]; !ok {
+				tx.balances[event.CoinId] = make(map[string]types.Amount)
+			}
+			delta, err := tx.balances[event.CoinId][event.Address].Add(event.Delta)
+			if err == nil {
+				_, err = block.balances[event.CoinId][event.Address].Add(delta)
+			}
+			if err != nil {
+				u.logger.Info("balance overflow", zap.String("id", event.CoinId), zap.String("tx", txUpdate.Txid))
+				reject(txUpdate.Txid, event.CoinId, event.Protocol, fmt.Sprintf("balance of %s in %s out of range: %v", event.Address, event.CoinId, err))
+				continue OUTER
+			}
+			tx.balances[event.CoinId][event.Address] = delta
+
+			direction := "in"
+			if event.Delta.Sign() < 0 {
+				direction = "out"
+			}
+			tx.history = append(tx.history, &types.HistoryEntry{
+				Txid:      txUpdate.Txid,
+				Height:    batch.Block.GetHeight(),
+				Time:      batch.Block.GetTime(),
+				CoinId:    event.CoinId,
+				Protocol:  event.Protocol,
+				Address:   event.Address,
+				Delta:     event.Delta,
+				Direction: direction,
+				Utxo:      event.Utxo,
+				IsMint:    event.IsMint,
+			})
+
+			// Events without UTXO change account based balances only, e.g. BRC-20.
+			if len(event.Utxo) > 0 {
+				if event.Delta.Sign() > 0 {
+					if uc := tx.utxos[event.Utxo][event.CoinId]; uc != nil {
+						// Several events of the transaction send the coin to the same output.
+						uc.Amount, _ = uc.Amount.Add(event.Delta)
+					} else {
+						tx.setCoin(event.Utxo, event.CoinId, &types.UnspentCoin{
+							CoinId:   event.CoinId,
+							Protocol: event.Protocol,
+							Owner:    event.Address,
+							Amount:   event.Delta,
+							Utxo:     event.Utxo,
+						})
+					}
+				} else {
+					tx.setCoin(event.Utxo, event.CoinId, nil)
+					tx.spends[event.Utxo] = append(tx.spends[event.Utxo], &types.SpentCoin{
+						UnspentCoin: types.UnspentCoin{
+							CoinId:   event.CoinId,
+							Protocol: event.Protocol,
+							Owner:    event.Address,
+							Amount:   event.Delta.Neg(),
+							Utxo:     event.Utxo,
+						},
+						SpentTxid:   txUpdate.Txid,
+						SpentHeight: batch.Block.GetHeight(),
+					})
+				}
 			}
 		}
+		block.merge(tx)
 	}
 
-	if len(coinInfoUpdates) > 0 {
-		u.db.CoinInfoBatchUpdate(coinInfoUpdates)
-	}
-	if len(coinAddressBalanceUpdates) > 0 {
-		u.db.BalanceBatchUpdate(coinAddressBalanceUpdates)
-	}
-	if len(utxoUpdates) > 0 {
-		u.db.UtxoBatchUpdate(utxoUpdates)
-	}
-	u.db.IndexedHeightUpdate(batch.Block.GetHeight())
-	return nil
+	return u.db.ApplyBlock(&types.BlockUpdate{
+		Height:     batch.Block.GetHeight(),
+		Hash:       batch.Block.GetHash(),
+		CoinInfos:  block.coins,
+		Balances:   block.balances,
+		Utxos:      block.utxos,
+		Spends:     block.spends,
+		History:    block.history,
+		CoinTxs:    block.coinTxs,
+		InvalidOps: block.invalidOps,
+	})
 }
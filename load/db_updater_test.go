@@ -1,6 +1,8 @@
 package load
 
 import (
+	"errors"
+	"math"
 	"testing"
 
 	"github.com/decentralize-everything/indexer/extract/mempool"
@@ -24,8 +26,7 @@ func setup(t *testing.T) (*store.MockDatabase, *DbUpdater, *observer.ObservedLog
 func TestDeployDuplicateCoinOnSameBlockError(t *testing.T) {
 	mockDb, updater, observedLogs := setup(t)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(nil, nil)
-	mockDb.EXPECT().CoinInfoBatchUpdate(gomock.Any())
-	mockDb.EXPECT().IndexedHeightUpdate(1)
+	mockDb.EXPECT().ApplyBlock(gomock.Any())
 
 	updater.Update(&types.BatchUpdate{
 		Block: &mempool.Block{
@@ -63,7 +64,16 @@ func TestDeployDuplicateCoinOnSameBlockError(t *testing.T) {
 func TestMintNonExistCoinError(t *testing.T) {
 	mockDb, updater, observedLogs := setup(t)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(nil, nil)
-	mockDb.EXPECT().IndexedHeightUpdate(1)
+	mockDb.EXPECT().ApplyBlock(&types.BlockUpdate{
+		Height:    1,
+		CoinInfos: map[string]*types.CoinInfo{},
+		Balances:  map[string]map[string]types.Amount{},
+		Utxos:     map[string]map[string]*types.UnspentCoin{},
+		Spends:    map[string]types.SpentCoins{},
+		InvalidOps: []*types.InvalidOperation{
+			{Txid: "1234", Height: 1, CoinId: "CARV", Reason: "coin ID not found: CARV"},
+		},
+	})
 
 	updater.Update(&types.BatchUpdate{
 		Block: &mempool.Block{
@@ -95,21 +105,22 @@ func TestMintExceedMaxSupplyError(t *testing.T) {
 	mockDb, updater, observedLogs := setup(t)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
 		Id:          "CARV",
-		TotalSupply: 100,
-		Args: map[string]interface{}{
-			"max": uint64(100),
-		},
+		TotalSupply: types.NewAmount(100),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Limit: 10,
+		}},
 	}, nil)
-	mockDb.EXPECT().CoinInfoBatchUpdate(map[string]*types.CoinInfo{
-		"CARV": {
-			Id:          "CARV",
-			TotalSupply: 100,
-			Args: map[string]interface{}{
-				"max": uint64(100),
-			},
+	mockDb.EXPECT().ApplyBlock(&types.BlockUpdate{
+		Height:    1,
+		CoinInfos: map[string]*types.CoinInfo{},
+		Balances:  map[string]map[string]types.Amount{},
+		Utxos:     map[string]map[string]*types.UnspentCoin{},
+		Spends:    map[string]types.SpentCoins{},
+		InvalidOps: []*types.InvalidOperation{
+			{Txid: "1234", Height: 1, CoinId: "CARV", Reason: "mint CARV exceed max supply, totalSupply = 100, delta = 1, max = 100"},
 		},
 	})
-	mockDb.EXPECT().IndexedHeightUpdate(1)
 
 	updater.Update(&types.BatchUpdate{
 		Block: &mempool.Block{
@@ -122,7 +133,7 @@ func TestMintExceedMaxSupplyError(t *testing.T) {
 					{
 						CoinId: "CARV",
 						IsMint: true,
-						Delta:  1,
+						Delta:  types.NewAmount(1),
 					},
 				},
 			},
@@ -138,23 +149,148 @@ func TestMintExceedMaxSupplyError(t *testing.T) {
 	}, allLogs[0].Context)
 }
 
+func TestMintExceedLimitError(t *testing.T) {
+	mockDb, updater, observedLogs := setup(t)
+	mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
+		Id:          "CARV",
+		TotalSupply: types.NewAmount(1),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Limit: 10,
+		}},
+	}, nil)
+	mockDb.EXPECT().ApplyBlock(&types.BlockUpdate{
+		Height:    1,
+		CoinInfos: map[string]*types.CoinInfo{},
+		Balances:  map[string]map[string]types.Amount{},
+		Utxos:     map[string]map[string]*types.UnspentCoin{},
+		Spends:    map[string]types.SpentCoins{},
+		InvalidOps: []*types.InvalidOperation{
+			{Txid: "1234", Height: 1, CoinId: "CARV", Reason: "mint CARV exceed mint limit, delta = 11, limit = 10"},
+		},
+	})
+
+	updater.Update(&types.BatchUpdate{
+		Block: &mempool.Block{
+			Height: 1,
+		},
+		TxUpdates: []*types.TxUpdate{
+			{
+				Txid: "1234",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{
+						CoinId: "CARV",
+						IsMint: true,
+						Delta:  types.NewAmount(11),
+					},
+				},
+			},
+		},
+	})
+
+	assert.Equal(t, 1, observedLogs.Len())
+	allLogs := observedLogs.All()
+	assert.Equal(t, "mint exceed limit", allLogs[0].Message)
+	assert.ElementsMatch(t, []zap.Field{
+		{Key: "id", Type: zapcore.StringType, String: "CARV"},
+		{Key: "tx", Type: zapcore.StringType, String: "1234"},
+	}, allLogs[0].Context)
+}
+
+// A transaction overflowing a balance is rejected, the transactions before it still apply.
+func TestBalanceOverflowError(t *testing.T) {
+	mockDb, updater, observedLogs := setup(t)
+	mockDb.EXPECT().GetCoinInfoById("ordi").Return(&types.CoinInfo{
+		Id:       "ordi",
+		Protocol: "brc20",
+	}, nil)
+	mockDb.EXPECT().ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		CoinInfos: map[string]*types.CoinInfo{
+			"ordi": {
+				Id:       "ordi",
+				Protocol: "brc20",
+				TxCount:  1,
+			},
+		},
+		Balances: map[string]map[string]types.Amount{
+			"ordi": {
+				"1234": types.MAX_AMOUNT,
+			},
+		},
+		Utxos:  map[string]map[string]*types.UnspentCoin{},
+		Spends: map[string]types.SpentCoins{},
+		History: []*types.HistoryEntry{
+			{Txid: "1234", Height: 1, Index: 0, CoinId: "ordi", Protocol: "brc20", Address: "1234", Delta: types.MAX_AMOUNT, Direction: "in"},
+		},
+		CoinTxs: []*types.CoinTx{
+			{Txid: "1234", Height: 1, CoinId: "ordi", Protocol: "brc20", Type: "transfer"},
+		},
+		InvalidOps: []*types.InvalidOperation{
+			{Txid: "5678", Height: 1, CoinId: "ordi", Protocol: "brc20", Reason: "balance of 1234 in ordi out of range: amount overflow: 340282366920938463463374607431768211455 + 1"},
+		},
+	})
+
+	updater.Update(&types.BatchUpdate{
+		Block: &mempool.Block{
+			Height: 1,
+		},
+		TxUpdates: []*types.TxUpdate{
+			{
+				Txid: "1234",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{
+						CoinId:   "ordi",
+						Protocol: "brc20",
+						Address:  "1234",
+						Delta:    types.MAX_AMOUNT,
+					},
+				},
+			},
+			{
+				Txid: "5678",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{
+						CoinId:   "ordi",
+						Protocol: "brc20",
+						Address:  "1234",
+						Delta:    types.NewAmount(1),
+					},
+				},
+			},
+		},
+	})
+
+	assert.Equal(t, 1, observedLogs.Len())
+	assert.Equal(t, "balance overflow", observedLogs.All()[0].Message)
+}
+
 func TestDeploySuccess(t *testing.T) {
 	mockDb, updater, _ := setup(t)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(nil, nil)
-	mockDb.EXPECT().CoinInfoBatchUpdate(map[string]*types.CoinInfo{
-		"CARV": {
-			Id:          "CARV",
-			TotalSupply: 0,
-			Args: map[string]interface{}{
-				"max": uint64(100),
+	mockDb.EXPECT().ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		CoinInfos: map[string]*types.CoinInfo{
+			"CARV": {
+				Id:          "CARV",
+				TotalSupply: types.NewAmount(0),
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max:   100,
+					Limit: 10,
+				}},
+				TxCount:      1,
+				CreatedAt:    1234567890,
+				DeployTx:     "1234",
+				DeployHeight: 1,
 			},
-			TxCount:      1,
-			CreatedAt:    1234567890,
-			DeployTx:     "1234",
-			DeployHeight: 1,
+		},
+		Balances: map[string]map[string]types.Amount{},
+		Utxos:    map[string]map[string]*types.UnspentCoin{},
+		Spends:   map[string]types.SpentCoins{},
+		CoinTxs: []*types.CoinTx{
+			{Txid: "1234", Height: 1, Time: 1234567890, CoinId: "CARV", Type: "deploy"},
 		},
 	})
-	mockDb.EXPECT().IndexedHeightUpdate(1)
 
 	updater.Update(&types.BatchUpdate{
 		Block: &mempool.Block{
@@ -167,9 +303,10 @@ func TestDeploySuccess(t *testing.T) {
 				NewCoinEvents: []*types.NewCoinEvent{
 					{
 						CoinId: "CARV",
-						Args: map[string]interface{}{
-							"max": uint64(100),
-						},
+						Args: types.CoinArgs{Carv: &types.CarvArgs{
+							Max:   100,
+							Limit: 10,
+						}},
 					},
 				},
 			},
@@ -181,35 +318,46 @@ func TestMintSuccess(t *testing.T) {
 	mockDb, updater, _ := setup(t)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
 		Id:          "CARV",
-		TotalSupply: 1,
-		Args: map[string]interface{}{
-			"max": uint64(100),
-		},
+		TotalSupply: types.NewAmount(1),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Limit: 10,
+		}},
 	}, nil)
-	mockDb.EXPECT().CoinInfoBatchUpdate(map[string]*types.CoinInfo{
-		"CARV": {
-			Id:          "CARV",
-			TotalSupply: 2,
-			Args: map[string]interface{}{
-				"max": uint64(100),
+	mockDb.EXPECT().ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		CoinInfos: map[string]*types.CoinInfo{
+			"CARV": {
+				Id:          "CARV",
+				TotalSupply: types.NewAmount(2),
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max:   100,
+					Limit: 10,
+				}},
+				TxCount: 1,
 			},
-			TxCount: 1,
 		},
-	})
-	mockDb.EXPECT().BalanceBatchUpdate(map[string]map[string]int{
-		"CARV": {
-			"5678": 1,
+		Balances: map[string]map[string]types.Amount{
+			"CARV": {
+				"5678": types.NewAmount(1),
+			},
 		},
-	})
-	mockDb.EXPECT().UtxoBatchUpdate(map[string]*types.UnspentCoin{
-		"1234:0": {
-			CoinId: "CARV",
-			Owner:  "5678",
-			Amount: 1,
-			Utxo:   "1234:0",
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			"1234:0": {"CARV": {
+				CoinId: "CARV",
+				Owner:  "5678",
+				Amount: types.NewAmount(1),
+				Utxo:   "1234:0",
+			}},
+		},
+		Spends: map[string]types.SpentCoins{},
+		History: []*types.HistoryEntry{
+			{Txid: "1234", Height: 1, CoinId: "CARV", Address: "5678", Delta: types.NewAmount(1), Direction: "in", Utxo: "1234:0", IsMint: true},
+		},
+		CoinTxs: []*types.CoinTx{
+			{Txid: "1234", Height: 1, CoinId: "CARV", Type: "mint"},
 		},
 	})
-	mockDb.EXPECT().IndexedHeightUpdate(1)
 
 	updater.Update(&types.BatchUpdate{
 		Block: &mempool.Block{
@@ -223,7 +371,7 @@ func TestMintSuccess(t *testing.T) {
 						CoinId:  "CARV",
 						Address: "5678",
 						IsMint:  true,
-						Delta:   1,
+						Delta:   types.NewAmount(1),
 						Utxo:    "1234:0",
 					},
 				},
@@ -236,37 +384,55 @@ func TestTransferSuccess(t *testing.T) {
 	mockDb, updater, _ := setup(t)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
 		Id:          "CARV",
-		TotalSupply: 1,
-		Args: map[string]interface{}{
-			"max": uint64(100),
-		},
+		TotalSupply: types.NewAmount(1),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Limit: 10,
+		}},
 	}, nil)
-	mockDb.EXPECT().CoinInfoBatchUpdate(map[string]*types.CoinInfo{
-		"CARV": {
-			Id:          "CARV",
-			TotalSupply: 1,
-			Args: map[string]interface{}{
-				"max": uint64(100),
+	mockDb.EXPECT().ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		CoinInfos: map[string]*types.CoinInfo{
+			"CARV": {
+				Id:          "CARV",
+				TotalSupply: types.NewAmount(1),
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max:   100,
+					Limit: 10,
+				}},
+				TxCount: 1,
 			},
-			TxCount: 1,
 		},
-	})
-	mockDb.EXPECT().BalanceBatchUpdate(map[string]map[string]int{
-		"CARV": {
-			"5678": -1,
-			"1234": 1,
+		Balances: map[string]map[string]types.Amount{
+			"CARV": {
+				"5678": types.NewAmount(-1),
+				"1234": types.NewAmount(1),
+			},
+		},
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			"1234:0": {"CARV": {
+				CoinId: "CARV",
+				Owner:  "1234",
+				Amount: types.NewAmount(1),
+				Utxo:   "1234:0",
+			}},
+			"9abc:0": {"CARV": nil},
+		},
+		Spends: map[string]types.SpentCoins{
+			"9abc:0": {{
+				UnspentCoin: types.UnspentCoin{CoinId: "CARV", Owner: "5678", Amount: types.NewAmount(1), Utxo: "9abc:0"},
+				SpentTxid:   "1234",
+				SpentHeight: 1,
+			}},
+		},
+		History: []*types.HistoryEntry{
+			{Txid: "1234", Height: 1, Index: 0, CoinId: "CARV", Address: "5678", Delta: types.NewAmount(-1), Direction: "out", Utxo: "9abc:0"},
+			{Txid: "1234", Height: 1, Index: 1, CoinId: "CARV", Address: "1234", Delta: types.NewAmount(1), Direction: "in", Utxo: "1234:0"},
+		},
+		CoinTxs: []*types.CoinTx{
+			{Txid: "1234", Height: 1, CoinId: "CARV", Type: "transfer"},
 		},
 	})
-	mockDb.EXPECT().UtxoBatchUpdate(map[string]*types.UnspentCoin{
-		"1234:0": {
-			CoinId: "CARV",
-			Owner:  "1234",
-			Amount: 1,
-			Utxo:   "1234:0",
-		},
-		"9abc:0": nil,
-	})
-	mockDb.EXPECT().IndexedHeightUpdate(1)
 
 	updater.Update(&types.BatchUpdate{
 		Block: &mempool.Block{
@@ -280,14 +446,14 @@ func TestTransferSuccess(t *testing.T) {
 						CoinId:  "CARV",
 						Address: "5678",
 						IsMint:  false,
-						Delta:   -1,
+						Delta:   types.NewAmount(-1),
 						Utxo:    "9abc:0",
 					},
 					{
 						CoinId:  "CARV",
 						Address: "1234",
 						IsMint:  false,
-						Delta:   1,
+						Delta:   types.NewAmount(1),
 						Utxo:    "1234:0",
 					},
 				},
@@ -295,3 +461,350 @@ func TestTransferSuccess(t *testing.T) {
 		},
 	})
 }
+
+func TestAccountBalanceChangeSuccess(t *testing.T) {
+	mockDb, updater, _ := setup(t)
+	mockDb.EXPECT().GetCoinInfoById("ordi").Return(&types.CoinInfo{
+		Id:       "ordi",
+		Protocol: "brc20",
+		Args: types.CoinArgs{Brc20: &types.Brc20Args{
+			Max:   types.NewAmount(100),
+			Limit: types.NewAmount(10),
+		}},
+	}, nil)
+	mockDb.EXPECT().ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		CoinInfos: map[string]*types.CoinInfo{
+			"ordi": {
+				Id:       "ordi",
+				Protocol: "brc20",
+				Args: types.CoinArgs{Brc20: &types.Brc20Args{
+					Max:   types.NewAmount(100),
+					Limit: types.NewAmount(10),
+				}},
+				TxCount: 1,
+			},
+		},
+		Balances: map[string]map[string]types.Amount{
+			"ordi": {
+				"5678": types.NewAmount(-1),
+				"1234": types.NewAmount(1),
+			},
+		},
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			"9abc:0": {"ordi": nil},
+		},
+		Spends: map[string]types.SpentCoins{
+			"9abc:0": {{
+				UnspentCoin: types.UnspentCoin{CoinId: "ordi", Protocol: "brc20", Owner: "5678", Amount: types.NewAmount(1), Utxo: "9abc:0"},
+				SpentTxid:   "1234",
+				SpentHeight: 1,
+			}},
+		},
+		History: []*types.HistoryEntry{
+			{Txid: "1234", Height: 1, Index: 0, CoinId: "ordi", Protocol: "brc20", Address: "5678", Delta: types.NewAmount(-1), Direction: "out", Utxo: "9abc:0"},
+			{Txid: "1234", Height: 1, Index: 1, CoinId: "ordi", Protocol: "brc20", Address: "1234", Delta: types.NewAmount(1), Direction: "in"},
+		},
+		CoinTxs: []*types.CoinTx{
+			{Txid: "1234", Height: 1, CoinId: "ordi", Protocol: "brc20", Type: "transfer"},
+		},
+	})
+
+	updater.Update(&types.BatchUpdate{
+		Block: &mempool.Block{
+			Height: 1,
+		},
+		TxUpdates: []*types.TxUpdate{
+			{
+				Txid: "1234",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{
+						CoinId:   "ordi",
+						Protocol: "brc20",
+						Address:  "5678",
+						Delta:    types.NewAmount(-1),
+						Utxo:     "9abc:0",
+					},
+					{
+						CoinId:   "ordi",
+						Protocol: "brc20",
+						Address:  "1234",
+						Delta:    types.NewAmount(1),
+					},
+				},
+			},
+		},
+	})
+}
+
+// A BRC-20 transfer inscription created and spent in the same block never reaches the UTXOs of the store.
+func TestCreateAndSpendUtxoInBlock(t *testing.T) {
+	db := store.NewMemDb("", "testnet", 100, false, zap.NewNop())
+	db.ApplyBlock(&types.BlockUpdate{
+		Height:    1,
+		CoinInfos: map[string]*types.CoinInfo{"ordi": {Id: "ordi", Protocol: "brc20"}},
+		Balances:  map[string]map[string]types.Amount{"ordi": {"a1": types.NewAmount(10)}},
+	})
+	updater := NewDbUpdater(db, zap.NewNop())
+
+	assert.Nil(t, updater.Update(&types.BatchUpdate{
+		Block: &mempool.Block{
+			Height: 2,
+		},
+		TxUpdates: []*types.TxUpdate{
+			{
+				Txid: "1234",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{CoinId: "ordi", Protocol: "brc20", Address: "a1", Delta: types.NewAmount(-10)},
+					{CoinId: "ordi", Protocol: "brc20", Address: "a1", Delta: types.NewAmount(10), Utxo: "1234:0"},
+				},
+			},
+			{
+				Txid: "5678",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{CoinId: "ordi", Protocol: "brc20", Address: "a1", Delta: types.NewAmount(-10), Utxo: "1234:0"},
+					{CoinId: "ordi", Protocol: "brc20", Address: "a2", Delta: types.NewAmount(10)},
+				},
+			},
+		},
+	}))
+
+	coins, _ := db.GetCoinsInUtxos([]string{"1234:0"})
+	assert.Equal(t, 0, len(coins))
+	spent, _ := db.GetSpentCoins([]string{"1234:0"})
+	assert.Equal(t, 1, len(spent))
+	assert.Equal(t, "5678", spent[0].SpentTxid)
+	balances, _ := db.GetBalancesByAddress("a2")
+	assert.Equal(t, map[string]types.Amount{"ordi": types.NewAmount(10)}, balances)
+	assert.Nil(t, db.RevertBlocks(1))
+}
+
+// Coins of two protocols allocated to the same output are both kept, and spent one by one.
+func TestUtxoHoldsSeveralCoins(t *testing.T) {
+	db := store.NewMemDb("", "testnet", 100, false, zap.NewNop())
+	db.ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		CoinInfos: map[string]*types.CoinInfo{
+			"CARV": {Id: "CARV", Protocol: "carv"},
+			"RUNE": {Id: "RUNE", Protocol: "runes"},
+		},
+		Balances: map[string]map[string]types.Amount{"CARV": {"a1": types.NewAmount(1)}, "RUNE": {"a1": types.NewAmount(1)}},
+	})
+	updater := NewDbUpdater(db, zap.NewNop())
+
+	assert.Nil(t, updater.Update(&types.BatchUpdate{
+		Block: &mempool.Block{
+			Height: 2,
+		},
+		TxUpdates: []*types.TxUpdate{
+			{
+				Txid: "1234",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{CoinId: "CARV", Protocol: "carv", Address: "a1", Delta: types.NewAmount(-1)},
+					{CoinId: "CARV", Protocol: "carv", Address: "a2", Delta: types.NewAmount(1), Utxo: "1234:0"},
+				},
+			},
+			{
+				Txid: "1234",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{CoinId: "RUNE", Protocol: "runes", Address: "a1", Delta: types.NewAmount(-1)},
+					{CoinId: "RUNE", Protocol: "runes", Address: "a2", Delta: types.NewAmount(1), Utxo: "1234:0"},
+				},
+			},
+		},
+	}))
+
+	coins, _ := db.GetCoinsInUtxos([]string{"1234:0"})
+	assert.Equal(t, []*types.UnspentCoin{
+		{CoinId: "CARV", Protocol: "carv", Owner: "a2", Amount: types.NewAmount(1), Utxo: "1234:0"},
+		{CoinId: "RUNE", Protocol: "runes", Owner: "a2", Amount: types.NewAmount(1), Utxo: "1234:0"},
+	}, coins)
+	balances, _ := db.GetBalancesByAddress("a2")
+	assert.Equal(t, map[string]types.Amount{"CARV": types.NewAmount(1), "RUNE": types.NewAmount(1)}, balances)
+	ops, _ := db.GetInvalidOperations("1234")
+	assert.Equal(t, 0, len(ops))
+
+	// Only the CARV transfer spending the output is valid, the runes stay.
+	assert.Nil(t, updater.Update(&types.BatchUpdate{
+		Block: &mempool.Block{
+			Height: 3,
+		},
+		TxUpdates: []*types.TxUpdate{
+			{
+				Txid: "5678",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{CoinId: "CARV", Protocol: "carv", Address: "a2", Delta: types.NewAmount(-1), Utxo: "1234:0"},
+					{CoinId: "CARV", Protocol: "carv", Address: "a3", Delta: types.NewAmount(1), Utxo: "5678:0"},
+				},
+			},
+		},
+	}))
+
+	coins, _ = db.GetCoinsInUtxos([]string{"1234:0"})
+	assert.Equal(t, []*types.UnspentCoin{{CoinId: "RUNE", Protocol: "runes", Owner: "a2", Amount: types.NewAmount(1), Utxo: "1234:0"}}, coins)
+	spent, _ := db.GetSpentCoins([]string{"1234:0"})
+	assert.Equal(t, 1, len(spent))
+	assert.Equal(t, "CARV", spent[0].CoinId)
+	assert.Nil(t, db.RevertBlocks(1))
+	coins, _ = db.GetCoinsInUtxos([]string{"1234:0"})
+	assert.Equal(t, 2, len(coins))
+}
+
+// A transaction rejected by a later event leaves none of the changes of its earlier events.
+func TestRejectedTxLeavesNoChanges(t *testing.T) {
+	db := store.NewMemDb("", "testnet", 100, false, zap.NewNop())
+	db.ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		CoinInfos: map[string]*types.CoinInfo{
+			"CARV": {Id: "CARV", Protocol: "carv", Args: types.CoinArgs{Carv: &types.CarvArgs{Max: 100, Limit: 10}}},
+		},
+		Balances: map[string]map[string]types.Amount{"CARV": {"a1": types.NewAmount(1)}},
+	})
+	updater := NewDbUpdater(db, zap.NewNop())
+
+	assert.Nil(t, updater.Update(&types.BatchUpdate{
+		Block: &mempool.Block{
+			Height: 2,
+		},
+		TxUpdates: []*types.TxUpdate{
+			{
+				Txid: "1234",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{CoinId: "CARV", Protocol: "carv", Address: "a1", Delta: types.NewAmount(-1), Utxo: "0000:0"},
+					{CoinId: "CARV", Protocol: "carv", Address: "a2", Delta: types.NewAmount(1), Utxo: "1234:0"},
+					{CoinId: "CARV", Protocol: "carv", Address: "a2", Delta: types.NewAmount(11), Utxo: "1234:1", IsMint: true},
+				},
+			},
+		},
+	}))
+
+	coins, _ := db.GetCoinsInUtxos([]string{"1234:0", "1234:1"})
+	assert.Equal(t, 0, len(coins))
+	spent, _ := db.GetSpentCoins([]string{"0000:0"})
+	assert.Equal(t, 0, len(spent))
+	balances, _ := db.GetBalancesByAddress("a1")
+	assert.Equal(t, map[string]types.Amount{"CARV": types.NewAmount(1)}, balances)
+	balances, _ = db.GetBalancesByAddress("a2")
+	assert.Equal(t, 0, len(balances))
+	history, _, _ := db.GetHistoryByAddress("a1", "", 0, math.MaxInt, 0, 10)
+	assert.Equal(t, 0, len(history))
+	ci, _ := db.GetCoinInfoById("CARV")
+	assert.Equal(t, 0, ci.TxCount)
+	assert.True(t, ci.TotalSupply.IsZero())
+	ops, _ := db.GetInvalidOperations("1234")
+	assert.Equal(t, []*types.InvalidOperation{
+		{Txid: "1234", Height: 2, CoinId: "CARV", Protocol: "carv", Reason: "mint CARV exceed mint limit, delta = 11, limit = 10"},
+	}, ops)
+}
+
+// A transaction moving several coins is recorded once per coin, whatever the order of its events.
+func TestCoinTxPerCoin(t *testing.T) {
+	db := store.NewMemDb("", "testnet", 100, false, zap.NewNop())
+	db.ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		CoinInfos: map[string]*types.CoinInfo{
+			"RUNEA": {Id: "RUNEA", Protocol: "runes", Args: types.CoinArgs{Runes: &types.RunesArgs{Max: types.NewAmount(100), Limit: types.NewAmount(10)}}},
+			"RUNEB": {Id: "RUNEB", Protocol: "runes"},
+		},
+		Balances: map[string]map[string]types.Amount{"RUNEA": {"a1": types.NewAmount(1)}, "RUNEB": {"a1": types.NewAmount(1)}},
+	})
+	updater := NewDbUpdater(db, zap.NewNop())
+
+	assert.Nil(t, updater.Update(&types.BatchUpdate{
+		Block: &mempool.Block{
+			Height: 2,
+		},
+		TxUpdates: []*types.TxUpdate{
+			{
+				Txid: "1234",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{CoinId: "RUNEA", Protocol: "runes", Address: "a1", Delta: types.NewAmount(-1)},
+					{CoinId: "RUNEB", Protocol: "runes", Address: "a1", Delta: types.NewAmount(-1)},
+					{CoinId: "RUNEA", Protocol: "runes", Address: "a2", Delta: types.NewAmount(1)},
+					{CoinId: "RUNEB", Protocol: "runes", Address: "a2", Delta: types.NewAmount(1)},
+					{CoinId: "RUNEA", Protocol: "runes", Address: "a2", Delta: types.NewAmount(10), IsMint: true},
+				},
+			},
+		},
+	}))
+
+	coinTxs, _ := db.GetCoinTxsByTxid("1234")
+	assert.ElementsMatch(t, []*types.CoinTx{
+		{Txid: "1234", Height: 2, CoinId: "RUNEA", Protocol: "runes", Type: "mint"},
+		{Txid: "1234", Height: 2, CoinId: "RUNEB", Protocol: "runes", Type: "transfer"},
+	}, coinTxs)
+	ci, _ := db.GetCoinInfoById("RUNEA")
+	assert.Equal(t, 1, ci.TxCount)
+	ci, _ = db.GetCoinInfoById("RUNEB")
+	assert.Equal(t, 1, ci.TxCount)
+}
+
+// Runes mints count in the supply apart from their allocation, the premine isn't bound by the limit of later mints.
+func TestRuneSupply(t *testing.T) {
+	db := store.NewMemDb("", "testnet", 100, false, zap.NewNop())
+	updater := NewDbUpdater(db, zap.NewNop())
+	args := types.CoinArgs{Runes: &types.RunesArgs{Max: types.NewAmount(1200), Limit: types.NewAmount(100), Premine: types.NewAmount(1000), Cap: 2}}
+
+	assert.Nil(t, updater.Update(&types.BatchUpdate{
+		Block: &mempool.Block{
+			Height: 1,
+		},
+		TxUpdates: []*types.TxUpdate{
+			{
+				Txid:          "1234",
+				NewCoinEvents: []*types.NewCoinEvent{{CoinId: "runes:RUNE", Protocol: "runes", Args: args}},
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{CoinId: "runes:RUNE", Protocol: "runes", Delta: types.NewAmount(1000), IsMint: true},
+					{CoinId: "runes:RUNE", Protocol: "runes", Address: "a1", Delta: types.NewAmount(1000), Utxo: "1234:0"},
+				},
+			},
+			{
+				Txid: "5678",
+				BalanceChangeEvents: []*types.BalanceChangeEvent{
+					{CoinId: "runes:RUNE", Protocol: "runes", Delta: types.NewAmount(100), IsMint: true}, // Burnt.
+				},
+			},
+		},
+	}))
+
+	ci, _ := db.GetCoinInfoById("runes:RUNE")
+	assert.Equal(t, types.NewAmount(1100), ci.TotalSupply)
+	assert.Equal(t, 1, ci.HolderCount)
+	holders, _, _ := db.GetHolders("runes:RUNE", 0, 10)
+	assert.Equal(t, []*types.Holder{{Address: "a1", Balance: types.NewAmount(1000)}}, holders)
+	coinTxs, _ := db.GetCoinTxsByTxid("5678")
+	assert.Equal(t, "mint", coinTxs[0].Type)
+}
+
+func TestApplyBlockError(t *testing.T) {
+	mockDb, updater, _ := setup(t)
+	mockDb.EXPECT().ApplyBlock(gomock.Any()).Return(errors.New("disk full"))
+
+	err := updater.Update(&types.BatchUpdate{
+		Block: &mempool.Block{
+			Height: 1,
+		},
+	})
+
+	assert.EqualError(t, err, "disk full")
+}
+
+// A coin info failing to be read fails the block, nothing is applied.
+func TestGetCoinInfoError(t *testing.T) {
+	for _, txUpdate := range []*types.TxUpdate{
+		{Txid: "1234", NewCoinEvents: []*types.NewCoinEvent{{CoinId: "CARV"}}},
+		{Txid: "1234", BalanceChangeEvents: []*types.BalanceChangeEvent{{CoinId: "CARV", Address: "a1", Delta: types.NewAmount(1)}}},
+	} {
+		mockDb, updater, _ := setup(t)
+		mockDb.EXPECT().GetCoinInfoById("CARV").Return(nil, errors.New("disk failure"))
+
+		err := updater.Update(&types.BatchUpdate{
+			Block: &mempool.Block{
+				Height: 1,
+			},
+			TxUpdates: []*types.TxUpdate{txUpdate},
+		})
+
+		assert.EqualError(t, err, "disk failure")
+	}
+}
@@ -0,0 +1,338 @@
+package pending
+
+import (
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/load"
+	"github.com/decentralize-everything/indexer/protocol"
+	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/types"
+	"go.uber.org/zap"
+)
+
+var (
+	MAX_REBUILD_ATTEMPTS = 3
+	MAX_PENDING_TXS      = 50000 // Mempool transactions beyond it are not tracked until others leave the mempool.
+)
+
+// BalanceChange is a balance change of an unconfirmed transaction.
+type BalanceChange struct {
+	Txid   string       `json:"txid"`
+	CoinId string       `json:"coin_id"`
+	Delta  types.Amount `json:"delta"`
+	Utxo   string       `json:"utxo"`
+	IsMint bool         `json:"is_mint"`
+}
+
+// Spend is a UTXO holding coins, spent by an unconfirmed transaction.
+type Spend struct {
+	Txid   string       `json:"txid"` // The spending transaction.
+	Utxo   string       `json:"utxo"`
+	CoinId string       `json:"coin_id"`
+	Owner  string       `json:"owner"`
+	Amount types.Amount `json:"amount"`
+}
+
+// Activity is the unconfirmed activity of an address.
+type Activity struct {
+	BalanceChanges []*BalanceChange `json:"balance_changes"`
+	Spends         []*Spend         `json:"spends"`
+}
+
+type snapshot struct {
+	activities map[string]*Activity
+	spends     map[string]*Spend
+}
+
+// Watcher tracks the Carv activity of unconfirmed transactions. Transactions are parsed in dependency order, on an
+// overlay of the store, so that transactions spending unconfirmed coins see them. Every round, only the transactions
+// new to the mempool are fetched and parsed on top of the previous ones. Once a transaction confirms, gets replaced or
+// drops out of the mempool, the remaining ones are parsed again on the store.
+type Watcher struct {
+	source   extract.MempoolSource
+	db       store.Database
+	interval time.Duration
+	txs      map[string]extract.Transaction // Fetched mempool transactions, by txid. Only accessed by Update.
+	view     *view                          // Nil when the transactions must be parsed again. Only accessed by Update.
+	mutex    sync.RWMutex
+	snapshot *snapshot
+	logger   *zap.Logger
+}
+
+func NewWatcher(source extract.MempoolSource, db store.Database, interval time.Duration, logger *zap.Logger) *Watcher {
+	return &Watcher{
+		source:   source,
+		db:       db,
+		interval: interval,
+		txs:      make(map[string]extract.Transaction),
+		snapshot: &snapshot{},
+		logger:   logger,
+	}
+}
+
+// Run updates the pending activity every interval, it never returns.
+func (w *Watcher) Run() {
+	for {
+		if err := w.Update(); err != nil {
+			w.logger.Warn("w.Update", zap.Error(err))
+		}
+		time.Sleep(w.interval)
+	}
+}
+
+// GetActivity returns the unconfirmed activity of the address, nil if there's none.
+func (w *Watcher) GetActivity(address string) *Activity {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.snapshot.activities[address]
+}
+
+// GetSpend returns the unconfirmed spend of the UTXO, nil if there's none.
+func (w *Watcher) GetSpend(utxo string) *Spend {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.snapshot.spends[utxo]
+}
+
+// Update fetches the transactions new to the mempool and updates the pending activity.
+func (w *Watcher) Update() error {
+	txids, err := w.source.GetMempoolTxids()
+	if err != nil {
+		return err
+	}
+
+	inMempool := make(map[string]bool, len(txids))
+	for _, txid := range txids {
+		inMempool[txid] = true
+	}
+	for txid := range w.txs {
+		if !inMempool[txid] {
+			delete(w.txs, txid)
+			w.view = nil
+		}
+	}
+	added := make(map[string]extract.Transaction)
+	skipped := 0
+	for _, txid := range txids {
+		if _, ok := w.txs[txid]; ok {
+			continue
+		}
+		if len(w.txs) >= MAX_PENDING_TXS {
+			skipped++
+			continue
+		}
+		tx, err := w.source.GetTransaction(txid)
+		if err != nil {
+			// It may have left the mempool since listed.
+			w.logger.Debug("source.GetTransaction", zap.String("txid", txid), zap.Error(err))
+			continue
+		}
+		w.txs[txid] = tx
+		added[txid] = tx
+	}
+	if skipped > 0 {
+		w.logger.Info("too many unconfirmed transactions, skip the rest", zap.Int("tracked", len(w.txs)), zap.Int("skipped", skipped))
+	}
+
+	// A block applied while parsing would mix two states of the store, parse again if that happens.
+	for i := 0; i < MAX_REBUILD_ATTEMPTS; i++ {
+		height, _, err := w.db.GetStatus()
+		if err != nil {
+			return err
+		}
+		txs := added
+		if w.view == nil || w.view.height != height || w.view.spendsAny(added) {
+			w.view = newView(w.db, height, w.logger)
+			txs = w.txs
+		}
+		snapshot, err := w.view.extend(sortByDependency(txs))
+		if err != nil {
+			w.view = nil
+			return err
+		}
+		if current, _, err := w.db.GetStatus(); err != nil || current != height {
+			w.view = nil
+			continue
+		}
+
+		w.mutex.Lock()
+		w.snapshot = snapshot
+		w.mutex.Unlock()
+		return nil
+	}
+	w.logger.Info("store kept changing while parsing the mempool, retry next round")
+	return nil
+}
+
+// view is the overlay of the store at a height with the parsed transactions applied, and their activity.
+type view struct {
+	height   int
+	parser   *protocol.CarvProtocol
+	updater  *load.DbUpdater
+	block    *pendingBlock
+	parents  map[string]bool // Txids spent by the parsed transactions.
+	snapshot *snapshot
+	logger   *zap.Logger
+}
+
+func newView(db store.Database, height int, logger *zap.Logger) *view {
+	overlay := store.NewOverlay(db)
+	return &view{
+		height:  height,
+		parser:  protocol.NewCarvProtocol(overlay, logger),
+		updater: load.NewDbUpdater(overlay, logger),
+		block:   &pendingBlock{height: height + 1, time: int(time.Now().Unix())},
+		parents: make(map[string]bool),
+		snapshot: &snapshot{
+			activities: make(map[string]*Activity),
+			spends:     make(map[string]*Spend),
+		},
+		logger: logger,
+	}
+}
+
+// spendsAny returns whether a parsed transaction spends one of the transactions, which then can't be parsed after it.
+func (v *view) spendsAny(txs map[string]extract.Transaction) bool {
+	for txid := range txs {
+		if v.parents[txid] {
+			return true
+		}
+	}
+	return false
+}
+
+// extend parses the transactions after the ones already parsed, and returns the activity of all of them. Snapshots
+// returned before are not modified, readers may still hold them.
+func (v *view) extend(txs []extract.Transaction) (*snapshot, error) {
+	if len(txs) == 0 {
+		return v.snapshot, nil
+	}
+
+	snapshot := &snapshot{
+		activities: maps.Clone(v.snapshot.activities),
+		spends:     maps.Clone(v.snapshot.spends),
+	}
+	copied := make(map[string]bool)
+	activity := func(address string) *Activity {
+		if a, ok := snapshot.activities[address]; !ok {
+			snapshot.activities[address] = &Activity{}
+		} else if !copied[address] {
+			snapshot.activities[address] = &Activity{
+				BalanceChanges: append([]*BalanceChange(nil), a.BalanceChanges...),
+				Spends:         append([]*Spend(nil), a.Spends...),
+			}
+		}
+		copied[address] = true
+		return snapshot.activities[address]
+	}
+
+	for _, tx := range txs {
+		for _, vin := range tx.GetVin() {
+			v.parents[vin.GetTxid()] = true
+		}
+		newCoinEvents, balanceChangeEvents, err := v.parser.Parse(tx)
+		if err != nil {
+			v.logger.Debug("parser.Parse", zap.String("txid", tx.GetTxid()), zap.Error(err))
+			continue
+		}
+		if len(newCoinEvents) == 0 && len(balanceChangeEvents) == 0 {
+			continue
+		}
+
+		// Applied one by one, so that the next transactions see the coins of this one.
+		if err := v.updater.Update(&types.BatchUpdate{
+			Block: v.block,
+			TxUpdates: []*types.TxUpdate{{
+				Txid:                tx.GetTxid(),
+				NewCoinEvents:       newCoinEvents,
+				BalanceChangeEvents: balanceChangeEvents,
+			}},
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, event := range balanceChangeEvents {
+			a := activity(event.Address)
+			a.BalanceChanges = append(a.BalanceChanges, &BalanceChange{
+				Txid:   tx.GetTxid(),
+				CoinId: event.CoinId,
+				Delta:  event.Delta,
+				Utxo:   event.Utxo,
+				IsMint: event.IsMint,
+			})
+			if event.Delta.Sign() < 0 && len(event.Utxo) > 0 {
+				spend := &Spend{
+					Txid:   tx.GetTxid(),
+					Utxo:   event.Utxo,
+					CoinId: event.CoinId,
+					Owner:  event.Address,
+					Amount: event.Delta.Neg(),
+				}
+				a.Spends = append(a.Spends, spend)
+				snapshot.spends[event.Utxo] = spend
+			}
+		}
+	}
+	v.snapshot = snapshot
+	return snapshot, nil
+}
+
+// sortByDependency sorts the transactions by txid, then moves parents in front of their children.
+func sortByDependency(txs map[string]extract.Transaction) []extract.Transaction {
+	txids := make([]string, 0, len(txs))
+	for txid := range txs {
+		txids = append(txids, txid)
+	}
+	sort.Strings(txids)
+
+	sorted := make([]extract.Transaction, 0, len(txs))
+	visited := make(map[string]bool, len(txs))
+	var visit func(txid string)
+	visit = func(txid string) {
+		tx, ok := txs[txid]
+		if !ok || visited[txid] {
+			return
+		}
+		visited[txid] = true
+		for _, vin := range tx.GetVin() {
+			visit(vin.GetTxid())
+		}
+		sorted = append(sorted, tx)
+	}
+	for _, txid := range txids {
+		visit(txid)
+	}
+	return sorted
+}
+
+// pendingBlock stands for the next block, which unconfirmed transactions are applied on.
+type pendingBlock struct {
+	height int
+	time   int
+}
+
+var _ extract.Block = (*pendingBlock)(nil)
+
+func (b *pendingBlock) GetHash() string {
+	return ""
+}
+
+func (b *pendingBlock) GetPrevHash() string {
+	return ""
+}
+
+func (b *pendingBlock) GetTime() int {
+	return b.time
+}
+
+func (b *pendingBlock) GetHeight() int {
+	return b.height
+}
+
+func (b *pendingBlock) GetTxs() []extract.Transaction {
+	return nil
+}
@@ -0,0 +1,209 @@
+package pending
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/extract/mempool"
+	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var CARV_ASM = "OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405"
+
+type fakeMempool struct {
+	txs     map[string]*mempool.Transaction
+	fetched int
+}
+
+var _ extract.MempoolSource = (*fakeMempool)(nil)
+
+func (m *fakeMempool) GetMempoolTxids() ([]string, error) {
+	var txids []string
+	for txid := range m.txs {
+		txids = append(txids, txid)
+	}
+	return txids, nil
+}
+
+func (m *fakeMempool) GetTransaction(txid string) (extract.Transaction, error) {
+	tx, ok := m.txs[txid]
+	if !ok {
+		return nil, fmt.Errorf("tx not found: %s", txid)
+	}
+	m.fetched++
+	return tx, nil
+}
+
+// carvTx moves Carv coins from the given UTXOs, or mints them without inputs, to the address.
+func carvTx(txid string, address string, amount int, utxos ...string) *mempool.Transaction {
+	tx := &mempool.Transaction{
+		Txid: txid,
+		Vout: []mempool.Vout{
+			{Address: address, Value: float64(amount * 10000)},
+			{Asm: CARV_ASM},
+		},
+	}
+	for _, utxo := range utxos {
+		prevTxid, vout, _ := strings.Cut(utxo, ":")
+		n, _ := strconv.Atoi(vout)
+		tx.Vin = append(tx.Vin, mempool.Vin{Txid: prevTxid, Vout: n})
+	}
+	return tx
+}
+
+func setup() (*store.MemDb, *fakeMempool, *Watcher) {
+	logger, _ := zap.NewDevelopment()
+	db := store.NewMemDb("", "testnet", 100, false, logger)
+	db.ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		CoinInfos: map[string]*types.CoinInfo{"CARV": {
+			Id:          "CARV",
+			Protocol:    "carv",
+			TotalSupply: types.NewAmount(2),
+			Args:        types.CoinArgs{Carv: &types.CarvArgs{Max: 100, Sats: 10000, Limit: 5}},
+		}},
+		Balances: map[string]map[string]types.Amount{"CARV": {"a1": types.NewAmount(2)}},
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			"c1:0": {"CARV": {CoinId: "CARV", Protocol: "carv", Owner: "a1", Amount: types.NewAmount(2), Utxo: "c1:0"}},
+		},
+	})
+	source := &fakeMempool{txs: make(map[string]*mempool.Transaction)}
+	return db, source, NewWatcher(source, db, time.Second, logger)
+}
+
+func TestWatcherChain(t *testing.T) {
+	_, source, w := setup()
+	// The child is sorted before its parent by txid.
+	source.txs["s2"] = carvTx("s2", "a3", 2, "t1:0")
+	source.txs["t1"] = carvTx("t1", "a2", 2, "c1:0")
+	source.txs["m1"] = carvTx("m1", "a4", 3)
+	source.txs["x1"] = &mempool.Transaction{Txid: "x1", Vin: []mempool.Vin{{Txid: "c2", Vout: 0}}}
+	assert.Nil(t, w.Update())
+
+	assert.Equal(t, &Activity{
+		Spends: []*Spend{{Txid: "t1", Utxo: "c1:0", CoinId: "CARV", Owner: "a1", Amount: types.NewAmount(2)}},
+		BalanceChanges: []*BalanceChange{
+			{Txid: "t1", CoinId: "CARV", Delta: types.NewAmount(-2), Utxo: "c1:0"},
+		},
+	}, w.GetActivity("a1"))
+	assert.Equal(t, &Activity{
+		Spends: []*Spend{{Txid: "s2", Utxo: "t1:0", CoinId: "CARV", Owner: "a2", Amount: types.NewAmount(2)}},
+		BalanceChanges: []*BalanceChange{
+			{Txid: "t1", CoinId: "CARV", Delta: types.NewAmount(2), Utxo: "t1:0"},
+			{Txid: "s2", CoinId: "CARV", Delta: types.NewAmount(-2), Utxo: "t1:0"},
+		},
+	}, w.GetActivity("a2"))
+	assert.Equal(t, types.NewAmount(2), w.GetActivity("a3").BalanceChanges[0].Delta)
+	assert.Equal(t, &BalanceChange{Txid: "m1", CoinId: "CARV", Delta: types.NewAmount(3), Utxo: "m1:0", IsMint: true}, w.GetActivity("a4").BalanceChanges[0])
+	assert.Equal(t, "s2", w.GetSpend("t1:0").Txid)
+	assert.Nil(t, w.GetSpend("c2:0"))
+	assert.Nil(t, w.GetActivity("a5"))
+}
+
+func TestWatcherEviction(t *testing.T) {
+	db, source, w := setup()
+	source.txs["t1"] = carvTx("t1", "a2", 2, "c1:0")
+	source.txs["m1"] = carvTx("m1", "a4", 3)
+	assert.Nil(t, w.Update())
+	assert.NotNil(t, w.GetActivity("a2"))
+	assert.Equal(t, 2, source.fetched)
+
+	// t1 is replaced by t1', m1 is dropped.
+	delete(source.txs, "t1")
+	delete(source.txs, "m1")
+	source.txs["t1'"] = carvTx("t1'", "a3", 2, "c1:0")
+	assert.Nil(t, w.Update())
+	assert.Nil(t, w.GetActivity("a2"))
+	assert.Nil(t, w.GetActivity("a4"))
+	assert.Equal(t, "t1'", w.GetSpend("c1:0").Txid)
+	assert.Equal(t, 3, source.fetched)
+
+	// t1' confirms.
+	db.ApplyBlock(&types.BlockUpdate{
+		Height:   2,
+		Balances: map[string]map[string]types.Amount{"CARV": {"a1": types.NewAmount(-2), "a3": types.NewAmount(2)}},
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			"c1:0":  {"CARV": nil},
+			"t1':0": {"CARV": {CoinId: "CARV", Protocol: "carv", Owner: "a3", Amount: types.NewAmount(2), Utxo: "t1':0"}},
+		},
+	})
+	delete(source.txs, "t1'")
+	assert.Nil(t, w.Update())
+	assert.Nil(t, w.GetActivity("a1"))
+	assert.Nil(t, w.GetActivity("a3"))
+	assert.Nil(t, w.GetSpend("c1:0"))
+	assert.Equal(t, 0, len(w.txs))
+}
+
+func TestWatcherIncremental(t *testing.T) {
+	_, source, w := setup()
+	source.txs["t1"] = carvTx("t1", "a2", 2, "c1:0")
+	assert.Nil(t, w.Update())
+	view := w.view
+	first := w.GetActivity("a2")
+
+	// New transactions are parsed on top of the previous ones.
+	source.txs["s2"] = carvTx("s2", "a3", 2, "t1:0")
+	assert.Nil(t, w.Update())
+	assert.Same(t, view, w.view)
+	assert.Equal(t, 2, source.fetched)
+	assert.Equal(t, "s2", w.GetSpend("t1:0").Txid)
+	assert.Equal(t, 2, len(w.GetActivity("a2").BalanceChanges))
+	// Snapshots already served are not modified.
+	assert.Equal(t, 1, len(first.BalanceChanges))
+
+	// Nothing new, nothing parsed.
+	assert.Nil(t, w.Update())
+	assert.Same(t, view, w.view)
+	assert.Equal(t, 2, source.fetched)
+}
+
+// A parent listed after its child is parsed before it again.
+func TestWatcherLateParent(t *testing.T) {
+	_, source, w := setup()
+	source.txs["s2"] = carvTx("s2", "a3", 2, "t1:0")
+	assert.Nil(t, w.Update())
+	assert.Nil(t, w.GetSpend("t1:0"))
+
+	source.txs["t1"] = carvTx("t1", "a2", 2, "c1:0")
+	assert.Nil(t, w.Update())
+	assert.Equal(t, "t1", w.GetSpend("c1:0").Txid)
+	assert.Equal(t, "s2", w.GetSpend("t1:0").Txid)
+	assert.Equal(t, types.NewAmount(2), w.GetActivity("a3").BalanceChanges[0].Delta)
+}
+
+func TestWatcherMaxPendingTxs(t *testing.T) {
+	defer func(max int) { MAX_PENDING_TXS = max }(MAX_PENDING_TXS)
+	MAX_PENDING_TXS = 2
+
+	_, source, w := setup()
+	source.txs["m1"] = carvTx("m1", "a1", 1)
+	source.txs["m2"] = carvTx("m2", "a2", 1)
+	source.txs["m3"] = carvTx("m3", "a3", 1)
+	assert.Nil(t, w.Update())
+	assert.Equal(t, 2, len(w.txs))
+	assert.Equal(t, 2, source.fetched)
+
+	// The skipped transaction is tracked once another leaves the mempool.
+	var skipped string
+	for txid := range source.txs {
+		if _, ok := w.txs[txid]; !ok {
+			skipped = txid
+		}
+	}
+	for txid := range w.txs {
+		delete(source.txs, txid)
+		break
+	}
+	assert.Nil(t, w.Update())
+	assert.Equal(t, 2, len(w.txs))
+	assert.Equal(t, 3, source.fetched)
+	assert.Equal(t, types.NewAmount(1), w.GetActivity("a"+skipped[1:]).BalanceChanges[0].Delta)
+}
@@ -0,0 +1,19 @@
+package pipeline
+
+import "expvar"
+
+// Metrics are published by expvar under "pipeline".
+var (
+	metrics         = expvar.NewMap("pipeline")
+	tipHeight       = new(expvar.Int)
+	indexedHeight   = new(expvar.Int)
+	prefetchDepth   = new(expvar.Int)
+	blocksProcessed = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("tip_height", tipHeight)
+	metrics.Set("indexed_height", indexedHeight)
+	metrics.Set("prefetch_depth", prefetchDepth)
+	metrics.Set("blocks_processed", blocksProcessed)
+}
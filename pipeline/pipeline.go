@@ -0,0 +1,168 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/load"
+	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/transform"
+	"go.uber.org/zap"
+)
+
+var (
+	MIN_POLL_INTERVAL = time.Second
+)
+
+// Pipeline runs the extract, transform and load stages block by block.
+type Pipeline struct {
+	source      extract.BlockSource
+	notifier    extract.Notifier
+	window      int
+	workers     int
+	maxInterval time.Duration
+	db          store.Database
+	transformer *transform.BitcoinTransformer
+	updater     *load.DbUpdater
+	logger      *zap.Logger
+}
+
+// NewPipeline creates a pipeline. The notifier is optional, the tip is polled with backoff up to maxInterval either way.
+// Up to window blocks are prefetched by the given number of workers.
+func NewPipeline(source extract.BlockSource, notifier extract.Notifier, window int, workers int, maxInterval time.Duration, db store.Database, transformer *transform.BitcoinTransformer, updater *load.DbUpdater, logger *zap.Logger) *Pipeline {
+	return &Pipeline{
+		source:      source,
+		notifier:    notifier,
+		window:      window,
+		workers:     workers,
+		maxInterval: maxInterval,
+		db:          db,
+		transformer: transformer,
+		updater:     updater,
+		logger:      logger,
+	}
+}
+
+// Run indexes blocks from the given height, it never returns. Once at the tip, or if the source fails, it waits for
+// the notifier or the poll interval, which doubles up to the max interval until a block is processed.
+func (p *Pipeline) Run(height int) {
+	interval := MIN_POLL_INTERVAL
+	for {
+		tip, err := p.source.GetLatestBlockHeight()
+		if err != nil {
+			p.logger.Warn("source.GetLatestBlockHeight", zap.Error(err))
+			interval = p.wait(interval)
+			continue
+		}
+		tipHeight.Set(int64(tip))
+		if height > tip {
+			interval = p.wait(interval)
+			continue
+		}
+
+		next, err := p.catchUp(height, tip)
+		if next != height {
+			interval = MIN_POLL_INTERVAL
+		}
+		height = next
+		if err != nil {
+			// The chain may also have been reorganized to a lower tip.
+			p.logger.Warn("failed to fetch or transform block", zap.Int("height", height), zap.Error(err))
+			interval = p.wait(interval)
+		}
+	}
+}
+
+// catchUp indexes the blocks from height to tip and returns the next height to index. It stops early if a block failed
+// to be fetched or transformed, or the chain was reorganized, so that the caller fetches the tip again.
+func (p *Pipeline) catchUp(height int, tip int) (int, error) {
+	prefetcher := NewPrefetcher(p.source, height, tip, p.window, p.workers)
+	defer prefetcher.Close()
+
+	for height <= tip {
+		block, err := prefetcher.Next()
+		prefetchDepth.Set(int64(prefetcher.Depth()))
+		if err != nil {
+			return height, err
+		}
+
+		// The store can't be trusted anymore if a block failed to apply, stop here and reload it on restart. The store is
+		// untouched by a failed transformation, which is retried.
+		next, err := p.Process(height, block)
+		var failed *transformError
+		if errors.As(err, &failed) {
+			return height, err
+		} else if err != nil {
+			p.logger.Fatal("p.Process", zap.Int("height", height), zap.Error(err))
+		}
+		if next != height+1 {
+			return next, nil
+		}
+		height = next
+	}
+	return height, nil
+}
+
+// wait returns after the interval, or earlier if the notifier signals a new block, then returns the next interval.
+func (p *Pipeline) wait(interval time.Duration) time.Duration {
+	var notified <-chan struct{}
+	if p.notifier != nil {
+		notified = p.notifier.Notify()
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-notified:
+		return MIN_POLL_INTERVAL
+	case <-timer.C:
+		return min(2*interval, p.maxInterval)
+	}
+}
+
+// transformError is a block which failed to be transformed, nothing was applied to the store.
+type transformError struct {
+	height int
+	err    error
+}
+
+func (e *transformError) Error() string {
+	return fmt.Sprintf("failed to transform block %d: %v", e.height, e.err)
+}
+
+func (e *transformError) Unwrap() error {
+	return e.err
+}
+
+// Process indexes the block at the given height and returns the next height to index. If the block doesn't connect to
+// the indexed chain, the tip is reverted instead, so that a reorganization is walked back one block at a time. A block
+// failing to be transformed leaves the store untouched, the caller retries it after backing off.
+func (p *Pipeline) Process(height int, block extract.Block) (int, error) {
+	hash, err := p.db.GetBlockHash(height - 1)
+	if err != nil {
+		return height, err
+	}
+	if len(hash) > 0 && hash != block.GetPrevHash() {
+		p.logger.Warn("chain reorganization detected", zap.Int("height", height), zap.String("prevHash", block.GetPrevHash()), zap.String("indexedHash", hash))
+		if err := p.db.RevertBlocks(1); err != nil {
+			return height, err
+		}
+		return height - 1, nil
+	}
+
+	batchUpdate, err := p.transformer.Transform(block)
+	if err != nil {
+		return height, &transformError{height: height, err: err}
+	}
+
+	if err := p.updater.Update(batchUpdate); err != nil {
+		return height, err
+	}
+
+	indexedHeight.Set(int64(height))
+	blocksProcessed.Add(1)
+	p.logger.Debug("Block processed", zap.Int("height", height))
+	return height + 1, nil
+}
@@ -0,0 +1,274 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/extract/mempool"
+	"github.com/decentralize-everything/indexer/load"
+	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/transform"
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// fakeSource serves a chain of blocks from memory, the first block is at height 1.
+type fakeSource struct {
+	mutex  sync.Mutex
+	blocks []*mempool.Block
+	calls  int
+}
+
+var _ extract.BlockSource = (*fakeSource)(nil)
+
+func (s *fakeSource) GetLatestBlockHeight() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls++
+	return len(s.blocks), nil
+}
+
+func (s *fakeSource) GetBlockHash(height int) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if height < 1 || height > len(s.blocks) {
+		return "", fmt.Errorf("block not found: %d", height)
+	}
+	return s.blocks[height-1].Hash, nil
+}
+
+func (s *fakeSource) GetBlock(hash string) (extract.Block, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, block := range s.blocks {
+		if block.Hash == hash {
+			return block, nil
+		}
+	}
+	return nil, fmt.Errorf("block not found: %s", hash)
+}
+
+func (s *fakeSource) addBlock(block *mempool.Block) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blocks = append(s.blocks, block)
+}
+
+func (s *fakeSource) getCalls() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls
+}
+
+// fakeNotifier stands in for the ZeroMQ notifier.
+type fakeNotifier struct {
+	notify chan struct{}
+}
+
+var _ extract.Notifier = (*fakeNotifier)(nil)
+
+func (n *fakeNotifier) Notify() <-chan struct{} {
+	return n.notify
+}
+
+func newBlock(height int, hash string, prevHash string, txs ...mempool.Transaction) *mempool.Block {
+	return &mempool.Block{
+		Hash:     hash,
+		PrevHash: prevHash,
+		Height:   height,
+		Tx:       txs,
+	}
+}
+
+var deployTx = mempool.Transaction{
+	Txid: "d1",
+	Vout: []mempool.Vout{
+		{
+			Asm: "OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_10 82a4058980dd40cd1001",
+		},
+	},
+}
+
+func mintTx(txid string, address string) mempool.Transaction {
+	return mempool.Transaction{
+		Txid: txid,
+		Vout: []mempool.Vout{
+			{
+				Address: address,
+				Value:   10000,
+			},
+			{
+				Asm: "OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405",
+			},
+		},
+	}
+}
+
+func setup(source extract.BlockSource, notifier extract.Notifier) (*store.MemDb, *Pipeline) {
+	logger, _ := zap.NewDevelopment()
+	db := store.NewMemDb("", "testnet", 100, false, logger)
+	return db, NewPipeline(source, notifier, 4, 2, 30*time.Second, db, transform.NewBitcoinTransformer(db, []string{"carv"}, logger), load.NewDbUpdater(db, logger), logger)
+}
+
+// indexToTip does what Run does, until the tip of the source.
+func indexToTip(t *testing.T, p *Pipeline, source extract.BlockSource, height int) int {
+	for {
+		tip, _ := source.GetLatestBlockHeight()
+		if height > tip {
+			return height
+		}
+
+		hash, err := source.GetBlockHash(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := source.GetBlock(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if height, err = p.Process(height, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPipelineProcess(t *testing.T) {
+	source := &fakeSource{
+		blocks: []*mempool.Block{
+			newBlock(1, "h1", "h0", deployTx),
+			newBlock(2, "h2", "h1", mintTx("m1", "a1")),
+			newBlock(3, "h3", "h2", mintTx("m2", "a2")),
+		},
+	}
+	db, p := setup(source, nil)
+
+	assert.Equal(t, 4, indexToTip(t, p, source, 1))
+
+	height, _, _ := db.GetStatus()
+	assert.Equal(t, 3, height)
+	ci, _ := db.GetCoinInfoById("CARV")
+	assert.Equal(t, types.NewAmount(2), ci.TotalSupply)
+	balances, _ := db.GetBalancesByAddress("a2")
+	assert.Equal(t, map[string]types.Amount{"CARV": types.NewAmount(1)}, balances)
+}
+
+func TestPipelineReorg(t *testing.T) {
+	source := &fakeSource{
+		blocks: []*mempool.Block{
+			newBlock(1, "h1", "h0", deployTx),
+			newBlock(2, "h2", "h1", mintTx("m1", "a1")),
+		},
+	}
+	db, p := setup(source, nil)
+	height := indexToTip(t, p, source, 1)
+
+	// Block 2 is replaced, the new chain is one block longer.
+	source.blocks = []*mempool.Block{
+		source.blocks[0],
+		newBlock(2, "h2'", "h1", mintTx("m2", "a2")),
+		newBlock(3, "h3'", "h2'", mintTx("m3", "a2")),
+	}
+	assert.Equal(t, 4, indexToTip(t, p, source, height))
+
+	hash, _ := db.GetBlockHash(2)
+	assert.Equal(t, "h2'", hash)
+	ci, _ := db.GetCoinInfoById("CARV")
+	assert.Equal(t, types.NewAmount(2), ci.TotalSupply)
+	balances, _ := db.GetBalancesByAddress("a1")
+	assert.Equal(t, 0, len(balances))
+	balances, _ = db.GetBalancesByAddress("a2")
+	assert.Equal(t, map[string]types.Amount{"CARV": types.NewAmount(2)}, balances)
+}
+
+func TestPipelineRun(t *testing.T) {
+	MIN_POLL_INTERVAL = time.Hour
+	defer func() { MIN_POLL_INTERVAL = time.Second }()
+
+	source := &fakeSource{
+		blocks: []*mempool.Block{
+			newBlock(1, "h1", "h0", deployTx),
+			newBlock(2, "h2", "h1", mintTx("m1", "a1")),
+		},
+	}
+	notifier := &fakeNotifier{notify: make(chan struct{}, 1)}
+	db, p := setup(source, notifier)
+	p.maxInterval = time.Hour
+	go p.Run(1)
+
+	indexed := func(height int) func() bool {
+		return func() bool {
+			h, _, _ := db.GetStatus()
+			return h == height
+		}
+	}
+	// Catching up fetches the tip once, then once more at the tip before waiting.
+	assert.Eventually(t, indexed(2), time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return source.getCalls() == 2 }, time.Second, 10*time.Millisecond)
+
+	// Polling would only happen an hour later.
+	source.addBlock(newBlock(3, "h3", "h2", mintTx("m2", "a2")))
+	notifier.notify <- struct{}{}
+	assert.Eventually(t, indexed(3), time.Second, 10*time.Millisecond)
+	balances, _ := db.GetBalancesByAddress("a2")
+	assert.Equal(t, map[string]types.Amount{"CARV": types.NewAmount(1)}, balances)
+}
+
+func TestPipelineWait(t *testing.T) {
+	MIN_POLL_INTERVAL = time.Millisecond
+	defer func() { MIN_POLL_INTERVAL = time.Second }()
+
+	notifier := &fakeNotifier{notify: make(chan struct{}, 1)}
+	_, p := setup(&fakeSource{}, notifier)
+	p.maxInterval = 3 * time.Millisecond
+	assert.Equal(t, 2*time.Millisecond, p.wait(time.Millisecond))
+	assert.Equal(t, 3*time.Millisecond, p.wait(2*time.Millisecond))
+	assert.Equal(t, 3*time.Millisecond, p.wait(3*time.Millisecond))
+
+	notifier.notify <- struct{}{}
+	assert.Equal(t, time.Millisecond, p.wait(time.Hour))
+}
+
+// flakyDb fails reading coins of UTXOs until healed.
+type flakyDb struct {
+	store.Database
+	failing bool
+}
+
+func (db *flakyDb) GetCoinsInUtxos(utxos []string) ([]*types.UnspentCoin, error) {
+	if db.failing {
+		return nil, errors.New("store unavailable")
+	}
+	return db.Database.GetCoinsInUtxos(utxos)
+}
+
+// A block failing to be transformed stops the catch up, leaving the store untouched, and is retried.
+func TestPipelineTransformError(t *testing.T) {
+	source := &fakeSource{
+		blocks: []*mempool.Block{
+			newBlock(1, "h1", "h0", deployTx),
+			newBlock(2, "h2", "h1", mintTx("m1", "a1")),
+		},
+	}
+	db, p := setup(source, nil)
+	flaky := &flakyDb{Database: db, failing: true}
+	p.transformer = transform.NewBitcoinTransformer(flaky, []string{"carv"}, zap.NewNop())
+
+	height, err := p.catchUp(1, 2)
+	var failed *transformError
+	assert.ErrorAs(t, err, &failed)
+	assert.Equal(t, 1, height)
+	indexed, _, _ := db.GetStatus()
+	assert.Equal(t, 0, indexed)
+
+	flaky.failing = false
+	height, err = p.catchUp(height, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, height)
+	balances, _ := db.GetBalancesByAddress("a1")
+	assert.Equal(t, map[string]types.Amount{"CARV": types.NewAmount(1)}, balances)
+}
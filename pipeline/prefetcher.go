@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"github.com/decentralize-everything/indexer/extract"
+)
+
+// Prefetcher fetches blocks of a height range with concurrent workers, and hands them out in height order. At most
+// window blocks are fetched ahead of the consumer. It only reads from the block source, so blocks are still
+// transformed and loaded one by one, in order.
+type Prefetcher struct {
+	source extract.BlockSource
+	queue  chan chan prefetched // Results in height order, bounded by the window.
+	stop   chan struct{}
+}
+
+type prefetched struct {
+	block extract.Block
+	err   error
+}
+
+type prefetchJob struct {
+	height int
+	result chan prefetched
+}
+
+// NewPrefetcher starts fetching blocks from height from to height to, both included.
+func NewPrefetcher(source extract.BlockSource, from int, to int, window int, workers int) *Prefetcher {
+	p := &Prefetcher{
+		source: source,
+		queue:  make(chan chan prefetched, max(window, 1)),
+		stop:   make(chan struct{}),
+	}
+
+	jobs := make(chan prefetchJob)
+	go func() {
+		defer close(jobs)
+		for height := from; height <= to; height++ {
+			result := make(chan prefetched, 1)
+			select {
+			case p.queue <- result:
+			case <-p.stop:
+				return
+			}
+			select {
+			case jobs <- prefetchJob{height: height, result: result}:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	for i := 0; i < max(workers, 1); i++ {
+		go func() {
+			for job := range jobs {
+				job.result <- p.fetch(job.height)
+			}
+		}()
+	}
+	return p
+}
+
+func (p *Prefetcher) fetch(height int) prefetched {
+	hash, err := p.source.GetBlockHash(height)
+	if err != nil {
+		return prefetched{err: err}
+	}
+	block, err := p.source.GetBlock(hash)
+	return prefetched{block: block, err: err}
+}
+
+// Next waits for the next block in height order. It must not be called more times than the number of blocks in the
+// range.
+func (p *Prefetcher) Next() (extract.Block, error) {
+	next := <-p.queue
+	result := <-next
+	return result.block, result.err
+}
+
+// Depth returns the number of blocks fetched or being fetched ahead of the consumer.
+func (p *Prefetcher) Depth() int {
+	return len(p.queue)
+}
+
+// Close stops fetching, blocks being fetched are dropped.
+func (p *Prefetcher) Close() {
+	close(p.stop)
+}
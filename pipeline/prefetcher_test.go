@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/extract/mempool"
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowSource returns blocks of any height, the lower the height the slower, and records the highest height fetched.
+type slowSource struct {
+	mutex     sync.Mutex
+	maxHeight int
+	failed    int
+}
+
+var _ extract.BlockSource = (*slowSource)(nil)
+
+func (s *slowSource) GetLatestBlockHeight() (int, error) {
+	return 0, fmt.Errorf("not implemented")
+}
+
+func (s *slowSource) GetBlockHash(height int) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if height == s.failed {
+		return "", fmt.Errorf("block not found: %d", height)
+	}
+	s.maxHeight = max(s.maxHeight, height)
+	return fmt.Sprintf("h%d", height), nil
+}
+
+func (s *slowSource) GetBlock(hash string) (extract.Block, error) {
+	var height int
+	fmt.Sscanf(hash, "h%d", &height)
+	time.Sleep(time.Duration(10-height%10) * time.Millisecond)
+	return newBlock(height, hash, fmt.Sprintf("h%d", height-1)), nil
+}
+
+func (s *slowSource) getMaxHeight() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.maxHeight
+}
+
+func TestPrefetcherOrder(t *testing.T) {
+	source := &slowSource{}
+	prefetcher := NewPrefetcher(source, 1, 30, 8, 4)
+	defer prefetcher.Close()
+
+	for height := 1; height <= 30; height++ {
+		block, err := prefetcher.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, height, block.GetHeight())
+	}
+	assert.Equal(t, 30, source.getMaxHeight())
+}
+
+func TestPrefetcherWindow(t *testing.T) {
+	source := &slowSource{}
+	prefetcher := NewPrefetcher(source, 1, 100, 8, 4)
+	defer prefetcher.Close()
+
+	_, err := prefetcher.Next()
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	// The consumer holds block 1, blocks 2 to 9 fill the window, and block 10 waits for a slot.
+	assert.Equal(t, 9, source.getMaxHeight())
+	assert.Equal(t, 8, prefetcher.Depth())
+}
+
+func TestPrefetcherError(t *testing.T) {
+	source := &slowSource{failed: 3}
+	prefetcher := NewPrefetcher(source, 1, 10, 4, 2)
+	defer prefetcher.Close()
+
+	for height := 1; height <= 2; height++ {
+		block, err := prefetcher.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, height, block.GetHeight())
+	}
+	_, err := prefetcher.Next()
+	assert.Equal(t, "block not found: 3", err.Error())
+}
+
+func TestPipelineRunWithPrefetch(t *testing.T) {
+	blocks := []*mempool.Block{newBlock(1, "h1", "h0", deployTx)}
+	for height := 2; height <= 50; height++ {
+		blocks = append(blocks, newBlock(height, fmt.Sprintf("h%d", height), fmt.Sprintf("h%d", height-1), mintTx(fmt.Sprintf("m%d", height), "a1")))
+	}
+	source := &fakeSource{blocks: blocks}
+	db, p := setup(source, nil)
+	go p.Run(1)
+
+	assert.Eventually(t, func() bool {
+		height, _, _ := db.GetStatus()
+		return height == 50
+	}, 5*time.Second, 10*time.Millisecond)
+	balances, _ := db.GetBalancesByAddress("a1")
+	assert.Equal(t, map[string]types.Amount{"CARV": types.NewAmount(49)}, balances)
+}
@@ -0,0 +1,480 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/types"
+	"go.uber.org/zap"
+)
+
+/*
+BRC-20 operations supported by Brc20Protocol:
+  - An inscription is read from the envelope (OP_FALSE OP_IF "ord" fields OP_0
+    body OP_ENDIF) in the tapscript of the first input, and lands on the first
+    output. Inscriptions of other inputs are not located, since input values
+    are not available.
+  - Deploy creates the coin whose ID is the lowercased tick, with Args max,
+    limit and decimals. The tick is 4 bytes of UTF-8 as other indexers count
+    it, not 4 characters, and must not contain the "/" separating the segments
+    of store keys. Amounts are scaled by 10^decimals and must fit
+    into 128 bits.
+  - Mint credits the available balance of the first output's owner, a mint over
+    the remaining supply is clamped.
+  - Transfer moves the amount from the available balance to a transfer
+    inscription, which is kept as an UnspentCoin at txid:0. Balances of the
+    store count both available and transferable amounts.
+  - Spending a transfer inscription as the first input credits the owner of the
+    first output, or the sender if the first output has no address. Transfer
+    inscriptions spent by other inputs return to the sender.
+*/
+var (
+	BRC20_PROTOCOL      = "brc-20"
+	BRC20_TICK_LEN      = 4   // In bytes.
+	BRC20_TICK_RESERVED = "/" // Separator of store keys.
+	BRC20_MAX_DECIMALS  = uint64(18)
+
+	INSCRIPTION_PROTOCOL_ID      = []byte("ord")
+	INSCRIPTION_TAG_CONTENT_TYPE = []byte{1}
+)
+
+type Brc20Protocol struct {
+	db      store.Database
+	pending *brc20Pending
+	logger  *zap.Logger
+}
+
+var _ BlockParser = (*Brc20Protocol)(nil)
+
+func NewBrc20Protocol(db store.Database, logger *zap.Logger) *Brc20Protocol {
+	return &Brc20Protocol{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// brc20Pending keeps the changes of the block being parsed, which are not in the store until the whole block is applied.
+type brc20Pending struct {
+	coins     map[string]*types.CoinInfo         // Coins deployed or minted.
+	available map[string]map[string]types.Amount // Available balance deltas, by coin ID and address.
+	transfers map[string]*types.UnspentCoin      // Transfer inscriptions, nil marks a spent one.
+}
+
+// StartBlock drops the changes of the previous block, which are in the store once applied, or orphaned by a reorg.
+func (p *Brc20Protocol) StartBlock(height int) {
+	p.pending = &brc20Pending{
+		coins:     make(map[string]*types.CoinInfo),
+		available: make(map[string]map[string]types.Amount),
+		transfers: make(map[string]*types.UnspentCoin),
+	}
+}
+
+func (p *Brc20Protocol) Parse(tx extract.Transaction) ([]*types.NewCoinEvent, []*types.BalanceChangeEvent, error) {
+	if p.pending == nil {
+		p.StartBlock(0)
+	}
+
+	if len(tx.GetVin()) == 0 || len(tx.GetVout()) == 0 {
+		return nil, nil, nil
+	}
+
+	balanceChangeEvents, err := p.parseTransfers(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newCoinEvents, events, err := p.parseInscription(tx)
+	if err != nil {
+		var invalid *InvalidOperationError
+		if len(balanceChangeEvents) == 0 || !errors.As(err, &invalid) {
+			return nil, nil, err
+		}
+		// The transfer inscriptions spent by the transaction still move.
+		p.logger.Info("invalid BRC-20 operation", zap.String("tx", tx.GetTxid()), zap.Error(err))
+		return nil, balanceChangeEvents, nil
+	}
+	return newCoinEvents, append(balanceChangeEvents, events...), nil
+}
+
+// parseTransfers moves the transfer inscriptions spent by the transaction.
+func (p *Brc20Protocol) parseTransfers(tx extract.Transaction) ([]*types.BalanceChangeEvent, error) {
+	var balanceChangeEvents []*types.BalanceChangeEvent
+
+	utxos := make([]string, 0, len(tx.GetVin()))
+	for _, vin := range tx.GetVin() {
+		utxos = append(utxos, vin.GetTxid()+":"+strconv.Itoa(vin.GetVout()))
+	}
+	inputs, err := p.db.GetCoinsInUtxos(utxos)
+	if err != nil {
+		return nil, err
+	}
+	transfers := make(map[string]*types.UnspentCoin)
+	for _, coin := range inputs {
+		if coin.Protocol == "brc20" {
+			transfers[coin.Utxo] = coin
+		}
+	}
+
+	for i, utxo := range utxos {
+		coin, ok := p.pending.transfers[utxo]
+		if !ok {
+			coin = transfers[utxo]
+		}
+		if coin == nil {
+			continue
+		}
+
+		receiver := coin.Owner
+		if i == 0 && len(tx.GetVout()[0].GetAddress()) > 0 {
+			receiver = tx.GetVout()[0].GetAddress()
+		}
+		balanceChangeEvents = append(balanceChangeEvents, &types.BalanceChangeEvent{
+			ChainId:  "bitcoin",
+			Protocol: "brc20",
+			CoinId:   coin.CoinId,
+			Address:  coin.Owner,
+			Delta:    coin.Amount.Neg(),
+			Utxo:     coin.Utxo,
+		}, &types.BalanceChangeEvent{
+			ChainId:  "bitcoin",
+			Protocol: "brc20",
+			CoinId:   coin.CoinId,
+			Address:  receiver,
+			Delta:    coin.Amount,
+		})
+		p.pending.transfers[utxo] = nil
+		if err := p.addAvailable(coin.CoinId, receiver, coin.Amount); err != nil {
+			return nil, err
+		}
+	}
+	return balanceChangeEvents, nil
+}
+
+// parseInscription applies the BRC-20 operation inscribed by the transaction, if any.
+func (p *Brc20Protocol) parseInscription(tx extract.Transaction) ([]*types.NewCoinEvent, []*types.BalanceChangeEvent, error) {
+	contentType, body, ok := decodeInscription(tx.GetVin()[0].GetWitness())
+	if !ok || (!strings.HasPrefix(contentType, "text/plain") && !strings.HasPrefix(contentType, "application/json")) {
+		return nil, nil, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil || fields["p"] != BRC20_PROTOCOL {
+		return nil, nil, nil
+	}
+	op, _ := fields["op"].(string)
+	tick, _ := fields["tick"].(string)
+	if len(tick) != BRC20_TICK_LEN || strings.Contains(tick, BRC20_TICK_RESERVED) {
+		return nil, nil, invalidBrc20("", "invalid BRC-20 tick: %v", fields["tick"])
+	}
+	id := strings.ToLower(tick)
+
+	owner := tx.GetVout()[0].GetAddress()
+	if len(owner) == 0 {
+		return nil, nil, invalidBrc20(id, "BRC-20 %s inscription of %s sent to output without address, tx = %s", op, id, tx.GetTxid())
+	}
+
+	ci, err := p.getCoinInfo(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch op {
+	case "deploy":
+		if ci != nil {
+			return nil, nil, invalidBrc20(id, "BRC-20 tick already deployed: %s", id)
+		}
+
+		decimals := BRC20_MAX_DECIMALS
+		if s, ok := fields["dec"]; ok {
+			d, ok := s.(string)
+			if !ok {
+				return nil, nil, invalidBrc20(id, "invalid BRC-20 decimals: %v", s)
+			}
+			if decimals, err = strconv.ParseUint(d, 10, 64); err != nil || decimals > BRC20_MAX_DECIMALS {
+				return nil, nil, invalidBrc20(id, "invalid BRC-20 decimals: %s", d)
+			}
+		}
+		max, err := parseBrc20Amount(fields["max"], decimals)
+		if err != nil {
+			return nil, nil, invalidBrc20(id, "invalid BRC-20 max supply of %s: %v", id, err)
+		}
+		limit := max
+		if _, ok := fields["lim"]; ok {
+			if limit, err = parseBrc20Amount(fields["lim"], decimals); err != nil {
+				return nil, nil, invalidBrc20(id, "invalid BRC-20 mint limit of %s: %v", id, err)
+			}
+		}
+
+		args := types.CoinArgs{Brc20: &types.Brc20Args{
+			Max:      max,
+			Limit:    limit,
+			Decimals: decimals,
+		}}
+		p.pending.coins[id] = &types.CoinInfo{
+			Id:       id,
+			Protocol: "brc20",
+			Args:     args,
+		}
+		return []*types.NewCoinEvent{
+			{
+				ChainId:  "bitcoin",
+				Protocol: "brc20",
+				CoinId:   id,
+				Args:     args,
+			},
+		}, nil, nil
+
+	case "mint":
+		if ci == nil || ci.Args.Brc20 == nil {
+			return nil, nil, invalidBrc20(id, "BRC-20 tick not deployed: %s", id)
+		}
+		args := ci.Args.Brc20
+		amount, err := parseBrc20Amount(fields["amt"], args.Decimals)
+		if err != nil {
+			return nil, nil, invalidBrc20(id, "invalid BRC-20 mint amount of %s: %v", id, err)
+		}
+		if amount.Cmp(args.Limit) > 0 {
+			return nil, nil, invalidBrc20(id, "mint BRC-20 %s exceed mint limit, amount = %s, limit = %s", id, amount, args.Limit)
+		}
+		remaining, err := args.Max.Sub(ci.TotalSupply)
+		if err != nil {
+			return nil, nil, invalidBrc20(id, "mint BRC-20 %s: %v", id, err)
+		}
+		if remaining.Sign() <= 0 {
+			return nil, nil, invalidBrc20(id, "mint BRC-20 %s exceed max supply, max = %s", id, args.Max)
+		}
+		if amount.Cmp(remaining) > 0 {
+			amount = remaining
+		}
+
+		if ci.TotalSupply, err = ci.TotalSupply.Add(amount); err != nil {
+			return nil, nil, invalidBrc20(id, "mint BRC-20 %s: %v", id, err)
+		}
+		p.pending.coins[id] = ci
+		if err := p.addAvailable(id, owner, amount); err != nil {
+			return nil, nil, err
+		}
+		return nil, []*types.BalanceChangeEvent{
+			{
+				ChainId:  "bitcoin",
+				Protocol: "brc20",
+				CoinId:   id,
+				Address:  owner,
+				Delta:    amount,
+				IsMint:   true,
+			},
+		}, nil
+
+	case "transfer":
+		if ci == nil || ci.Args.Brc20 == nil {
+			return nil, nil, invalidBrc20(id, "BRC-20 tick not deployed: %s", id)
+		}
+		amount, err := parseBrc20Amount(fields["amt"], ci.Args.Brc20.Decimals)
+		if err != nil {
+			return nil, nil, invalidBrc20(id, "invalid BRC-20 transfer amount of %s: %v", id, err)
+		}
+		available, err := p.getAvailable(id, owner)
+		if err != nil {
+			return nil, nil, err
+		}
+		if available.Cmp(amount) < 0 {
+			return nil, nil, invalidBrc20(id, "insufficient BRC-20 %s available balance for transfer, available = %s, amount = %s", id, available, amount)
+		}
+
+		utxo := tx.GetTxid() + ":0"
+		if err := p.addAvailable(id, owner, amount.Neg()); err != nil {
+			return nil, nil, err
+		}
+		p.pending.transfers[utxo] = &types.UnspentCoin{
+			CoinId:   id,
+			Protocol: "brc20",
+			Owner:    owner,
+			Amount:   amount,
+			Utxo:     utxo,
+		}
+		return nil, []*types.BalanceChangeEvent{
+			{
+				ChainId:  "bitcoin",
+				Protocol: "brc20",
+				CoinId:   id,
+				Address:  owner,
+				Delta:    amount.Neg(),
+			},
+			{
+				ChainId:  "bitcoin",
+				Protocol: "brc20",
+				CoinId:   id,
+				Address:  owner,
+				Delta:    amount,
+				Utxo:     utxo,
+			},
+		}, nil
+
+	default:
+		return nil, nil, invalidBrc20(id, "invalid BRC-20 operation: %v", fields["op"])
+	}
+}
+
+// invalidBrc20 rejects the operation, the coin ID is "" if unknown.
+func invalidBrc20(id string, format string, args ...interface{}) error {
+	return &InvalidOperationError{
+		Protocol: "brc20",
+		CoinId:   id,
+		Reason:   fmt.Sprintf(format, args...),
+	}
+}
+
+// getCoinInfo returns a copy of the BRC-20 coin info, including the changes of the pending block.
+func (p *Brc20Protocol) getCoinInfo(id string) (*types.CoinInfo, error) {
+	if ci, ok := p.pending.coins[id]; ok {
+		updated := *ci
+		return &updated, nil
+	}
+
+	ci, err := p.db.GetCoinInfoById(id)
+	if err != nil || ci == nil {
+		return nil, err
+	}
+	if ci.Protocol != "brc20" {
+		return nil, invalidBrc20(id, "coin ID %s taken by protocol %s", id, ci.Protocol)
+	}
+	updated := *ci
+	return &updated, nil
+}
+
+// getAvailable returns the balance of the address which is not held by transfer inscriptions.
+func (p *Brc20Protocol) getAvailable(id string, address string) (types.Amount, error) {
+	balances, err := p.db.GetBalancesByAddress(address)
+	if err != nil {
+		return types.Amount{}, err
+	}
+	coins, err := p.db.GetCoinsByAddress(address)
+	if err != nil {
+		return types.Amount{}, err
+	}
+
+	available, err := balances[id].Add(p.pending.available[id][address])
+	if err != nil {
+		return types.Amount{}, invalidBrc20(id, "available BRC-20 %s of %s: %v", id, address, err)
+	}
+	for _, coin := range coins {
+		if coin.Protocol == "brc20" && coin.CoinId == id {
+			if available, err = available.Sub(coin.Amount); err != nil {
+				return types.Amount{}, invalidBrc20(id, "available BRC-20 %s of %s: %v", id, address, err)
+			}
+		}
+	}
+	return available, nil
+}
+
+func (p *Brc20Protocol) addAvailable(id string, address string, delta types.Amount) error {
+	if _, ok := p.pending.available[id]; !ok {
+		p.pending.available[id] = make(map[string]types.Amount)
+	}
+	available, err := p.pending.available[id][address].Add(delta)
+	if err != nil {
+		return invalidBrc20(id, "available BRC-20 %s of %s: %v", id, address, err)
+	}
+	p.pending.available[id][address] = available
+	return nil
+}
+
+// parseBrc20Amount converts a decimal string into an integer amount of the smallest unit.
+func parseBrc20Amount(value interface{}, decimals uint64) (types.Amount, error) {
+	s, ok := value.(string)
+	if !ok {
+		return types.Amount{}, fmt.Errorf("amount should be a string: %v", value)
+	}
+
+	integer, fraction, _ := strings.Cut(s, ".")
+	if len(integer) == 0 || uint64(len(fraction)) > decimals || strings.Contains(s, ".") && len(fraction) == 0 {
+		return types.Amount{}, fmt.Errorf("invalid amount: %s", s)
+	}
+	for _, c := range integer + fraction {
+		if c < '0' || c > '9' {
+			return types.Amount{}, fmt.Errorf("invalid amount: %s", s)
+		}
+	}
+
+	amount, err := types.ParseAmount(integer + fraction + strings.Repeat("0", int(decimals)-len(fraction)))
+	if err != nil || amount.IsZero() {
+		return types.Amount{}, fmt.Errorf("amount out of range: %s", s)
+	}
+	return amount, nil
+}
+
+// decodeInscription returns the content type and body of the first inscription envelope in the tapscript of the witness.
+func decodeInscription(witness []string) (string, []byte, bool) {
+	items := make([][]byte, 0, len(witness))
+	for _, item := range witness {
+		bs, err := hex.DecodeString(item)
+		if err != nil {
+			return "", nil, false
+		}
+		items = append(items, bs)
+	}
+	if len(items) >= 2 && len(items[len(items)-1]) > 0 && items[len(items)-1][0] == txscript.TaprootAnnexTag {
+		items = items[:len(items)-1]
+	}
+	if len(items) < 2 {
+		return "", nil, false
+	}
+
+	// Find OP_FALSE OP_IF "ord", and collect the pushes until OP_ENDIF.
+	var pushes [][]byte
+	matched, closed := 0, false
+	tokenizer := txscript.MakeScriptTokenizer(0, items[len(items)-2])
+	for tokenizer.Next() {
+		op, data := tokenizer.Opcode(), tokenizer.Data()
+		if matched < 3 {
+			switch {
+			case matched == 0 && op == txscript.OP_FALSE,
+				matched == 1 && op == txscript.OP_IF,
+				matched == 2 && bytes.Equal(data, INSCRIPTION_PROTOCOL_ID):
+				matched++
+			case op == txscript.OP_FALSE:
+				matched = 1
+			default:
+				matched = 0
+			}
+			continue
+		}
+
+		if op == txscript.OP_ENDIF {
+			closed = true
+			break
+		}
+		switch {
+		case op == txscript.OP_0:
+			pushes = append(pushes, []byte{})
+		case op >= txscript.OP_1 && op <= txscript.OP_16:
+			pushes = append(pushes, []byte{op - txscript.OP_1 + 1})
+		case op <= txscript.OP_PUSHDATA4:
+			pushes = append(pushes, data)
+		default:
+			return "", nil, false
+		}
+	}
+	if !closed || tokenizer.Err() != nil {
+		return "", nil, false
+	}
+
+	contentType := ""
+	for i := 0; i+1 < len(pushes); i += 2 {
+		if len(pushes[i]) == 0 { // Body.
+			return contentType, bytes.Join(pushes[i+1:], nil), true
+		}
+		if bytes.Equal(pushes[i], INSCRIPTION_TAG_CONTENT_TYPE) && len(contentType) == 0 {
+			contentType = string(pushes[i+1])
+		}
+	}
+	return contentType, nil, true
+}
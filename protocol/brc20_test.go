@@ -0,0 +1,368 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/decentralize-everything/indexer/extract/mempool"
+	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/types"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+)
+
+func setupBrc20(t *testing.T) (*store.MockDatabase, *Brc20Protocol) {
+	logger, _ := zap.NewDevelopment()
+	ctrl := gomock.NewController(t)
+	mockDb := store.NewMockDatabase(ctrl)
+	return mockDb, NewBrc20Protocol(mockDb, logger)
+}
+
+func inscriptionWitness(contentType string, body string) []string {
+	script, _ := txscript.NewScriptBuilder().
+		AddData(make([]byte, 32)).
+		AddOp(txscript.OP_CHECKSIG).
+		AddOp(txscript.OP_FALSE).
+		AddOp(txscript.OP_IF).
+		AddData([]byte("ord")).
+		AddData([]byte{1}).
+		AddData([]byte(contentType)).
+		AddOp(txscript.OP_0).
+		AddData([]byte(body)).
+		AddOp(txscript.OP_ENDIF).
+		Script()
+	return []string{hex.EncodeToString(make([]byte, 64)), hex.EncodeToString(script), hex.EncodeToString(make([]byte, 33))}
+}
+
+func inscriptionTx(txid string, body string, vins ...mempool.Vin) *mempool.Transaction {
+	if len(vins) == 0 {
+		vins = []mempool.Vin{
+			{
+				Txid: "5678",
+				Vout: 0,
+			},
+		}
+	}
+	vins[0].Witness = inscriptionWitness("text/plain;charset=utf-8", body)
+	return &mempool.Transaction{
+		Txid: txid,
+		Vin:  vins,
+		Vout: []mempool.Vout{
+			{
+				Address: "1234",
+				Value:   546,
+			},
+		},
+	}
+}
+
+func ordiInfo(totalSupply int) *types.CoinInfo {
+	return &types.CoinInfo{
+		Id:          "ordi",
+		Protocol:    "brc20",
+		TotalSupply: types.NewAmount(int64(totalSupply)),
+		Args: types.CoinArgs{Brc20: &types.Brc20Args{
+			Max:      types.NewAmount(1000),
+			Limit:    types.NewAmount(100),
+			Decimals: 1,
+		}},
+	}
+}
+
+func brc20Event(address string, delta int, utxo string, isMint bool) *types.BalanceChangeEvent {
+	return &types.BalanceChangeEvent{
+		ChainId:  "bitcoin",
+		Protocol: "brc20",
+		CoinId:   "ordi",
+		Address:  address,
+		Delta:    types.NewAmount(int64(delta)),
+		Utxo:     utxo,
+		IsMint:   isMint,
+	}
+}
+
+func TestDecodeInscription(t *testing.T) {
+	contentType, body, ok := decodeInscription(inscriptionWitness("text/plain", "hello"))
+	if !ok || contentType != "text/plain" || string(body) != "hello" {
+		t.Fatalf("unexpected inscription: %s, %s, %v", contentType, body, ok)
+	}
+
+	// With annex.
+	witness := append(inscriptionWitness("text/plain", "hello"), "50")
+	if _, body, ok := decodeInscription(witness); !ok || string(body) != "hello" {
+		t.Fatalf("unexpected inscription with annex: %s, %v", body, ok)
+	}
+
+	// Not closed by OP_ENDIF.
+	script, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_FALSE).AddOp(txscript.OP_IF).AddData([]byte("ord")).AddOp(txscript.OP_0).AddData([]byte("hello")).Script()
+	if _, _, ok := decodeInscription([]string{"00", hex.EncodeToString(script), "00"}); ok {
+		t.Fatal("unclosed envelope should be ignored")
+	}
+
+	// Key path spend.
+	if _, _, ok := decodeInscription([]string{hex.EncodeToString(make([]byte, 64))}); ok {
+		t.Fatal("key path spend has no inscription")
+	}
+}
+
+func TestParseBrc20Amount(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		decimals uint64
+		expected string
+		ok       bool
+	}{
+		{"21000000", 0, "21000000", true},
+		{"1.5", 2, "150", true},
+		{"0.01", 2, "1", true},
+		{"21000000", 18, "21000000000000000000000000", true},
+		{"1.555", 2, "0", false},
+		{"1.", 2, "0", false},
+		{".5", 2, "0", false},
+		{"-1", 2, "0", false},
+		{"0", 2, "0", false},
+		{"1000000000000000000000", 18, "0", false}, // Overflow.
+		{1000, 0, "0", false},
+	}
+
+	for _, tt := range tests {
+		amount, err := parseBrc20Amount(tt.value, tt.decimals)
+		if (err == nil) != tt.ok || amount.String() != tt.expected {
+			t.Fatalf("unexpected result of %v: %s, %v", tt.value, amount, err)
+		}
+	}
+}
+
+func TestBrc20Deploy(t *testing.T) {
+	mockDb, brc20 := setupBrc20(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return(nil, nil)
+	mockDb.EXPECT().GetCoinInfoById("ordi").Return(nil, nil)
+
+	newCoinEvents, balanceChangeEvents, err := brc20.Parse(inscriptionTx("9abc", `{"p":"brc-20","op":"deploy","tick":"ORDI","max":"100","lim":"10","dec":"1"}`))
+
+	expected := []*types.NewCoinEvent{
+		{
+			ChainId:  "bitcoin",
+			Protocol: "brc20",
+			CoinId:   "ordi",
+			Args: types.CoinArgs{Brc20: &types.Brc20Args{
+				Max:      types.NewAmount(1000),
+				Limit:    types.NewAmount(100),
+				Decimals: 1,
+			}},
+		},
+	}
+	if err != nil || len(balanceChangeEvents) != 0 || !reflect.DeepEqual(newCoinEvents, expected) {
+		t.Fatalf("unexpected result: %v, %v, %v", newCoinEvents, balanceChangeEvents, err)
+	}
+
+	// Deployed in the same block.
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return(nil, nil)
+	_, _, err = brc20.Parse(inscriptionTx("def0", `{"p":"brc-20","op":"deploy","tick":"ordi","max":"100"}`))
+	if err == nil || err.Error() != "BRC-20 tick already deployed: ordi" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBrc20InvalidTick(t *testing.T) {
+	for _, tick := range []string{"ord", "ordis", "or/d", "π"} {
+		mockDb, brc20 := setupBrc20(t)
+		mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return(nil, nil)
+
+		_, _, err := brc20.Parse(inscriptionTx("9abc", `{"p":"brc-20","op":"deploy","tick":"`+tick+`","max":"100"}`))
+		if err == nil || err.Error() != "invalid BRC-20 tick: "+tick {
+			t.Fatalf("unexpected error of %s: %v", tick, err)
+		}
+	}
+
+	// 4 bytes of UTF-8.
+	mockDb, brc20 := setupBrc20(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return(nil, nil)
+	mockDb.EXPECT().GetCoinInfoById("ππ").Return(nil, nil)
+	if _, _, err := brc20.Parse(inscriptionTx("9abc", `{"p":"brc-20","op":"deploy","tick":"ππ","max":"100"}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// The pending changes of a block orphaned by a reorg are dropped when the replacement block starts.
+func TestBrc20StartBlock(t *testing.T) {
+	mockDb, brc20 := setupBrc20(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return(nil, nil).Times(2)
+	mockDb.EXPECT().GetCoinInfoById("ordi").Return(nil, nil).Times(2)
+
+	brc20.StartBlock(1)
+	if _, _, err := brc20.Parse(inscriptionTx("9abc", `{"p":"brc-20","op":"deploy","tick":"ordi","max":"100"}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	brc20.StartBlock(2)
+	newCoinEvents, _, err := brc20.Parse(inscriptionTx("def0", `{"p":"brc-20","op":"deploy","tick":"ordi","max":"100"}`))
+	if err != nil || len(newCoinEvents) != 1 {
+		t.Fatalf("unexpected result: %v, %v", newCoinEvents, err)
+	}
+}
+
+func TestBrc20Mint(t *testing.T) {
+	tests := []struct {
+		name        string
+		totalSupply int
+		amount      string
+		delta       int
+		err         string
+	}{
+		{"below limit", 0, "5", 50, ""},
+		{"clamped to remaining supply", 990, "5", 10, ""},
+		{"exceed limit", 0, "10.1", 0, "mint BRC-20 ordi exceed mint limit, amount = 101, limit = 100"},
+		{"exceed max supply", 1000, "1", 0, "mint BRC-20 ordi exceed max supply, max = 1000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDb, brc20 := setupBrc20(t)
+			mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return(nil, nil)
+			mockDb.EXPECT().GetCoinInfoById("ordi").Return(ordiInfo(tt.totalSupply), nil)
+
+			_, balanceChangeEvents, err := brc20.Parse(inscriptionTx("9abc", `{"p":"brc-20","op":"mint","tick":"ordi","amt":"`+tt.amount+`"}`))
+
+			if len(tt.err) > 0 {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("unexpected error: %v, expected: %s", err, tt.err)
+				}
+				return
+			}
+			expected := []*types.BalanceChangeEvent{brc20Event("1234", tt.delta, "", true)}
+			if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+				t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+			}
+		})
+	}
+}
+
+func TestBrc20TransferInscribeAndSend(t *testing.T) {
+	mockDb, brc20 := setupBrc20(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return(nil, nil)
+	mockDb.EXPECT().GetCoinInfoById("ordi").Return(ordiInfo(100), nil)
+	mockDb.EXPECT().GetBalancesByAddress("1234").Return(map[string]types.Amount{"ordi": types.NewAmount(100)}, nil)
+	mockDb.EXPECT().GetCoinsByAddress("1234").Return([]*types.UnspentCoin{
+		{
+			CoinId:   "ordi",
+			Protocol: "brc20",
+			Owner:    "1234",
+			Amount:   types.NewAmount(30),
+			Utxo:     "1111:0",
+		},
+	}, nil)
+
+	_, balanceChangeEvents, err := brc20.Parse(inscriptionTx("9abc", `{"p":"brc-20","op":"transfer","tick":"ordi","amt":"7"}`))
+
+	expected := []*types.BalanceChangeEvent{
+		brc20Event("1234", -70, "", false),
+		brc20Event("1234", 70, "9abc:0", false),
+	}
+	if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+	}
+
+	// The transfer inscription is sent in the same block.
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"9abc:0"}).Return(nil, nil)
+
+	_, balanceChangeEvents, err = brc20.Parse(&mempool.Transaction{
+		Txid: "def0",
+		Vin: []mempool.Vin{
+			{
+				Txid: "9abc",
+				Vout: 0,
+			},
+		},
+		Vout: []mempool.Vout{
+			{
+				Address: "abcd",
+				Value:   546,
+			},
+		},
+	})
+
+	expected = []*types.BalanceChangeEvent{
+		brc20Event("1234", -70, "9abc:0", false),
+		brc20Event("abcd", 70, "", false),
+	}
+	if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+	}
+}
+
+func TestBrc20TransferInsufficientBalance(t *testing.T) {
+	mockDb, brc20 := setupBrc20(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return(nil, nil)
+	mockDb.EXPECT().GetCoinInfoById("ordi").Return(ordiInfo(100), nil)
+	mockDb.EXPECT().GetBalancesByAddress("1234").Return(map[string]types.Amount{"ordi": types.NewAmount(100)}, nil)
+	mockDb.EXPECT().GetCoinsByAddress("1234").Return([]*types.UnspentCoin{
+		{
+			CoinId:   "ordi",
+			Protocol: "brc20",
+			Owner:    "1234",
+			Amount:   types.NewAmount(30),
+			Utxo:     "1111:0",
+		},
+	}, nil)
+
+	_, _, err := brc20.Parse(inscriptionTx("9abc", `{"p":"brc-20","op":"transfer","tick":"ordi","amt":"7.1"}`))
+
+	if err == nil || err.Error() != "insufficient BRC-20 ordi available balance for transfer, available = 70, amount = 71" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBrc20TransferReturnedToSender(t *testing.T) {
+	mockDb, brc20 := setupBrc20(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0", "1111:0"}).Return([]*types.UnspentCoin{
+		{
+			CoinId:   "ordi",
+			Protocol: "brc20",
+			Owner:    "1234",
+			Amount:   types.NewAmount(30),
+			Utxo:     "1111:0",
+		},
+	}, nil)
+
+	_, balanceChangeEvents, err := brc20.Parse(&mempool.Transaction{
+		Txid: "def0",
+		Vin: []mempool.Vin{
+			{
+				Txid: "5678",
+				Vout: 0,
+			},
+			{
+				Txid: "1111",
+				Vout: 0,
+			},
+		},
+		Vout: []mempool.Vout{
+			{
+				Address: "abcd",
+				Value:   546,
+			},
+		},
+	})
+
+	expected := []*types.BalanceChangeEvent{
+		brc20Event("1234", -30, "1111:0", false),
+		brc20Event("1234", 30, "", false),
+	}
+	if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+	}
+}
+
+func TestBrc20IgnoresOtherInscriptions(t *testing.T) {
+	mockDb, brc20 := setupBrc20(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return(nil, nil).Times(2)
+
+	for _, body := range []string{"hello", `{"p":"sns","op":"reg","name":"satoshi.sats"}`} {
+		newCoinEvents, balanceChangeEvents, err := brc20.Parse(inscriptionTx("9abc", body))
+		if err != nil || newCoinEvents != nil || balanceChangeEvents != nil {
+			t.Fatalf("unexpected result: %v, %v, %v", newCoinEvents, balanceChangeEvents, err)
+		}
+	}
+}
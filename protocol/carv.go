@@ -46,17 +46,23 @@ func (p *CarvProtocol) Parse(tx extract.Transaction) ([]*types.NewCoinEvent, []*
 	for _, vin := range tx.GetVin() {
 		utxos = append(utxos, vin.GetTxid()+":"+strconv.Itoa(vin.GetVout()))
 	}
-	coins, err := p.db.GetCoinsInUtxos(utxos)
+	inputs, err := p.db.GetCoinsInUtxos(utxos)
 	if err != nil {
 		return nil, nil, err
 	}
-	for _, coin := range coins {
+	var coins []*types.UnspentCoin
+	for _, coin := range inputs {
+		// Coins of other protocols are handled by their own parsers, coins indexed before protocols were recorded are all Carv.
+		if len(coin.Protocol) > 0 && coin.Protocol != "carv" {
+			continue
+		}
+		coins = append(coins, coin)
 		balanceChangeEvents = append(balanceChangeEvents, &types.BalanceChangeEvent{
 			ChainId:  "bitcoin",
 			Protocol: "carv",
 			CoinId:   coin.CoinId,
 			Address:  coin.Owner,
-			Delta:    -coin.Amount,
+			Delta:    coin.Amount.Neg(),
 			Utxo:     coin.Utxo,
 		})
 	}
@@ -70,31 +76,31 @@ func (p *CarvProtocol) Parse(tx extract.Transaction) ([]*types.NewCoinEvent, []*
 		}
 
 		if metaFound {
-			return nil, nil, fmt.Errorf("multiple Carv protocol metadata found in tx: %v", tx)
+			return nil, nil, invalidCarv("", "multiple Carv protocol metadata found in tx: %v", tx)
 		}
 		metaFound = true
 
 		if len(vout.GetAsm()) < len(CARV_PREFIX+"OP_PUSHBYTES_") {
-			return nil, nil, fmt.Errorf("metadata is too short: %s", vout.GetAsm())
+			return nil, nil, invalidCarv("", "metadata is too short: %s", vout.GetAsm())
 		}
 
 		segments := strings.Split(vout.GetAsm()[len(CARV_PREFIX+"OP_PUSHBYTES_"):], " ")
 		if len(segments) != 2 {
-			return nil, nil, fmt.Errorf("invalid metadata format: %s", vout.GetAsm())
+			return nil, nil, invalidCarv("", "invalid metadata format: %s", vout.GetAsm())
 		}
 
 		length, err := strconv.Atoi(segments[0])
 		if err != nil {
-			return nil, nil, fmt.Errorf("error parsing metadata length: %s", vout.GetAsm())
+			return nil, nil, invalidCarv("", "error parsing metadata length: %s", vout.GetAsm())
 		}
 
 		if len(segments[1]) != length*2 {
-			return nil, nil, fmt.Errorf("metadata length mismatch: %s", vout.GetAsm())
+			return nil, nil, invalidCarv("", "metadata length mismatch: %s", vout.GetAsm())
 		}
 
 		meta, err := hex.DecodeString(segments[1])
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to decode metadata into bytes: %s", vout.GetAsm())
+			return nil, nil, invalidCarv("", "failed to decode metadata into bytes: %s", vout.GetAsm())
 		}
 		args := utils.VarintDecodeArray(meta)
 
@@ -102,60 +108,75 @@ func (p *CarvProtocol) Parse(tx extract.Transaction) ([]*types.NewCoinEvent, []*
 			id, max, sats, limit := utils.Base26Decode(args[0]), args[1], args[2], args[3]
 			// Easy checks go first.
 			if len(id) < COIN_ID_LEN_MIN || len(id) > COIN_ID_LEN_MAX || max < COIN_SUPPLY_MIN || sats < COIN_SATS_MIN || limit < COIN_MINT_LIMIT_MIN {
-				return nil, nil, fmt.Errorf("invalid arguments for deployment, id = %s, max = %d, sats = %d, limit = %d", id, max, sats, limit)
+				return nil, nil, invalidCarv(id, "invalid arguments for deployment, id = %s, max = %d, sats = %d, limit = %d", id, max, sats, limit)
 			}
 
 			lockedBtc := max * sats
 			if lockedBtc/max != sats || lockedBtc > COIN_LOCKED_BTC_MAX { // Handle overflow.
-				return nil, nil, fmt.Errorf("locked BTC out of range, max = %d, sats = %d, lockedBtc = %d", max, sats, lockedBtc)
+				return nil, nil, invalidCarv(id, "locked BTC out of range, max = %d, sats = %d, lockedBtc = %d", max, sats, lockedBtc)
 			}
 
 			if i != 0 {
-				return nil, nil, fmt.Errorf("metadata for deployment placed at the %d-th UTXO, should be the first", i+1)
+				return nil, nil, invalidCarv(id, "metadata for deployment placed at the %d-th UTXO, should be the first", i+1)
 			}
 
 			// Check if the coin ID is already taken.
-			if ci, _ := p.db.GetCoinInfoById(id); ci != nil {
-				return nil, nil, fmt.Errorf("coin ID already taken: %s", id)
+			ci, err := p.db.GetCoinInfoById(id)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ci != nil {
+				return nil, nil, invalidCarv(id, "coin ID already taken: %s", id)
 			}
 
 			newCoinEvents = append(newCoinEvents, &types.NewCoinEvent{
 				ChainId:  "bitcoin",
 				Protocol: "carv",
 				CoinId:   id,
-				Args: map[string]interface{}{
-					"max":   max,
-					"sats":  sats,
-					"limit": limit,
-				},
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max:   max,
+					Sats:  sats,
+					Limit: limit,
+				}},
 			})
 		} else if len(args) == 1 { // Mint or transfer.
 			id := utils.Base26Decode(args[0])
 			ci, err := p.db.GetCoinInfoById(id)
 			if err != nil || ci == nil {
-				return nil, nil, fmt.Errorf("coin ID not found: %s", id)
+				return nil, nil, invalidCarv(id, "coin ID not found: %s", id)
+			}
+			params := ci.Args.Carv
+			if params == nil {
+				return nil, nil, invalidCarv(id, "coin %s is not a Carv coin", id)
 			}
 
-			totalInput := 0
+			totalInput := types.Amount{}
 			for _, coin := range coins {
 				if coin.CoinId == id {
-					totalInput += coin.Amount
+					if totalInput, err = totalInput.Add(coin.Amount); err != nil {
+						return nil, nil, invalidCarv(id, "invalid inputs for transfer: %v", err)
+					}
 				}
 			}
 
-			if totalInput == 0 { // Mint.
+			if totalInput.IsZero() { // Mint.
 				// There must be exactly one valid UTXO following the metadata of the Carv protocol.
 				if i != 1 || len(tx.GetVout()[0].GetAddress()) == 0 {
-					return nil, nil, fmt.Errorf("invalid UTXO following mint metadata: %v", tx)
+					return nil, nil, invalidCarv(id, "invalid UTXO following mint metadata: %v", tx)
 				}
 
-				if uint64(tx.GetVout()[0].GetValue())%ci.Args["sats"].(uint64) != 0 {
-					return nil, nil, fmt.Errorf("the valid output of Carv Coin %s should be an integer multiple of %d, tx = %v", id, ci.Args["sats"].(uint64), tx)
+				if uint64(tx.GetVout()[0].GetValue())%params.Sats != 0 {
+					return nil, nil, invalidCarv(id, "the valid output of Carv Coin %s should be an integer multiple of %d, tx = %v", id, params.Sats, tx)
 				}
 
-				delta := uint64(tx.GetVout()[0].GetValue()) / ci.Args["sats"].(uint64)
-				if uint64(ci.TotalSupply)+delta > ci.Args["max"].(uint64) {
-					return nil, nil, fmt.Errorf("mint Carv Coin %s exceed max supply, totalSupply = %d, delta = %d, max = %d", id, ci.TotalSupply, delta, ci.Args["max"].(uint64))
+				// A mint claiming more than the per-mint limit is rejected as a whole, rather than clamped.
+				delta := uint64(tx.GetVout()[0].GetValue()) / params.Sats
+				if delta > params.Limit {
+					return nil, nil, invalidCarv(id, "mint Carv Coin %s exceed mint limit, delta = %d, limit = %d", id, delta, params.Limit)
+				}
+
+				if supply, err := ci.TotalSupply.Add(types.AmountFromUint64(delta)); err != nil || supply.Cmp(types.AmountFromUint64(params.Max)) > 0 {
+					return nil, nil, invalidCarv(id, "mint Carv Coin %s exceed max supply, totalSupply = %s, delta = %d, max = %d", id, ci.TotalSupply, delta, params.Max)
 				}
 
 				balanceChangeEvents = append(balanceChangeEvents, &types.BalanceChangeEvent{
@@ -163,7 +184,7 @@ func (p *CarvProtocol) Parse(tx extract.Transaction) ([]*types.NewCoinEvent, []*
 					Protocol: "carv",
 					CoinId:   id,
 					Address:  tx.GetVout()[0].GetAddress(),
-					Delta:    int(delta),
+					Delta:    types.AmountFromUint64(delta),
 					Utxo:     tx.GetTxid() + ":0",
 					IsMint:   true,
 				})
@@ -171,27 +192,36 @@ func (p *CarvProtocol) Parse(tx extract.Transaction) ([]*types.NewCoinEvent, []*
 				totalOutput := uint64(0)
 				for j := 0; j < i; j++ {
 					vout := tx.GetVout()[j]
-					if vout.GetValue() == 0 || uint64(vout.GetValue())%ci.Args["sats"].(uint64) != 0 || len(vout.GetAddress()) == 0 {
-						return nil, nil, fmt.Errorf("the valid output of Carv Coin %s should be an integer multiple of %d, tx = %v", id, ci.Args["sats"].(uint64), tx)
+					if vout.GetValue() == 0 || uint64(vout.GetValue())%params.Sats != 0 || len(vout.GetAddress()) == 0 {
+						return nil, nil, invalidCarv(id, "the valid output of Carv Coin %s should be an integer multiple of %d, tx = %v", id, params.Sats, tx)
 					}
-					totalOutput += uint64(vout.GetValue()) / ci.Args["sats"].(uint64)
+					totalOutput += uint64(vout.GetValue()) / params.Sats
 					balanceChangeEvents = append(balanceChangeEvents, &types.BalanceChangeEvent{
 						ChainId:  "bitcoin",
 						Protocol: "carv",
 						CoinId:   id,
 						Address:  vout.GetAddress(),
-						Delta:    int(uint64(vout.GetValue()) / ci.Args["sats"].(uint64)),
+						Delta:    types.AmountFromUint64(uint64(vout.GetValue()) / params.Sats),
 						Utxo:     tx.GetTxid() + ":" + strconv.Itoa(j),
 					})
 				}
 
-				if totalInput < int(totalOutput) {
-					return nil, nil, fmt.Errorf("insufficient inputs for transfer, input = %d, output = %d", totalInput, totalOutput)
+				if totalInput.Cmp(types.AmountFromUint64(totalOutput)) < 0 {
+					return nil, nil, invalidCarv(id, "insufficient inputs for transfer, input = %s, output = %d", totalInput, totalOutput)
 				}
 			}
 		} else {
-			return nil, nil, fmt.Errorf("invalid Carv protocol metadata: %s", vout.GetAsm())
+			return nil, nil, invalidCarv("", "invalid Carv protocol metadata: %s", vout.GetAsm())
 		}
 	}
 	return newCoinEvents, balanceChangeEvents, nil
 }
+
+// invalidCarv rejects the transaction, the coin ID is "" if unknown.
+func invalidCarv(id string, format string, args ...interface{}) error {
+	return &InvalidOperationError{
+		Protocol: "carv",
+		CoinId:   id,
+		Reason:   fmt.Sprintf(format, args...),
+	}
+}
@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
@@ -281,6 +282,10 @@ func TestDeployCoinAlreadyExists(t *testing.T) {
 	if err == nil || err.Error() != "coin ID already taken: CARV" {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	var invalid *InvalidOperationError
+	if !errors.As(err, &invalid) || invalid.Protocol != "carv" || invalid.CoinId != "CARV" {
+		t.Fatalf("unexpected error type: %#v", err)
+	}
 }
 
 func TestDeploySuccess(t *testing.T) {
@@ -306,11 +311,11 @@ func TestDeploySuccess(t *testing.T) {
 		ChainId:  "bitcoin",
 		Protocol: "carv",
 		CoinId:   "CARV",
-		Args: map[string]interface{}{
-			"max":   uint64(21000000),
-			"sats":  uint64(10000),
-			"limit": uint64(1),
-		},
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   21000000,
+			Sats:  10000,
+			Limit: 1,
+		}},
 	}
 	if !reflect.DeepEqual(newCoinEvents[0], expected) {
 		t.Fatalf("unexpected new coin event: %v, expected: %v", newCoinEvents[0], expected)
@@ -322,11 +327,12 @@ func TestMintUtxoValueMismatchWithSats(t *testing.T) {
 	mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
 		Id:          "CARV",
-		TotalSupply: 1,
-		Args: map[string]interface{}{
-			"max":  uint64(21000000),
-			"sats": uint64(10000),
-		},
+		TotalSupply: types.NewAmount(1),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   21000000,
+			Sats:  10000,
+			Limit: 1000,
+		}},
 	}, nil)
 
 	_, _, err := carv.Parse(
@@ -353,11 +359,12 @@ func TestMintTotalSupplyExceed(t *testing.T) {
 	mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
 		Id:          "CARV",
-		TotalSupply: 21000000,
-		Args: map[string]interface{}{
-			"max":  uint64(21000000),
-			"sats": uint64(10000),
-		},
+		TotalSupply: types.NewAmount(21000000),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   21000000,
+			Sats:  10000,
+			Limit: 1000,
+		}},
 	}, nil)
 
 	_, _, err := carv.Parse(
@@ -379,16 +386,70 @@ func TestMintTotalSupplyExceed(t *testing.T) {
 	}
 }
 
+func TestMintLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		err   string
+	}{
+		{"below limit", 20000, ""},
+		{"at limit", 30000, ""},
+		{"above limit", 40000, "mint Carv Coin CARV exceed mint limit, delta = 4, limit = 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDb, carv := setup(t)
+			mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil)
+			mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
+				Id:          "CARV",
+				TotalSupply: types.NewAmount(1),
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max:   21000000,
+					Sats:  10000,
+					Limit: 3,
+				}},
+			}, nil)
+
+			_, balanceChangeEvents, err := carv.Parse(
+				&mempool.Transaction{
+					Txid: "5678",
+					Vout: []mempool.Vout{
+						{
+							Address: "1234",
+							Value:   tt.value,
+						},
+						{
+							Asm: "OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405",
+						},
+					},
+				},
+			)
+
+			if len(tt.err) > 0 {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil || len(balanceChangeEvents) != 1 || balanceChangeEvents[0].Delta != types.NewAmount(int64(tt.value/10000)) {
+				t.Fatalf("unexpected result: %v, %v", balanceChangeEvents, err)
+			}
+		})
+	}
+}
+
 func TestMintSuccess(t *testing.T) {
 	mockDb, carv := setup(t)
 	mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
 		Id:          "CARV",
-		TotalSupply: 1,
-		Args: map[string]interface{}{
-			"max":  uint64(21000000),
-			"sats": uint64(10000),
-		},
+		TotalSupply: types.NewAmount(1),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   21000000,
+			Sats:  10000,
+			Limit: 1000,
+		}},
 	}, nil)
 
 	newCoinEvents, balanceChangeEvents, err := carv.Parse(
@@ -415,7 +476,7 @@ func TestMintSuccess(t *testing.T) {
 		Protocol: "carv",
 		CoinId:   "CARV",
 		Address:  "1234",
-		Delta:    1,
+		Delta:    types.NewAmount(1),
 		Utxo:     "5678:0",
 		IsMint:   true,
 	}
@@ -428,17 +489,18 @@ func TestTransferUtxoValueNotMultiplyOfSatsError(t *testing.T) {
 	mockDb, carv := setup(t)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
 		Id:          "CARV",
-		TotalSupply: 1,
-		Args: map[string]interface{}{
-			"max":  uint64(21000000),
-			"sats": uint64(10000),
-		},
+		TotalSupply: types.NewAmount(1),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   21000000,
+			Sats:  10000,
+			Limit: 1000,
+		}},
 	}, nil)
 	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return([]*types.UnspentCoin{
 		{
 			CoinId: "CARV",
 			Owner:  "1234",
-			Amount: 1,
+			Amount: types.NewAmount(1),
 			Utxo:   "5678:0",
 		},
 	}, nil)
@@ -463,7 +525,7 @@ func TestTransferUtxoValueNotMultiplyOfSatsError(t *testing.T) {
 		},
 	)
 
-	if err == nil || err.Error() != "the valid output of Carv Coin CARV should be an integer multiple of 10000, tx = &{ [{5678 0}] [{15000 1234 } {0  OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405}]}" {
+	if err == nil || err.Error() != "the valid output of Carv Coin CARV should be an integer multiple of 10000, tx = &{ [{5678 0 []}] [{15000 1234 } {0  OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_3 82a405}]}" {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
@@ -472,17 +534,18 @@ func TestTransferInsufficientInputsError(t *testing.T) {
 	mockDb, carv := setup(t)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
 		Id:          "CARV",
-		TotalSupply: 1,
-		Args: map[string]interface{}{
-			"max":  uint64(21000000),
-			"sats": uint64(10000),
-		},
+		TotalSupply: types.NewAmount(1),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   21000000,
+			Sats:  10000,
+			Limit: 1000,
+		}},
 	}, nil)
 	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return([]*types.UnspentCoin{
 		{
 			CoinId: "CARV",
 			Owner:  "1234",
-			Amount: 1,
+			Amount: types.NewAmount(1),
 			Utxo:   "5678:0",
 		},
 	}, nil)
@@ -520,17 +583,18 @@ func TestTransferSuccess(t *testing.T) {
 	mockDb, carv := setup(t)
 	mockDb.EXPECT().GetCoinInfoById("CARV").Return(&types.CoinInfo{
 		Id:          "CARV",
-		TotalSupply: 1,
-		Args: map[string]interface{}{
-			"max":  uint64(21000000),
-			"sats": uint64(10000),
-		},
+		TotalSupply: types.NewAmount(1),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   21000000,
+			Sats:  10000,
+			Limit: 1000,
+		}},
 	}, nil)
 	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return([]*types.UnspentCoin{
 		{
 			CoinId: "CARV",
 			Owner:  "1234",
-			Amount: 1,
+			Amount: types.NewAmount(1),
 			Utxo:   "5678:0",
 		},
 	}, nil)
@@ -566,7 +630,7 @@ func TestTransferSuccess(t *testing.T) {
 			Protocol: "carv",
 			CoinId:   "CARV",
 			Address:  "1234",
-			Delta:    -1,
+			Delta:    types.NewAmount(-1),
 			Utxo:     "5678:0",
 		},
 		{
@@ -574,7 +638,7 @@ func TestTransferSuccess(t *testing.T) {
 			Protocol: "carv",
 			CoinId:   "CARV",
 			Address:  "1234",
-			Delta:    1,
+			Delta:    types.NewAmount(1),
 			Utxo:     "9abc:0",
 		},
 	}
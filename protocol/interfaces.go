@@ -8,3 +8,23 @@ import (
 type Parser interface {
 	Parse(tx extract.Transaction) ([]*types.NewCoinEvent, []*types.BalanceChangeEvent, error)
 }
+
+// BlockParser is a parser keeping the changes of the transactions parsed so far in the block, as they aren't in the
+// store until the whole block is applied. StartBlock is called with the height of the block before its first
+// transaction.
+type BlockParser interface {
+	Parser
+	StartBlock(height int)
+}
+
+// InvalidOperationError is returned by parsers for a transaction carrying protocol metadata which breaks the rules of
+// the protocol, as opposed to failures of the store.
+type InvalidOperationError struct {
+	Protocol string
+	CoinId   string // Empty if unknown, e.g. the metadata can't be decoded.
+	Reason   string
+}
+
+func (e *InvalidOperationError) Error() string {
+	return e.Reason
+}
@@ -1,35 +1,597 @@
 package protocol
 
 import (
-	"fmt"
+	"encoding/hex"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/decentralize-everything/indexer/extract"
+	"github.com/decentralize-everything/indexer/store"
 	"github.com/decentralize-everything/indexer/types"
+	"github.com/decentralize-everything/indexer/utils"
 	"go.uber.org/zap"
 )
 
+/*
+Runestone format supported by RuneProtocol:
+  - The runestone is the first output whose script is OP_RETURN, a push of "R",
+    and data pushes. The concatenated data is decoded into integers with
+    utils.VarintDecodeArray.
+  - Integers are tag/value pairs until the body tag 0. The remaining integers
+    are edicts, each one being (id, amount, output).
+  - An edict id is the rune name encoded with utils.Base26Encode, 0 refers to
+    the rune etched by the same transaction. Amount 0 means all the unallocated
+    runes. Output equal to the number of outputs splits among all the
+    non-OP_RETURN outputs.
+  - The coin ID of a rune is its name prefixed with RUNE_ID_PREFIX, so that a
+    rune and a Carv coin of the same name are different coins.
+  - Unallocated runes go to the pointer output, or to the first non-OP_RETURN
+    output if there is no pointer. Runes sent to an OP_RETURN output are burnt.
+  - An output keeps every rune allocated to it. Unallocated runes of a name
+    adding up above uint64 are burnt.
+  - An etching with the terms flag opens mints of the amount, up to the cap,
+    from the later of the start height and the etching height plus the start
+    offset, until the earlier of the end height and the etching height plus
+    the end offset. The mint field is a rune name encoded like edict ids. Mints
+    are allocated like input runes, a transaction can't mint the rune it
+    etches.
+  - A runestone that can't be decoded, or contains unrecognized even tags or
+    flags, is a cenotaph: all input and minted runes are burnt, the mint still
+    counts toward the cap, and an etching only creates the rune, without
+    premine nor mints.
+*/
 var (
-	RUNE_PREFIX = "OP_RETURN OP_PUSHBYTES_1 52 "
+	RUNE_PREFIX           = "OP_RETURN OP_PUSHBYTES_1 52 "
+	RUNE_ID_PREFIX        = "runes:"
+	RUNE_MAX_DIVISIBILITY = uint64(38)
+
+	RUNE_TAG_BODY         = uint64(0)
+	RUNE_TAG_DIVISIBILITY = uint64(1)
+	RUNE_TAG_FLAGS        = uint64(2)
+	RUNE_TAG_SPACERS      = uint64(3)
+	RUNE_TAG_RUNE         = uint64(4)
+	RUNE_TAG_SYMBOL       = uint64(5)
+	RUNE_TAG_PREMINE      = uint64(6)
+	RUNE_TAG_CAP          = uint64(8)
+	RUNE_TAG_AMOUNT       = uint64(10)
+	RUNE_TAG_HEIGHT_START = uint64(12)
+	RUNE_TAG_HEIGHT_END   = uint64(14)
+	RUNE_TAG_OFFSET_START = uint64(16)
+	RUNE_TAG_OFFSET_END   = uint64(18)
+	RUNE_TAG_MINT         = uint64(20)
+	RUNE_TAG_POINTER      = uint64(22)
+
+	RUNE_FLAG_ETCHING = uint64(1)
+	RUNE_FLAG_TERMS   = uint64(2)
+	RUNE_FLAG_TURBO   = uint64(4)
 )
 
 type RuneProtocol struct {
-	logger *zap.Logger
+	db      store.Database
+	pending *runePending
+	logger  *zap.Logger
 }
 
-var _ Parser = (*RuneProtocol)(nil)
+var _ BlockParser = (*RuneProtocol)(nil)
 
-func NewRuneProtocol(logger *zap.Logger) *RuneProtocol {
+func NewRuneProtocol(db store.Database, logger *zap.Logger) *RuneProtocol {
 	return &RuneProtocol{
+		db:     db,
 		logger: logger,
 	}
 }
 
+// runePending keeps the changes of the block being parsed, which are not in the store until the whole block is applied.
+type runePending struct {
+	height  int
+	outputs map[string][]*types.UnspentCoin // Runes of the outputs created in the block, nil marks a spent output.
+	runes   map[string]*types.CoinInfo      // Runes etched or minted in the block, with the supply minted so far.
+}
+
+// StartBlock drops the changes of the previous block, which are in the store once applied, or orphaned by a reorg.
+func (p *RuneProtocol) StartBlock(height int) {
+	p.pending = &runePending{
+		height:  height,
+		outputs: make(map[string][]*types.UnspentCoin),
+		runes:   make(map[string]*types.CoinInfo),
+	}
+}
+
+type edict struct {
+	id     uint64
+	amount uint64
+	output uint64
+}
+
+type runestone struct {
+	fields   map[uint64]uint64
+	edicts   []edict
+	cenotaph bool
+}
+
 func (p *RuneProtocol) Parse(tx extract.Transaction) ([]*types.NewCoinEvent, []*types.BalanceChangeEvent, error) {
+	if p.pending == nil {
+		p.StartBlock(0)
+	}
+
+	var newCoinEvents []*types.NewCoinEvent
+	var balanceChangeEvents []*types.BalanceChangeEvent
+
+	// All the runes in inputs are unallocated at first.
+	coins, err := p.spendInputs(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	unallocated := make(map[string]uint64)
+	overflowed := make(map[string]bool)
+	var names []string // Keep the order of first appearance, so that allocations are deterministic.
+	credit := func(name string, amount types.Amount) {
+		if _, ok := unallocated[name]; !ok {
+			names = append(names, name)
+		}
+		// Allocations are in runestone amounts, which are uint64.
+		total, err := types.AmountFromUint64(unallocated[name]).Add(amount)
+		n, ok := total.Uint64()
+		if err != nil || !ok || overflowed[name] {
+			if !overflowed[name] {
+				p.logger.Info("unallocated runes out of range, burnt", zap.String("rune", name), zap.String("tx", tx.GetTxid()))
+			}
+			overflowed[name] = true
+			n = 0
+		}
+		unallocated[name] = n
+	}
+	for _, coin := range coins {
+		credit(coin.CoinId, coin.Amount)
+		balanceChangeEvents = append(balanceChangeEvents, &types.BalanceChangeEvent{
+			ChainId:  "bitcoin",
+			Protocol: "runes",
+			CoinId:   coin.CoinId,
+			Address:  coin.Owner,
+			Delta:    coin.Amount.Neg(),
+			Utxo:     coin.Utxo,
+		})
+	}
+
+	var rs *runestone
 	for _, vout := range tx.GetVout() {
-		if strings.HasPrefix(vout.GetAsm(), RUNE_PREFIX) {
-			p.logger.Debug("found RUNE_PREFIX", zap.String("txid", tx.GetTxid()), zap.String("vout", fmt.Sprintf("%v", vout)))
+		if vout.GetValue() == 0 && len(vout.GetAddress()) == 0 && strings.HasPrefix(vout.GetAsm(), RUNE_PREFIX) {
+			rs = decodeRunestone(vout.GetAsm(), len(tx.GetVout()))
+			break
+		}
+	}
+	if rs == nil && len(names) == 0 {
+		return nil, nil, nil
+	}
+
+	// Mint, before the etching so that a transaction can't mint the rune it etches.
+	if rs != nil {
+		name, amount, err := p.mint(rs)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !amount.IsZero() {
+			credit(name, amount)
+			balanceChangeEvents = append(balanceChangeEvents, supplyEvent(name, amount))
+		}
+	}
+
+	// Etching.
+	etched := ""
+	if rs != nil && rs.fields[RUNE_TAG_FLAGS]&RUNE_FLAG_ETCHING != 0 {
+		name, args, err := p.etching(rs)
+		if err != nil {
+			return nil, nil, err
+		}
+		if args != nil {
+			newCoinEvents = append(newCoinEvents, &types.NewCoinEvent{
+				ChainId:  "bitcoin",
+				Protocol: "runes",
+				CoinId:   name,
+				Args:     types.CoinArgs{Runes: args},
+			})
+			p.pending.runes[name] = &types.CoinInfo{
+				Id:           name,
+				Protocol:     "runes",
+				TotalSupply:  args.Premine,
+				Args:         types.CoinArgs{Runes: args},
+				DeployTx:     tx.GetTxid(),
+				DeployHeight: p.pending.height,
+			}
+			if !args.Premine.IsZero() {
+				etched = name
+				credit(name, args.Premine)
+				balanceChangeEvents = append(balanceChangeEvents, supplyEvent(name, args.Premine))
+			}
+		}
+	}
+
+	// Input and minted runes are burnt by a cenotaph.
+	if rs != nil && rs.cenotaph {
+		return newCoinEvents, balanceChangeEvents, nil
+	}
+
+	vouts := tx.GetVout()
+	allocated := make([]map[string]uint64, len(vouts))
+	allocate := func(output int, name string, amount uint64) {
+		if allocated[output] == nil {
+			allocated[output] = make(map[string]uint64)
+		}
+		allocated[output][name] += amount
+		unallocated[name] -= amount
+	}
+
+	if rs != nil {
+		for _, e := range rs.edicts {
+			name := runeId(e.id)
+			if e.id == 0 {
+				if len(etched) == 0 {
+					continue
+				}
+				name = etched
+			}
+			if unallocated[name] == 0 {
+				continue
+			}
+
+			if e.output < uint64(len(vouts)) {
+				amount := e.amount
+				if amount == 0 || amount > unallocated[name] {
+					amount = unallocated[name]
+				}
+				allocate(int(e.output), name, amount)
+				continue
+			}
+
+			// Split among all the non-OP_RETURN outputs.
+			var outputs []int
+			for i, vout := range vouts {
+				if !isOpReturn(vout) {
+					outputs = append(outputs, i)
+				}
+			}
+			if len(outputs) == 0 {
+				continue
+			}
+			if e.amount == 0 {
+				amount := unallocated[name] / uint64(len(outputs))
+				remainder := unallocated[name] % uint64(len(outputs))
+				for i, output := range outputs {
+					if uint64(i) < remainder {
+						allocate(output, name, amount+1)
+					} else if amount > 0 {
+						allocate(output, name, amount)
+					}
+				}
+			} else {
+				for _, output := range outputs {
+					amount := e.amount
+					if amount > unallocated[name] {
+						amount = unallocated[name]
+					}
+					if amount == 0 {
+						break
+					}
+					allocate(output, name, amount)
+				}
+			}
+		}
+	}
+
+	// Default allocation for the rest.
+	output := -1
+	if pointer, ok := rs.pointer(); ok && pointer < uint64(len(vouts)) {
+		output = int(pointer)
+	} else {
+		for i, vout := range vouts {
+			if !isOpReturn(vout) {
+				output = i
+				break
+			}
+		}
+	}
+	if output >= 0 {
+		for _, name := range names {
+			if unallocated[name] > 0 {
+				allocate(output, name, unallocated[name])
+			}
+		}
+	}
+
+	for i, balances := range allocated {
+		if len(balances) == 0 || isOpReturn(vouts[i]) { // Burnt.
+			continue
+		}
+		utxo := tx.GetTxid() + ":" + strconv.Itoa(i)
+		for _, name := range names {
+			amount, ok := balances[name]
+			if !ok {
+				continue
+			}
+			balanceChangeEvents = append(balanceChangeEvents, &types.BalanceChangeEvent{
+				ChainId:  "bitcoin",
+				Protocol: "runes",
+				CoinId:   name,
+				Address:  vouts[i].GetAddress(),
+				Delta:    types.AmountFromUint64(amount),
+				Utxo:     utxo,
+			})
+			p.pending.outputs[utxo] = append(p.pending.outputs[utxo], &types.UnspentCoin{
+				CoinId:   name,
+				Protocol: "runes",
+				Owner:    vouts[i].GetAddress(),
+				Amount:   types.AmountFromUint64(amount),
+				Utxo:     utxo,
+			})
+		}
+	}
+	return newCoinEvents, balanceChangeEvents, nil
+}
+
+// spendInputs returns the runes in the inputs of the transaction in the order of the inputs, including the outputs
+// created earlier in the block, and marks the inputs holding runes as spent.
+func (p *RuneProtocol) spendInputs(tx extract.Transaction) ([]*types.UnspentCoin, error) {
+	utxos := make([]string, 0, len(tx.GetVin()))
+	for _, vin := range tx.GetVin() {
+		utxos = append(utxos, vin.GetTxid()+":"+strconv.Itoa(vin.GetVout()))
+	}
+	stored, err := p.db.GetCoinsInUtxos(utxos)
+	if err != nil {
+		return nil, err
+	}
+	byUtxo := make(map[string][]*types.UnspentCoin)
+	for _, coin := range stored {
+		if coin.Protocol == "runes" {
+			byUtxo[coin.Utxo] = append(byUtxo[coin.Utxo], coin)
+		}
+	}
+
+	var coins []*types.UnspentCoin
+	for _, utxo := range utxos {
+		runes, ok := p.pending.outputs[utxo]
+		if !ok {
+			runes = byUtxo[utxo]
+		}
+		if len(runes) > 0 {
+			coins = append(coins, runes...)
+			p.pending.outputs[utxo] = nil
+		}
+	}
+	return coins, nil
+}
+
+// etching returns the rune etched by the runestone, with nil args if the etching is invalid. An invalid etching is
+// ignored while the rest of the runestone still takes effect. The rune of a cenotaph has no premine and no mints.
+func (p *RuneProtocol) etching(rs *runestone) (string, *types.RunesArgs, error) {
+	id, ok := rs.fields[RUNE_TAG_RUNE]
+	if !ok {
+		p.logger.Debug("etching without rune name")
+		return "", nil, nil
+	}
+	name := runeId(id)
+
+	if rs.fields[RUNE_TAG_DIVISIBILITY] > RUNE_MAX_DIVISIBILITY {
+		p.logger.Debug("invalid etching", zap.String("rune", name), zap.Uint64("divisibility", rs.fields[RUNE_TAG_DIVISIBILITY]))
+		return "", nil, nil
+	}
+
+	if _, ok := p.pending.runes[name]; ok {
+		p.logger.Debug("rune name already taken in the block", zap.String("rune", name))
+		return "", nil, nil
+	}
+	ci, err := p.db.GetCoinInfoById(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if ci != nil {
+		p.logger.Debug("rune name already taken", zap.String("rune", name))
+		return "", nil, nil
+	}
+
+	args := &types.RunesArgs{
+		Divisibility: rs.fields[RUNE_TAG_DIVISIBILITY],
+		Spacers:      rs.fields[RUNE_TAG_SPACERS],
+		Symbol:       rs.fields[RUNE_TAG_SYMBOL],
+	}
+	if !rs.cenotaph {
+		args.Premine = types.AmountFromUint64(rs.fields[RUNE_TAG_PREMINE])
+		if rs.fields[RUNE_TAG_FLAGS]&RUNE_FLAG_TERMS != 0 {
+			args.Limit = types.AmountFromUint64(rs.fields[RUNE_TAG_AMOUNT])
+			args.Cap = rs.fields[RUNE_TAG_CAP]
+			if start, end, ok := rs.mintWindow(uint64(p.pending.height)); ok {
+				args.MintStart, args.MintEnd = start, end
+			} else {
+				args.Cap = 0
+			}
 		}
 	}
-	return nil, nil, nil
+	// Both factors and the premine are uint64, the max fits in 128 bits.
+	mints, _ := types.AmountFromUint64(args.Cap).Mul(args.Limit)
+	args.Max, _ = args.Premine.Add(mints)
+	return name, args, nil
+}
+
+// mint returns the rune minted by the runestone, with a zero amount if the rune can't be minted in the block. Mints of
+// a cenotaph count toward the cap too.
+func (p *RuneProtocol) mint(rs *runestone) (string, types.Amount, error) {
+	id, ok := rs.fields[RUNE_TAG_MINT]
+	if !ok {
+		return "", types.Amount{}, nil
+	}
+	name := runeId(id)
+
+	ci, ok := p.pending.runes[name]
+	if !ok {
+		var err error
+		if ci, err = p.db.GetCoinInfoById(name); err != nil {
+			return "", types.Amount{}, err
+		}
+		if ci == nil || ci.Args.Runes == nil {
+			p.logger.Debug("mint of unknown rune", zap.String("rune", name))
+			return "", types.Amount{}, nil
+		}
+		// Work on a copy, the store keeps the supply of the previous block.
+		updated := *ci
+		ci = &updated
+		p.pending.runes[name] = ci
+	}
+
+	args := ci.Args.Runes
+	height := uint64(p.pending.height)
+	if args.Cap == 0 || args.Limit.IsZero() || height < args.MintStart || (args.MintEnd > 0 && height >= args.MintEnd) {
+		p.logger.Debug("rune not mintable", zap.String("rune", name), zap.Int("height", p.pending.height))
+		return "", types.Amount{}, nil
+	}
+	supply, err := ci.TotalSupply.Add(args.Limit)
+	if err != nil || supply.Cmp(args.Max) > 0 {
+		p.logger.Debug("rune mint cap reached", zap.String("rune", name))
+		return "", types.Amount{}, nil
+	}
+	ci.TotalSupply = supply
+	return name, args.Limit, nil
+}
+
+// supplyEvent counts minted runes in the supply, they are allocated to outputs like the runes of the inputs.
+func supplyEvent(name string, amount types.Amount) *types.BalanceChangeEvent {
+	return &types.BalanceChangeEvent{
+		ChainId:  "bitcoin",
+		Protocol: "runes",
+		CoinId:   name,
+		Delta:    amount,
+		IsMint:   true,
+	}
+}
+
+// runeId returns the coin ID of the rune name encoded with utils.Base26Encode.
+func runeId(name uint64) string {
+	return RUNE_ID_PREFIX + utils.Base26Decode(name)
+}
+
+// mintWindow returns the block the mints of a rune etched at the height open at, and the block they end before, 0 if
+// they don't end. Absolute heights and offsets from the etching both bound the window, false if it's empty.
+func (rs *runestone) mintWindow(height uint64) (uint64, uint64, bool) {
+	start, end := uint64(0), uint64(0)
+	ended := false
+	if v, ok := rs.fields[RUNE_TAG_HEIGHT_START]; ok {
+		start = v
+	}
+	if v, ok := rs.fields[RUNE_TAG_OFFSET_START]; ok {
+		start = max(start, saturatingAdd(height, v))
+	}
+	if v, ok := rs.fields[RUNE_TAG_HEIGHT_END]; ok {
+		end, ended = v, true
+	}
+	if v, ok := rs.fields[RUNE_TAG_OFFSET_END]; ok {
+		if v = saturatingAdd(height, v); !ended || v < end {
+			end, ended = v, true
+		}
+	}
+	if ended && end <= start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func saturatingAdd(a uint64, b uint64) uint64 {
+	if b > math.MaxUint64-a {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+func (rs *runestone) pointer() (uint64, bool) {
+	if rs == nil {
+		return 0, false
+	}
+	pointer, ok := rs.fields[RUNE_TAG_POINTER]
+	return pointer, ok
+}
+
+func decodeRunestone(asm string, numOutputs int) *runestone {
+	rs := &runestone{
+		fields: make(map[uint64]uint64),
+	}
+
+	// Concatenate all the data pushes.
+	var payload []byte
+	tokens := strings.Fields(asm[len(RUNE_PREFIX):])
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] == "OP_0" {
+			continue
+		}
+		if !strings.HasPrefix(tokens[i], "OP_PUSHBYTES_") && !strings.HasPrefix(tokens[i], "OP_PUSHDATA") || i+1 == len(tokens) {
+			rs.cenotaph = true
+			return rs
+		}
+		data, err := hex.DecodeString(tokens[i+1])
+		if err != nil {
+			rs.cenotaph = true
+			return rs
+		}
+		if strings.HasPrefix(tokens[i], "OP_PUSHBYTES_") {
+			if length, err := strconv.Atoi(tokens[i][len("OP_PUSHBYTES_"):]); err != nil || length != len(data) {
+				rs.cenotaph = true
+				return rs
+			}
+		}
+		payload = append(payload, data...)
+		i++
+	}
+	if len(payload) == 0 {
+		return rs
+	}
+
+	// Every varint ends with a byte below 128, a truncated one can't be decoded.
+	if payload[len(payload)-1] >= 128 {
+		rs.cenotaph = true
+		return rs
+	}
+	integers := utils.VarintDecodeArray(payload)
+
+	for i := 0; i < len(integers); i += 2 {
+		tag := integers[i]
+		if tag == RUNE_TAG_BODY {
+			body := integers[i+1:]
+			if len(body)%3 != 0 {
+				rs.cenotaph = true
+				return rs
+			}
+			for j := 0; j < len(body); j += 3 {
+				if body[j+2] > uint64(numOutputs) {
+					rs.cenotaph = true
+					return rs
+				}
+				rs.edicts = append(rs.edicts, edict{id: body[j], amount: body[j+1], output: body[j+2]})
+			}
+			break
+		}
+
+		if i+1 == len(integers) {
+			rs.cenotaph = true
+			return rs
+		}
+		value := integers[i+1]
+		switch tag {
+		case RUNE_TAG_DIVISIBILITY, RUNE_TAG_FLAGS, RUNE_TAG_SPACERS, RUNE_TAG_RUNE, RUNE_TAG_SYMBOL, RUNE_TAG_PREMINE,
+			RUNE_TAG_CAP, RUNE_TAG_AMOUNT, RUNE_TAG_HEIGHT_START, RUNE_TAG_HEIGHT_END, RUNE_TAG_OFFSET_START,
+			RUNE_TAG_OFFSET_END, RUNE_TAG_MINT, RUNE_TAG_POINTER:
+			if _, ok := rs.fields[tag]; !ok { // Only the first value counts.
+				rs.fields[tag] = value
+			}
+		default:
+			if tag%2 == 0 {
+				rs.cenotaph = true
+			}
+		}
+	}
+
+	if rs.fields[RUNE_TAG_FLAGS]&^(RUNE_FLAG_ETCHING|RUNE_FLAG_TERMS|RUNE_FLAG_TURBO) != 0 {
+		rs.cenotaph = true
+	}
+	return rs
+}
+
+func isOpReturn(vout extract.Vout) bool {
+	return strings.HasPrefix(vout.GetAsm(), "OP_RETURN")
 }
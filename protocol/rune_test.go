@@ -0,0 +1,621 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/decentralize-everything/indexer/extract/mempool"
+	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/decentralize-everything/indexer/utils"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+)
+
+func setupRune(t *testing.T) (*store.MockDatabase, *RuneProtocol) {
+	logger, _ := zap.NewDevelopment()
+	ctrl := gomock.NewController(t)
+	mockDb := store.NewMockDatabase(ctrl)
+	return mockDb, NewRuneProtocol(mockDb, logger)
+}
+
+func runestoneAsm(integers ...uint64) string {
+	payload := utils.VarintEncodeArray(integers)
+	return fmt.Sprintf("%sOP_PUSHBYTES_%d %s", RUNE_PREFIX, len(payload), hex.EncodeToString(payload))
+}
+
+func runeInput(mockDb *store.MockDatabase, name string, amount int) {
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return([]*types.UnspentCoin{
+		{
+			CoinId:   name,
+			Protocol: "runes",
+			Owner:    "1234",
+			Amount:   types.NewAmount(int64(amount)),
+			Utxo:     "5678:0",
+		},
+	}, nil)
+}
+
+func runeEvent(name string, address string, delta int, utxo string, isMint bool) *types.BalanceChangeEvent {
+	return &types.BalanceChangeEvent{
+		ChainId:  "bitcoin",
+		Protocol: "runes",
+		CoinId:   name,
+		Address:  address,
+		Delta:    types.NewAmount(int64(delta)),
+		Utxo:     utxo,
+		IsMint:   isMint,
+	}
+}
+
+func TestRuneNoRunestone(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil)
+
+	newCoinEvents, balanceChangeEvents, err := runes.Parse(
+		&mempool.Transaction{
+			Vout: []mempool.Vout{
+				{
+					Address: "1234",
+					Value:   10000,
+				},
+			},
+		},
+	)
+
+	if err != nil || newCoinEvents != nil || balanceChangeEvents != nil {
+		t.Fatalf("unexpected result: %v, %v, %v", newCoinEvents, balanceChangeEvents, err)
+	}
+}
+
+func TestRuneEtchingWithPremine(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil)
+	mockDb.EXPECT().GetCoinInfoById("runes:RUNE").Return(nil, nil)
+
+	newCoinEvents, balanceChangeEvents, err := runes.Parse(
+		&mempool.Transaction{
+			Txid: "9abc",
+			Vout: []mempool.Vout{
+				{
+					Asm: runestoneAsm(
+						RUNE_TAG_FLAGS, RUNE_FLAG_ETCHING,
+						RUNE_TAG_RUNE, utils.Base26Encode("RUNE"),
+						RUNE_TAG_DIVISIBILITY, 2,
+						RUNE_TAG_PREMINE, 1000,
+						RUNE_TAG_BODY, 0, 400, 2,
+					),
+				},
+				{
+					Address: "1234",
+					Value:   546,
+				},
+				{
+					Address: "5678",
+					Value:   546,
+				},
+			},
+		},
+	)
+
+	if err != nil || len(newCoinEvents) != 1 {
+		t.Fatalf("unexpected result: %v, %v", newCoinEvents, err)
+	}
+
+	expectedCoin := &types.NewCoinEvent{
+		ChainId:  "bitcoin",
+		Protocol: "runes",
+		CoinId:   "runes:RUNE",
+		Args: types.CoinArgs{Runes: &types.RunesArgs{
+			Max:          types.NewAmount(1000),
+			Premine:      types.NewAmount(1000),
+			Divisibility: 2,
+			Spacers:      0,
+			Symbol:       0,
+		}},
+	}
+	if !reflect.DeepEqual(newCoinEvents[0], expectedCoin) {
+		t.Fatalf("unexpected new coin event: %v, expected: %v", newCoinEvents[0], expectedCoin)
+	}
+
+	expected := []*types.BalanceChangeEvent{
+		runeEvent("runes:RUNE", "", 1000, "", true),
+		runeEvent("runes:RUNE", "1234", 600, "9abc:1", false),
+		runeEvent("runes:RUNE", "5678", 400, "9abc:2", false),
+	}
+	if !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v", balanceChangeEvents, expected)
+	}
+}
+
+func TestRuneEtchingNameTaken(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil)
+	mockDb.EXPECT().GetCoinInfoById("runes:RUNE").Return(&types.CoinInfo{Id: "runes:RUNE"}, nil)
+
+	newCoinEvents, balanceChangeEvents, err := runes.Parse(
+		&mempool.Transaction{
+			Txid: "9abc",
+			Vout: []mempool.Vout{
+				{
+					Asm: runestoneAsm(RUNE_TAG_FLAGS, RUNE_FLAG_ETCHING, RUNE_TAG_RUNE, utils.Base26Encode("RUNE"), RUNE_TAG_PREMINE, 1000),
+				},
+				{
+					Address: "1234",
+					Value:   546,
+				},
+			},
+		},
+	)
+
+	if err != nil || len(newCoinEvents) != 0 || len(balanceChangeEvents) != 0 {
+		t.Fatalf("unexpected result: %v, %v, %v", newCoinEvents, balanceChangeEvents, err)
+	}
+}
+
+func TestRuneDefaultAllocation(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	runeInput(mockDb, "runes:RUNE", 100)
+
+	_, balanceChangeEvents, err := runes.Parse(
+		&mempool.Transaction{
+			Txid: "9abc",
+			Vin: []mempool.Vin{
+				{
+					Txid: "5678",
+					Vout: 0,
+				},
+			},
+			Vout: []mempool.Vout{
+				{
+					Asm: "OP_RETURN OP_PUSHBYTES_4 00000000",
+				},
+				{
+					Address: "abcd",
+					Value:   546,
+				},
+			},
+		},
+	)
+
+	expected := []*types.BalanceChangeEvent{
+		runeEvent("runes:RUNE", "1234", -100, "5678:0", false),
+		runeEvent("runes:RUNE", "abcd", 100, "9abc:1", false),
+	}
+	if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+	}
+}
+
+// Runes sent to an output earlier in the block are spent from the pending changes, as the store doesn't hold them yet.
+func TestRuneSpendOutputOfSameBlock(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	runeInput(mockDb, "runes:RUNE", 100)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"9abc:0"}).Return(nil, nil)
+
+	runes.StartBlock(1)
+	if _, _, err := runes.Parse(
+		&mempool.Transaction{
+			Txid: "9abc",
+			Vin: []mempool.Vin{
+				{
+					Txid: "5678",
+					Vout: 0,
+				},
+			},
+			Vout: []mempool.Vout{
+				{
+					Address: "abcd",
+					Value:   546,
+				},
+			},
+		},
+	); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, balanceChangeEvents, err := runes.Parse(
+		&mempool.Transaction{
+			Txid: "def0",
+			Vin: []mempool.Vin{
+				{
+					Txid: "9abc",
+					Vout: 0,
+				},
+			},
+			Vout: []mempool.Vout{
+				{
+					Address: "ef01",
+					Value:   546,
+				},
+			},
+		},
+	)
+
+	expected := []*types.BalanceChangeEvent{
+		runeEvent("runes:RUNE", "abcd", -100, "9abc:0", false),
+		runeEvent("runes:RUNE", "ef01", 100, "def0:0", false),
+	}
+	if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+	}
+}
+
+// A name etched earlier in the block is taken.
+func TestRuneEtchingNameTakenInBlock(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil).Times(2)
+	mockDb.EXPECT().GetCoinInfoById("runes:RUNE").Return(nil, nil)
+
+	tx := &mempool.Transaction{
+		Txid: "9abc",
+		Vout: []mempool.Vout{
+			{
+				Asm: runestoneAsm(RUNE_TAG_FLAGS, RUNE_FLAG_ETCHING, RUNE_TAG_RUNE, utils.Base26Encode("RUNE")),
+			},
+		},
+	}
+	runes.StartBlock(1)
+	if newCoinEvents, _, err := runes.Parse(tx); err != nil || len(newCoinEvents) != 1 {
+		t.Fatalf("unexpected result: %v, %v", newCoinEvents, err)
+	}
+	if newCoinEvents, _, err := runes.Parse(tx); err != nil || len(newCoinEvents) != 0 {
+		t.Fatalf("unexpected result: %v, %v", newCoinEvents, err)
+	}
+}
+
+func TestRuneEdictsAndPointer(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	runeInput(mockDb, "runes:RUNE", 100)
+
+	_, balanceChangeEvents, err := runes.Parse(
+		&mempool.Transaction{
+			Txid: "9abc",
+			Vin: []mempool.Vin{
+				{
+					Txid: "5678",
+					Vout: 0,
+				},
+			},
+			Vout: []mempool.Vout{
+				{
+					Address: "abcd",
+					Value:   546,
+				},
+				{
+					Asm: runestoneAsm(RUNE_TAG_POINTER, 3, RUNE_TAG_BODY, utils.Base26Encode("RUNE"), 30, 0, utils.Base26Encode("RUNE"), 10, 1),
+				},
+				{
+					Address: "ef01",
+					Value:   546,
+				},
+				{
+					Address: "2345",
+					Value:   546,
+				},
+			},
+		},
+	)
+
+	// 10 runes sent to the runestone output are burnt.
+	expected := []*types.BalanceChangeEvent{
+		runeEvent("runes:RUNE", "1234", -100, "5678:0", false),
+		runeEvent("runes:RUNE", "abcd", 30, "9abc:0", false),
+		runeEvent("runes:RUNE", "2345", 60, "9abc:3", false),
+	}
+	if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+	}
+}
+
+func TestRuneSplitEdict(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	runeInput(mockDb, "runes:RUNE", 100)
+
+	_, balanceChangeEvents, err := runes.Parse(
+		&mempool.Transaction{
+			Txid: "9abc",
+			Vin: []mempool.Vin{
+				{
+					Txid: "5678",
+					Vout: 0,
+				},
+			},
+			Vout: []mempool.Vout{
+				{
+					Asm: runestoneAsm(RUNE_TAG_BODY, utils.Base26Encode("RUNE"), 0, 4),
+				},
+				{
+					Address: "abcd",
+					Value:   546,
+				},
+				{
+					Address: "ef01",
+					Value:   546,
+				},
+				{
+					Address: "2345",
+					Value:   546,
+				},
+			},
+		},
+	)
+
+	expected := []*types.BalanceChangeEvent{
+		runeEvent("runes:RUNE", "1234", -100, "5678:0", false),
+		runeEvent("runes:RUNE", "abcd", 34, "9abc:1", false),
+		runeEvent("runes:RUNE", "ef01", 33, "9abc:2", false),
+		runeEvent("runes:RUNE", "2345", 33, "9abc:3", false),
+	}
+	if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+	}
+}
+
+func TestRuneCenotaphBurnsInputs(t *testing.T) {
+	tests := []struct {
+		name string
+		asm  string
+	}{
+		{"unrecognized even tag", runestoneAsm(24, 1)},
+		{"unrecognized flag", runestoneAsm(RUNE_TAG_FLAGS, 8)},
+		{"edict output out of range", runestoneAsm(RUNE_TAG_BODY, utils.Base26Encode("RUNE"), 0, 3)},
+		{"trailing edict integers", runestoneAsm(RUNE_TAG_BODY, utils.Base26Encode("RUNE"), 0)},
+		{"truncated varint", RUNE_PREFIX + "OP_PUSHBYTES_1 80"},
+		{"non push opcode", RUNE_PREFIX + "OP_DROP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDb, runes := setupRune(t)
+			runeInput(mockDb, "runes:RUNE", 100)
+
+			_, balanceChangeEvents, err := runes.Parse(
+				&mempool.Transaction{
+					Txid: "9abc",
+					Vin: []mempool.Vin{
+						{
+							Txid: "5678",
+							Vout: 0,
+						},
+					},
+					Vout: []mempool.Vout{
+						{
+							Asm: tt.asm,
+						},
+						{
+							Address: "abcd",
+							Value:   546,
+						},
+					},
+				},
+			)
+
+			expected := []*types.BalanceChangeEvent{
+				runeEvent("runes:RUNE", "1234", -100, "5678:0", false),
+			}
+			if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+				t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+			}
+		})
+	}
+}
+
+func TestRuneIgnoresOtherProtocols(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0"}).Return([]*types.UnspentCoin{
+		{
+			CoinId:   "CARV",
+			Protocol: "carv",
+			Owner:    "1234",
+			Amount:   types.NewAmount(1),
+			Utxo:     "5678:0",
+		},
+	}, nil)
+
+	_, balanceChangeEvents, err := runes.Parse(
+		&mempool.Transaction{
+			Vin: []mempool.Vin{
+				{
+					Txid: "5678",
+					Vout: 0,
+				},
+			},
+			Vout: []mempool.Vout{
+				{
+					Address: "abcd",
+					Value:   10000,
+				},
+			},
+		},
+	)
+
+	if err != nil || balanceChangeEvents != nil {
+		t.Fatalf("unexpected result: %v, %v", balanceChangeEvents, err)
+	}
+}
+
+// Runes of both inputs go to the first output, which keeps both.
+func TestRuneMultipleRunesOnOneOutput(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{"5678:0", "5678:1"}).Return([]*types.UnspentCoin{
+		{
+			CoinId:   "runes:RUNEA",
+			Protocol: "runes",
+			Owner:    "1234",
+			Amount:   types.NewAmount(1),
+			Utxo:     "5678:0",
+		},
+		{
+			CoinId:   "runes:RUNEB",
+			Protocol: "runes",
+			Owner:    "1234",
+			Amount:   types.NewAmount(1),
+			Utxo:     "5678:1",
+		},
+	}, nil)
+
+	_, balanceChangeEvents, err := runes.Parse(
+		&mempool.Transaction{
+			Txid: "9abc",
+			Vin: []mempool.Vin{
+				{
+					Txid: "5678",
+					Vout: 0,
+				},
+				{
+					Txid: "5678",
+					Vout: 1,
+				},
+			},
+			Vout: []mempool.Vout{
+				{
+					Address: "abcd",
+					Value:   546,
+				},
+			},
+		},
+	)
+
+	expected := []*types.BalanceChangeEvent{
+		runeEvent("runes:RUNEA", "1234", -1, "5678:0", false),
+		runeEvent("runes:RUNEB", "1234", -1, "5678:1", false),
+		runeEvent("runes:RUNEA", "abcd", 1, "9abc:0", false),
+		runeEvent("runes:RUNEB", "abcd", 1, "9abc:0", false),
+	}
+	if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+	}
+}
+
+func TestRuneEtchingWithTerms(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil)
+	mockDb.EXPECT().GetCoinInfoById("runes:RUNE").Return(nil, nil)
+
+	runes.StartBlock(5)
+	newCoinEvents, _, err := runes.Parse(
+		&mempool.Transaction{
+			Txid: "9abc",
+			Vout: []mempool.Vout{
+				{
+					Asm: runestoneAsm(
+						RUNE_TAG_FLAGS, RUNE_FLAG_ETCHING|RUNE_FLAG_TERMS,
+						RUNE_TAG_RUNE, utils.Base26Encode("RUNE"),
+						RUNE_TAG_PREMINE, 1000,
+						RUNE_TAG_AMOUNT, 100,
+						RUNE_TAG_CAP, 2,
+						RUNE_TAG_HEIGHT_START, 10,
+						RUNE_TAG_HEIGHT_END, 30,
+						RUNE_TAG_OFFSET_END, 20,
+					),
+				},
+				{
+					Address: "1234",
+					Value:   546,
+				},
+			},
+		},
+	)
+
+	expected := &types.RunesArgs{
+		Max:       types.NewAmount(1200),
+		Limit:     types.NewAmount(100),
+		Premine:   types.NewAmount(1000),
+		Cap:       2,
+		MintStart: 10,
+		MintEnd:   25,
+	}
+	if err != nil || len(newCoinEvents) != 1 || !reflect.DeepEqual(newCoinEvents[0].Args.Runes, expected) {
+		t.Fatalf("unexpected result: %v, %v", newCoinEvents, err)
+	}
+}
+
+// Mints are open in the window of the terms until the cap is reached, counting the mints earlier in the block.
+func TestRuneMint(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil).AnyTimes()
+	ci := &types.CoinInfo{
+		Id:          "runes:RUNE",
+		Protocol:    "runes",
+		TotalSupply: types.NewAmount(1100),
+		Args: types.CoinArgs{Runes: &types.RunesArgs{
+			Max:       types.NewAmount(1200),
+			Limit:     types.NewAmount(100),
+			Premine:   types.NewAmount(1000),
+			Cap:       2,
+			MintStart: 10,
+			MintEnd:   25,
+		}},
+	}
+	mockDb.EXPECT().GetCoinInfoById("runes:RUNE").Return(ci, nil).Times(2)
+	mintTx := func(txid string) *mempool.Transaction {
+		return &mempool.Transaction{
+			Txid: txid,
+			Vout: []mempool.Vout{
+				{
+					Asm: runestoneAsm(RUNE_TAG_MINT, utils.Base26Encode("RUNE")),
+				},
+				{
+					Address: "1234",
+					Value:   546,
+				},
+			},
+		}
+	}
+
+	runes.StartBlock(25)
+	_, balanceChangeEvents, err := runes.Parse(mintTx("9abc"))
+	if err != nil || balanceChangeEvents != nil {
+		t.Fatalf("unexpected mint after the end: %v, %v", balanceChangeEvents, err)
+	}
+
+	runes.StartBlock(24)
+	_, balanceChangeEvents, err = runes.Parse(mintTx("9abc"))
+	expected := []*types.BalanceChangeEvent{
+		runeEvent("runes:RUNE", "", 100, "", true),
+		runeEvent("runes:RUNE", "1234", 100, "9abc:1", false),
+	}
+	if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+	}
+	_, balanceChangeEvents, err = runes.Parse(mintTx("def0"))
+	if err != nil || balanceChangeEvents != nil {
+		t.Fatalf("unexpected mint above the cap: %v, %v", balanceChangeEvents, err)
+	}
+	if ci.TotalSupply.Cmp(types.NewAmount(1100)) != 0 {
+		t.Fatal("coin info of the store updated")
+	}
+}
+
+// Runes minted by a cenotaph count toward the cap and are burnt.
+func TestRuneMintCenotaph(t *testing.T) {
+	mockDb, runes := setupRune(t)
+	mockDb.EXPECT().GetCoinsInUtxos([]string{}).Return(nil, nil)
+	mockDb.EXPECT().GetCoinInfoById("runes:RUNE").Return(&types.CoinInfo{
+		Id:       "runes:RUNE",
+		Protocol: "runes",
+		Args:     types.CoinArgs{Runes: &types.RunesArgs{Max: types.NewAmount(100), Limit: types.NewAmount(100), Cap: 1}},
+	}, nil)
+
+	_, balanceChangeEvents, err := runes.Parse(
+		&mempool.Transaction{
+			Txid: "9abc",
+			Vout: []mempool.Vout{
+				{
+					Asm: runestoneAsm(RUNE_TAG_MINT, utils.Base26Encode("RUNE"), 24, 1),
+				},
+				{
+					Address: "1234",
+					Value:   546,
+				},
+			},
+		},
+	)
+
+	expected := []*types.BalanceChangeEvent{runeEvent("runes:RUNE", "", 100, "", true)}
+	if err != nil || !reflect.DeepEqual(balanceChangeEvents, expected) {
+		t.Fatalf("unexpected balance change events: %v, expected: %v, err: %v", balanceChangeEvents, expected, err)
+	}
+}
@@ -1,15 +1,23 @@
 package store
 
 import (
+	"fmt"
+
 	"github.com/dgraph-io/badger"
 )
 
+var (
+	// A transaction holds up to 15% of a table, the 64MB default is too small for the busiest blocks. The memtables
+	// take up to 5 tables of memory.
+	BADGER_MAX_TABLE_SIZE = int64(128 << 20)
+)
+
 type BadgerDB struct {
 	impl *badger.DB
 }
 
 func NewBadgerDB(path string) *BadgerDB {
-	bdg, err := badger.Open(badger.DefaultOptions(path))
+	bdg, err := badger.Open(badger.DefaultOptions(path).WithMaxTableSize(BADGER_MAX_TABLE_SIZE))
 	if err != nil {
 		panic(err)
 	}
@@ -88,6 +96,37 @@ func (db *BadgerDB) BatchSet(keys []string, values [][]byte) error {
 	return nil
 }
 
+// AtomicBatchSet writes all the keys in one transaction, a nil value deletes the key. The batch must fit in the
+// transaction size limit set by BADGER_MAX_TABLE_SIZE.
+func (db *BadgerDB) AtomicBatchSet(keys []string, values [][]byte) error {
+	err := db.impl.Update(func(txn *badger.Txn) error {
+		for i := range keys {
+			if len(keys[i]) == 0 {
+				continue
+			}
+
+			var err error
+			if values[i] == nil {
+				err = txn.Delete([]byte(keys[i]))
+			} else {
+				err = txn.Set([]byte(keys[i]), values[i])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == badger.ErrTxnTooBig {
+		size := 0
+		for i := range keys {
+			size += len(keys[i]) + len(values[i])
+		}
+		return fmt.Errorf("batch of %d keys and %d bytes exceeds the transaction limit of %d keys or %d bytes, raise BADGER_MAX_TABLE_SIZE: %w", len(keys), size, db.impl.MaxBatchCount(), db.impl.MaxBatchSize(), err)
+	}
+	return err
+}
+
 func (db *BadgerDB) Query(prefix string) (keys []string, values [][]byte, err error) {
 	db.impl.View(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.IteratorOptions{
@@ -108,6 +147,82 @@ func (db *BadgerDB) Query(prefix string) (keys []string, values [][]byte, err er
 	return
 }
 
+// Iterate calls fn on the keys with the prefix in order, without loading them all, and stops at the first error.
+func (db *BadgerDB) Iterate(prefix string, fn func(key string, value []byte) error) error {
+	return db.impl.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			PrefetchValues: true,
+			PrefetchSize:   100,
+			Prefix:         []byte(prefix),
+		})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(string(it.Item().Key()), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// IterateReverse calls fn on the keys with the prefix before the seek key, or on all of them if it's empty, in reverse
+// order. It stops when fn returns false or an error.
+func (db *BadgerDB) IterateReverse(prefix string, before string, fn func(key string, value []byte) (bool, error)) error {
+	if len(before) == 0 {
+		before = prefix + "\xff"
+	}
+	return db.impl.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			PrefetchValues: true,
+			PrefetchSize:   100,
+			Reverse:        true,
+			Prefix:         []byte(prefix),
+		})
+		defer it.Close()
+
+		// Seeking in reverse stops at the last key not greater than the seek key.
+		for it.Seek([]byte(before)); it.ValidForPrefix([]byte(prefix)); it.Next() {
+			if string(it.Item().Key()) == before {
+				continue
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if more, err := fn(string(it.Item().Key()), value); !more || err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Last returns the last key with the prefix and its value, "" if there's none.
+func (db *BadgerDB) Last(prefix string) (key string, value []byte, err error) {
+	err = db.impl.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			Reverse: true,
+			Prefix:  []byte(prefix),
+		})
+		defer it.Close()
+
+		// Seeking in reverse stops at the last key not greater than the seek key.
+		it.Seek(append([]byte(prefix), 0xff))
+		if !it.ValidForPrefix([]byte(prefix)) {
+			return nil
+		}
+		key = string(it.Item().Key())
+		value, err = it.Item().ValueCopy(nil)
+		return err
+	})
+	return
+}
+
 func (db *BadgerDB) Sync() error {
 	return db.impl.Sync()
 }
@@ -2,8 +2,12 @@ package store
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"testing"
+
+	"github.com/dgraph-io/badger"
 )
 
 func TestBadgerDBFunctions(t *testing.T) {
@@ -60,3 +64,68 @@ func TestBadgerDBFunctions(t *testing.T) {
 	db.Close()
 	os.RemoveAll("./badger-test/")
 }
+
+func TestBadgerDBAtomicBatchSet(t *testing.T) {
+	db := NewBadgerDB("./badger-atomic-test/")
+	defer func() {
+		db.Close()
+		os.RemoveAll("./badger-atomic-test/")
+	}()
+
+	if err := db.AtomicBatchSet([]string{"a01", "a02"}, [][]byte{{1}, {2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AtomicBatchSet([]string{"a01", "a03"}, [][]byte{nil, {3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, values, _ := db.Query("a")
+	if len(keys) != 2 || keys[0] != "a02" || keys[1] != "a03" || !bytes.Equal(values[1], []byte{3}) {
+		t.Errorf("AtomicBatchSet Failed: %v %v", keys, values)
+	}
+}
+
+func TestBadgerDBAtomicBatchSetLarge(t *testing.T) {
+	// A batch over the default limit fits after raising the table size.
+	opts := badger.DefaultOptions(t.TempDir())
+	opts.Logger = nil
+	bdg, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := int(bdg.MaxBatchCount()) + 1
+	bdg.Close()
+
+	db := NewBadgerDB(t.TempDir())
+	defer db.Close()
+
+	keys := make([]string, count)
+	values := make([][]byte, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("a%08d", i)
+		values[i] = []byte{1}
+	}
+	if err := db.AtomicBatchSet(keys, values); err != nil {
+		t.Fatal(err)
+	}
+	keys, _, _ = db.Query("a")
+	if len(keys) != count {
+		t.Errorf("AtomicBatchSet Failed: got %d keys, want %d", len(keys), count)
+	}
+
+	// A batch over the raised limit is rejected with a clear error and nothing is written.
+	count = int(db.impl.MaxBatchCount()) + 1
+	keys = make([]string, count)
+	values = make([][]byte, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("b%08d", i)
+		values[i] = []byte{1}
+	}
+	err = db.AtomicBatchSet(keys, values)
+	if !errors.Is(err, badger.ErrTxnTooBig) {
+		t.Fatalf("AtomicBatchSet Failed: %v", err)
+	}
+	if keys, _, _ = db.Query("b"); len(keys) != 0 {
+		t.Errorf("AtomicBatchSet Failed: wrote %d keys of a rejected batch", len(keys))
+	}
+}
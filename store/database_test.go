@@ -0,0 +1,559 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type closableDatabase interface {
+	Database
+	Close() error
+}
+
+// The stores below must behave the same, each test opens them on its own directory.
+var testStores = []struct {
+	name string
+	open func(path string, journalDepth int) closableDatabase
+}{
+	{"memdb", func(path string, journalDepth int) closableDatabase {
+		return NewMemDb(path, "testnet", journalDepth, false, zap.NewNop())
+	}},
+	{"diskdb", func(path string, journalDepth int) closableDatabase {
+		return NewDiskDb(path, "testnet", journalDepth, 1000, zap.NewNop())
+	}},
+	{"diskdb-evicting", func(path string, journalDepth int) closableDatabase {
+		return NewDiskDb(path, "testnet", journalDepth, 2, zap.NewNop())
+	}},
+}
+
+func forEachStore(t *testing.T, test func(t *testing.T, open func(journalDepth int) closableDatabase)) {
+	for _, s := range testStores {
+		t.Run(s.name, func(t *testing.T) {
+			path := t.TempDir()
+			test(t, func(journalDepth int) closableDatabase { return s.open(path, journalDepth) })
+		})
+	}
+}
+
+func sortedCoins(coins []*types.UnspentCoin) []*types.UnspentCoin {
+	sort.Slice(coins, func(i, j int) bool { return coins[i].Utxo < coins[j].Utxo })
+	return coins
+}
+
+func TestDatabaseApplyBlockBalances(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func(int) closableDatabase) {
+		db := open(100)
+		defer db.Close()
+		assert.Nil(t, db.ApplyBlock(&types.BlockUpdate{
+			Height:    1,
+			CoinInfos: map[string]*types.CoinInfo{"c1": {Id: "c1"}, "c2": {Id: "c2"}},
+			Balances:  map[string]map[string]types.Amount{"c1": {"a1": types.NewAmount(1), "a2": types.NewAmount(2)}},
+		}))
+		assert.Nil(t, db.ApplyBlock(&types.BlockUpdate{
+			Height: 2,
+			Balances: map[string]map[string]types.Amount{
+				"c1": {"a1": types.NewAmount(-1), "a2": types.NewAmount(1)},
+				"c2": {"a1": types.NewAmount(1)},
+			},
+		}))
+
+		balances, _ := db.GetBalancesByAddress("a1")
+		assert.Equal(t, map[string]types.Amount{"c2": types.NewAmount(1)}, balances)
+		balances, _ = db.GetBalancesByAddress("a2")
+		assert.Equal(t, map[string]types.Amount{"c1": types.NewAmount(3)}, balances)
+		ci, _ := db.GetCoinInfoById("c1")
+		assert.Equal(t, 1, ci.HolderCount)
+		ci, _ = db.GetCoinInfoById("c2")
+		assert.Equal(t, 1, ci.HolderCount)
+		holders, total, _ := db.GetHolders("c1", 0, 10)
+		assert.Equal(t, []*types.Holder{{Address: "a2", Balance: types.NewAmount(3)}}, holders)
+		assert.Equal(t, 1, total)
+	})
+}
+
+func applyTestBlocks(db Database) {
+	// Block 1: deploy c1 and mint to a1.
+	db.ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		Hash:   "h1",
+		CoinInfos: map[string]*types.CoinInfo{
+			"c1": {
+				Id:          "c1",
+				TotalSupply: types.NewAmount(2),
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max: 100,
+				}},
+				TxCount: 2,
+			},
+		},
+		Balances: map[string]map[string]types.Amount{
+			"c1": {
+				"a1": types.NewAmount(2),
+			},
+		},
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			"u1": {"c1": {
+				CoinId: "c1",
+				Owner:  "a1",
+				Amount: types.NewAmount(2),
+				Utxo:   "u1",
+			}},
+		},
+		History: []*types.HistoryEntry{
+			{Txid: "t1", Height: 1, CoinId: "c1", Address: "a1", Delta: types.NewAmount(2), Direction: "in", Utxo: "u1", IsMint: true},
+		},
+		CoinTxs: []*types.CoinTx{
+			{Txid: "t0", Height: 1, CoinId: "c1", Type: "deploy"},
+			{Txid: "t1", Height: 1, CoinId: "c1", Type: "mint"},
+		},
+	})
+
+	// Block 2: transfer from a1 to a2.
+	db.ApplyBlock(&types.BlockUpdate{
+		Height: 2,
+		Hash:   "h2",
+		CoinInfos: map[string]*types.CoinInfo{
+			"c1": {
+				Id:          "c1",
+				TotalSupply: types.NewAmount(2),
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max: 100,
+				}},
+				TxCount: 3,
+			},
+		},
+		Balances: map[string]map[string]types.Amount{
+			"c1": {
+				"a1": types.NewAmount(-2),
+				"a2": types.NewAmount(2),
+			},
+		},
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			"u1": {"c1": nil},
+			"u2": {"c1": {
+				CoinId: "c1",
+				Owner:  "a2",
+				Amount: types.NewAmount(2),
+				Utxo:   "u2",
+			}},
+		},
+		History: []*types.HistoryEntry{
+			{Txid: "t2", Height: 2, Index: 0, CoinId: "c1", Address: "a1", Delta: types.NewAmount(-2), Direction: "out", Utxo: "u1"},
+			{Txid: "t2", Height: 2, Index: 1, CoinId: "c1", Address: "a2", Delta: types.NewAmount(2), Direction: "in", Utxo: "u2"},
+		},
+		Spends: map[string]types.SpentCoins{
+			"u1": {{
+				UnspentCoin: types.UnspentCoin{CoinId: "c1", Owner: "a1", Amount: types.NewAmount(2), Utxo: "u1"},
+				SpentTxid:   "t2",
+				SpentHeight: 2,
+			}},
+		},
+		CoinTxs: []*types.CoinTx{
+			{Txid: "t2", Height: 2, CoinId: "c1", Type: "transfer"},
+		},
+		InvalidOps: []*types.InvalidOperation{
+			{Txid: "t3", Height: 2, CoinId: "c1", Protocol: "carv", Reason: "insufficient inputs for transfer, input = 0, output = 1"},
+		},
+	})
+}
+
+func TestDatabaseSaveLoad(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func(int) closableDatabase) {
+		db := open(100)
+		applyTestBlocks(db)
+		for height := 3; height <= 11; height++ {
+			db.ApplyBlock(&types.BlockUpdate{
+				Height: height,
+				Hash:   fmt.Sprintf("h%d", height),
+				History: []*types.HistoryEntry{
+					{Txid: "t1", Height: height, Index: 0, CoinId: "c1", Address: "a3", Delta: types.NewAmount(1), Direction: "in"},
+				},
+			})
+		}
+		coins, _ := db.GetCoinInfos()
+		db.Close()
+
+		db = open(100)
+		defer db.Close()
+		height, network, _ := db.GetStatus()
+		assert.Equal(t, 11, height)
+		assert.Equal(t, "testnet", network)
+		hash, _ := db.GetBlockHash(2)
+		assert.Equal(t, "h2", hash)
+		loaded, _ := db.GetCoinInfos()
+		assert.Equal(t, coins, loaded)
+		assert.Equal(t, 3, loaded[0].TxCount)
+		assert.Equal(t, 1, loaded[0].HolderCount)
+
+		utxos, _ := db.GetCoinsInUtxos([]string{"u1", "u2"})
+		assert.Equal(t, []*types.UnspentCoin{{CoinId: "c1", Owner: "a2", Amount: types.NewAmount(2), Utxo: "u2"}}, utxos)
+		utxos, _ = db.GetCoinsByAddress("a2")
+		assert.Equal(t, []*types.UnspentCoin{{CoinId: "c1", Owner: "a2", Amount: types.NewAmount(2), Utxo: "u2"}}, utxos)
+		utxos, _ = db.GetCoinsByAddress("a1")
+		assert.Equal(t, 0, len(utxos))
+		balances, _ := db.GetBalancesByAddress("a2")
+		assert.Equal(t, map[string]types.Amount{"c1": types.NewAmount(2)}, balances)
+		balances, _ = db.GetBalancesByAddress("a1")
+		assert.Equal(t, 0, len(balances))
+
+		history, _, _ := db.GetHistoryByAddress("a1", "", 0, math.MaxInt, 0, 10)
+		assert.Equal(t, []string{"t2", "t1"}, []string{history[0].Txid, history[1].Txid})
+		// Heights are zero padded in keys, so 10 and 11 are listed before 2.
+		history, _, _ = db.GetHistoryByAddress("a3", "", 0, math.MaxInt, 0, 10)
+		assert.Equal(t, 9, len(history))
+		assert.Equal(t, 11, history[0].Height)
+		txs, _ := db.GetCoinTxs("c1", math.MaxInt, 10, "")
+		assert.Equal(t, []int{2, 1, 0}, []int{txs[0].Seq, txs[1].Seq, txs[2].Seq})
+		assert.Equal(t, "transfer", txs[0].Type)
+		txs, _ = db.GetCoinTxsByTxid("t2")
+		assert.Equal(t, 1, len(txs))
+		assert.Equal(t, 2, txs[0].Seq)
+		spent, _ := db.GetSpentCoins([]string{"u1", "u2"})
+		assert.Equal(t, 1, len(spent))
+		assert.Equal(t, "t2", spent[0].SpentTxid)
+		ops, _ := db.GetInvalidOperations("t3")
+		assert.Equal(t, "c1", ops[0].CoinId)
+		holders, _, _ := db.GetHolders("c1", 0, 10)
+		assert.Equal(t, []*types.Holder{{Address: "a2", Balance: types.NewAmount(2)}}, holders)
+	})
+}
+
+func TestDatabaseRevertBlocks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func(int) closableDatabase) {
+		db := open(100)
+		defer db.Close()
+		applyTestBlocks(db)
+
+		assert.Nil(t, db.RevertBlocks(1))
+		height, _, _ := db.GetStatus()
+		assert.Equal(t, 1, height)
+		ci, _ := db.GetCoinInfoById("c1")
+		assert.Equal(t, 2, ci.TxCount)
+		assert.Equal(t, 1, ci.HolderCount)
+		balances, _ := db.GetBalancesByAddress("a1")
+		assert.Equal(t, map[string]types.Amount{"c1": types.NewAmount(2)}, balances)
+		balances, _ = db.GetBalancesByAddress("a2")
+		assert.Equal(t, 0, len(balances))
+		utxos, _ := db.GetCoinsInUtxos([]string{"u1", "u2"})
+		assert.Equal(t, 1, len(utxos))
+		assert.Equal(t, "a1", utxos[0].Owner)
+		utxos, _ = db.GetCoinsByAddress("a1")
+		assert.Equal(t, 1, len(utxos))
+		utxos, _ = db.GetCoinsByAddress("a2")
+		assert.Equal(t, 0, len(utxos))
+		hash, _ := db.GetBlockHash(2)
+		assert.Equal(t, "", hash)
+		history, _, _ := db.GetHistoryByAddress("a1", "", 0, math.MaxInt, 0, 10)
+		assert.Equal(t, 1, len(history))
+		history, _, _ = db.GetHistoryByAddress("a2", "", 0, math.MaxInt, 0, 10)
+		assert.Equal(t, 0, len(history))
+		txs, _ := db.GetCoinTxs("c1", math.MaxInt, 10, "")
+		assert.Equal(t, 2, len(txs))
+		assert.Equal(t, 1, txs[0].Seq)
+		assert.Equal(t, "mint", txs[0].Type)
+		spent, _ := db.GetSpentCoins([]string{"u1"})
+		assert.Equal(t, 0, len(spent))
+		txs, _ = db.GetCoinTxsByTxid("t2")
+		assert.Equal(t, 0, len(txs))
+		ops, _ := db.GetInvalidOperations("t3")
+		assert.Equal(t, 0, len(ops))
+		holders, _, _ := db.GetHolders("c1", 0, 10)
+		assert.Equal(t, []*types.Holder{{Address: "a1", Balance: types.NewAmount(2)}}, holders)
+		holders, total, _ := db.GetHolders("c1", 1, 10)
+		assert.Equal(t, 0, len(holders))
+		assert.Equal(t, 1, total)
+
+		// Transactions appended after the revert continue the sequence.
+		assert.Nil(t, db.ApplyBlock(&types.BlockUpdate{
+			Height:  2,
+			Hash:    "h2b",
+			CoinTxs: []*types.CoinTx{{Txid: "t4", Height: 2, CoinId: "c1", Type: "mint"}},
+		}))
+		txs, _ = db.GetCoinTxs("c1", math.MaxInt, 10, "")
+		assert.Equal(t, 2, txs[0].Seq)
+
+		assert.Nil(t, db.RevertBlocks(2))
+		coins, _ := db.GetCoinInfos()
+		assert.Equal(t, 0, len(coins))
+		utxos, _ = db.GetCoinsByAddress("a1")
+		assert.Equal(t, 0, len(utxos))
+		balances, _ = db.GetBalancesByAddress("a1")
+		assert.Equal(t, 0, len(balances))
+		history, _, _ = db.GetHistoryByAddress("a1", "", 0, math.MaxInt, 0, 10)
+		assert.Equal(t, 0, len(history))
+		txs, _ = db.GetCoinTxs("c1", math.MaxInt, 10, "")
+		assert.Equal(t, 0, len(txs))
+		_, total, _ = db.GetHolders("c1", 0, 10)
+		assert.Equal(t, 0, total)
+
+		assert.NotNil(t, db.RevertBlocks(1))
+	})
+}
+
+// A transaction rejected several times keeps all its operations.
+func TestDatabaseInvalidOperations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func(int) closableDatabase) {
+		ops := []*types.InvalidOperation{
+			{Txid: "t1", Height: 1, CoinId: "c1", Protocol: "carv", Reason: "r1"},
+			{Txid: "t1", Height: 1, CoinId: "c2", Protocol: "runes", Reason: "r2"},
+			{Txid: "t2", Height: 1, CoinId: "c1", Protocol: "carv", Reason: "r3"},
+		}
+		db := open(100)
+		assert.Nil(t, db.ApplyBlock(&types.BlockUpdate{Height: 1, Hash: "h1", InvalidOps: ops}))
+		db.Close()
+
+		db = open(100)
+		defer db.Close()
+		rejected, _ := db.GetInvalidOperations("t1")
+		assert.Equal(t, ops[:2], rejected)
+		rejected, _ = db.GetInvalidOperations("t2")
+		assert.Equal(t, ops[2:], rejected)
+
+		assert.Nil(t, db.RevertBlocks(1))
+		rejected, _ = db.GetInvalidOperations("t1")
+		assert.Equal(t, 0, len(rejected))
+		rejected, _ = db.GetInvalidOperations("t2")
+		assert.Equal(t, 0, len(rejected))
+	})
+}
+
+// Transactions are listed newest first from the cursor, in pages of the limit, after reopening too.
+func TestDatabaseCoinTxsPage(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func(int) closableDatabase) {
+		var txs []*types.CoinTx
+		for i := 0; i < 12; i++ {
+			txType := "mint"
+			if i%3 == 0 {
+				txType = "transfer"
+			}
+			txs = append(txs, &types.CoinTx{Txid: fmt.Sprintf("t%d", i), Height: 1, CoinId: "c1", Type: txType})
+		}
+		db := open(100)
+		assert.Nil(t, db.ApplyBlock(&types.BlockUpdate{Height: 1, Hash: "h1", CoinTxs: txs}))
+		db.Close()
+
+		db = open(100)
+		defer db.Close()
+		seqs := func(txs []*types.CoinTx) []int {
+			var seqs []int
+			for _, tx := range txs {
+				seqs = append(seqs, tx.Seq)
+			}
+			return seqs
+		}
+		page, err := db.GetCoinTxs("c1", math.MaxInt, 5, "")
+		assert.Nil(t, err)
+		assert.Equal(t, []int{11, 10, 9, 8, 7}, seqs(page))
+		page, _ = db.GetCoinTxs("c1", 7, 5, "")
+		assert.Equal(t, []int{6, 5, 4, 3, 2}, seqs(page))
+		page, _ = db.GetCoinTxs("c1", 2, 5, "")
+		assert.Equal(t, []int{1, 0}, seqs(page))
+		page, _ = db.GetCoinTxs("c1", 0, 5, "")
+		assert.Equal(t, 0, len(page))
+		page, _ = db.GetCoinTxs("c1", 10, 3, "transfer")
+		assert.Equal(t, []int{9, 6, 3}, seqs(page))
+		page, _ = db.GetCoinTxs("c2", math.MaxInt, 5, "")
+		assert.Equal(t, 0, len(page))
+	})
+}
+
+// History is listed newest first, filtered by coin and height range, from the offset.
+func TestDatabaseHistoryPage(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func(int) closableDatabase) {
+		db := open(100)
+		for height := 1; height <= 12; height++ {
+			var history []*types.HistoryEntry
+			for i, coin := range []string{"c1", "c2"} {
+				history = append(history, &types.HistoryEntry{
+					Address: "a1", Height: height, Index: i, Txid: fmt.Sprintf("t%d", height), CoinId: coin, Delta: types.NewAmount(1),
+				})
+			}
+			assert.Nil(t, db.ApplyBlock(&types.BlockUpdate{Height: height, Hash: fmt.Sprintf("h%d", height), History: history}))
+		}
+		db.Close()
+
+		db = open(100)
+		defer db.Close()
+		heights := func(history []*types.HistoryEntry) []int {
+			var heights []int
+			for _, entry := range history {
+				heights = append(heights, entry.Height)
+			}
+			return heights
+		}
+		history, total, err := db.GetHistoryByAddress("a1", "", 0, math.MaxInt, 0, 3)
+		assert.Nil(t, err)
+		assert.Equal(t, 24, total)
+		assert.Equal(t, []int{12, 12, 11}, heights(history))
+		assert.Equal(t, "c2", history[0].CoinId)
+		history, total, _ = db.GetHistoryByAddress("a1", "c1", 3, 10, 2, 3)
+		assert.Equal(t, 8, total)
+		assert.Equal(t, []int{8, 7, 6}, heights(history))
+		assert.Equal(t, "c1", history[0].CoinId)
+		history, total, _ = db.GetHistoryByAddress("a1", "c1", 3, 10, 8, 3)
+		assert.Equal(t, 8, total)
+		assert.Equal(t, 0, len(history))
+		history, total, _ = db.GetHistoryByAddress("a1", "c3", 0, math.MaxInt, 0, 3)
+		assert.Equal(t, 0, total)
+		assert.Equal(t, 0, len(history))
+	})
+}
+
+func TestDatabaseJournalPrune(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func(int) closableDatabase) {
+		db := open(1)
+		applyTestBlocks(db)
+		db.Close()
+
+		db = open(1)
+		defer db.Close()
+		hash, _ := db.GetBlockHash(1)
+		assert.Equal(t, "", hash)
+		// Too deep, nothing is reverted.
+		assert.NotNil(t, db.RevertBlocks(2))
+		height, _, _ := db.GetStatus()
+		assert.Equal(t, 2, height)
+		assert.Nil(t, db.RevertBlocks(1))
+		assert.NotNil(t, db.RevertBlocks(1))
+	})
+}
+
+func TestDatabaseSaveLoadRevertBlocks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func(int) closableDatabase) {
+		db := open(100)
+		applyTestBlocks(db)
+		db.Close()
+
+		db = open(100)
+		assert.Nil(t, db.RevertBlocks(1))
+		db.Close()
+
+		db = open(100)
+		defer db.Close()
+		height, _, _ := db.GetStatus()
+		assert.Equal(t, 1, height)
+		hash, _ := db.GetBlockHash(1)
+		assert.Equal(t, "h1", hash)
+		balances, _ := db.GetBalancesByAddress("a1")
+		assert.Equal(t, map[string]types.Amount{"c1": types.NewAmount(2)}, balances)
+		utxos, _ := db.GetCoinsByAddress("a1")
+		assert.Equal(t, 1, len(utxos))
+		history, _, _ := db.GetHistoryByAddress("a1", "", 0, math.MaxInt, 0, 10)
+		assert.Equal(t, 1, len(history))
+		txs, _ := db.GetCoinTxs("c1", math.MaxInt, 10, "")
+		assert.Equal(t, 2, len(txs))
+		txs, _ = db.GetCoinTxsByTxid("t1")
+		assert.Equal(t, 1, len(txs))
+		spent, _ := db.GetSpentCoins([]string{"u1"})
+		assert.Equal(t, 0, len(spent))
+		ops, _ := db.GetInvalidOperations("t3")
+		assert.Equal(t, 0, len(ops))
+		assert.Nil(t, db.RevertBlocks(1))
+		assert.NotNil(t, db.RevertBlocks(1))
+	})
+}
+
+// Holders updated block by block must match the balances, whatever the order of reads and reverts.
+func TestDatabaseHoldersRandom(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open func(int) closableDatabase) {
+		db := open(100)
+		defer db.Close()
+		db.ApplyBlock(&types.BlockUpdate{Height: 1, CoinInfos: map[string]*types.CoinInfo{"c1": {Id: "c1"}}})
+
+		r := rand.New(rand.NewSource(1))
+		expected := make(map[string]int)
+		for height := 2; height < 50; height++ {
+			balances := make(map[string]int)
+			for i := 0; i < 10; i++ {
+				address := fmt.Sprintf("a%d", r.Intn(20))
+				if balance := expected[address] + balances[address]; balance > 0 && r.Intn(2) == 0 {
+					balances[address] -= r.Intn(balance) + 1
+				} else {
+					balances[address] += r.Intn(10) + 1
+				}
+			}
+			deltas := make(map[string]types.Amount)
+			for address, delta := range balances {
+				expected[address] += delta
+				deltas[address] = types.NewAmount(int64(delta))
+			}
+			db.ApplyBlock(&types.BlockUpdate{Height: height, Balances: map[string]map[string]types.Amount{"c1": deltas}})
+			if height%7 == 0 {
+				db.GetHolders("c1", 0, 1) // Cache the index midway.
+			}
+		}
+		assert.Nil(t, db.RevertBlocks(10))
+
+		holders, total, _ := db.GetHolders("c1", 0, 100)
+		count := 0
+		for _, holder := range holders {
+			balances, _ := db.GetBalancesByAddress(holder.Address)
+			assert.Equal(t, balances["c1"], holder.Balance)
+			if holder.Balance.Cmp(types.NewAmount(5)) >= 0 {
+				count++
+			}
+		}
+		ci, _ := db.GetCoinInfoById("c1")
+		assert.Equal(t, ci.HolderCount, total)
+		assert.Equal(t, total, len(holders))
+		above, _ := db.CountHoldersAbove("c1", types.NewAmount(5))
+		assert.Equal(t, count, above)
+		assert.True(t, sort.SliceIsSorted(holders, func(i, j int) bool { return holders[i].Balance.Cmp(holders[j].Balance) > 0 }))
+	})
+}
+
+// Both stores share the data schema and the journal, so either can take over the database of the other.
+func TestDatabaseSwitchStores(t *testing.T) {
+	path := t.TempDir()
+	mem := NewMemDb(path, "testnet", 100, false, zap.NewNop())
+	applyTestBlocks(mem)
+	mem.Close()
+
+	disk := NewDiskDb(path, "testnet", 100, 1000, zap.NewNop())
+	utxos, _ := disk.GetCoinsByAddress("a2")
+	assert.Equal(t, 1, len(utxos))
+	assert.Nil(t, disk.RevertBlocks(1))
+	assert.Nil(t, disk.ApplyBlock(&types.BlockUpdate{
+		Height:   2,
+		Hash:     "h2b",
+		Balances: map[string]map[string]types.Amount{"c1": {"a3": types.NewAmount(1)}},
+		CoinTxs:  []*types.CoinTx{{Txid: "t4", Height: 2, CoinId: "c1", Type: "mint"}},
+	}))
+	disk.Close()
+
+	mem = NewMemDb(path, "testnet", 100, false, zap.NewNop())
+	defer mem.Close()
+	assert.Equal(t, map[string]types.Amount{"a1": types.NewAmount(2), "a3": types.NewAmount(1)}, mem.coinAddressBalance["c1"])
+	assert.Equal(t, 2, mem.coins["c1"].HolderCount)
+	assert.Equal(t, 3, len(mem.coinTxs["c1"]))
+	assert.Equal(t, "t4", mem.coinTxs["c1"][2].Txid)
+	assert.Equal(t, 0, len(mem.spentCoins))
+	assert.Nil(t, mem.RevertBlocks(2))
+	assert.Equal(t, 0, len(mem.coins))
+}
+
+func TestLruCache(t *testing.T) {
+	cache := newLruCache(2)
+	cache.add("k1", 1)
+	cache.add("k2", 2)
+	cache.get("k1")
+	cache.add("k3", 3)
+
+	_, ok := cache.get("k2")
+	assert.False(t, ok)
+	v, ok := cache.get("k1")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	cache.remove("k1")
+	_, ok = cache.get("k1")
+	assert.False(t, ok)
+	v, _ = cache.get("k3")
+	assert.Equal(t, 3, v)
+}
@@ -0,0 +1,892 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/dgraph-io/badger"
+	"go.uber.org/zap"
+)
+
+// DiskDb serves reads from BadgerDB, keeping only recently used values in an LRU cache, for states which don't fit in
+// memory. It shares the data schema and the journal of MemDb, so either store can open the database of the other.
+type DiskDb struct {
+	mutex        sync.RWMutex
+	network      string
+	height       int
+	hashes       map[int]string // Hashes of the blocks in the journal.
+	journalDepth int
+	cache        *lruCache // Decoded values by key, and derived holder indexes.
+	persistDb    *BadgerDB
+	logger       *zap.Logger
+}
+
+var _ Database = (*DiskDb)(nil)
+
+// decoder decodes the value of a key into its type.
+type decoder func(bs []byte) (interface{}, error)
+
+// loader returns the decoded value of a key, nil if the key doesn't exist.
+type loader func(key string, decode decoder) (interface{}, error)
+
+// NewDiskDb opens the store at persistPath, caching up to cacheSize values and keeping undo journals for the latest
+// journalDepth blocks.
+func NewDiskDb(persistPath string, network string, journalDepth int, cacheSize int, logger *zap.Logger) *DiskDb {
+	db := &DiskDb{
+		network:      network,
+		hashes:       make(map[int]string),
+		journalDepth: journalDepth,
+		cache:        newLruCache(cacheSize),
+		persistDb:    NewBadgerDB(persistPath),
+		logger:       logger,
+	}
+	migrate(db.persistDb, logger)
+
+	v, err := db.persistDb.Get(STATUS_KEY)
+	if err == nil && v != nil {
+		if db.height, db.network, err = decodeStatus(v); err != nil {
+			panic(fmt.Sprintf("failed to decode height from disk: %v", err))
+		}
+	}
+	db.loadJournal()
+	return db
+}
+
+func (d *DiskDb) Close() error {
+	return d.persistDb.Close()
+}
+
+// loadJournal reads the block hashes of the journal, pruning the entries out of its depth.
+func (d *DiskDb) loadJournal() {
+	_, values, err := d.persistDb.Query(JOURNAL_PREFIX)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load journal from disk: %v", err))
+	}
+
+	var staleKeys []string
+	var staleValues [][]byte
+	for i := range values {
+		entry := newJournalEntry()
+		if err := entry.fromBytes(values[i]); err != nil {
+			panic(fmt.Sprintf("failed to decode journal from disk: %v", err))
+		}
+		if entry.Height <= d.height-d.journalDepth || entry.Height > d.height {
+			staleKeys = append(staleKeys, journalKey(entry.Height))
+			staleValues = append(staleValues, nil)
+			continue
+		}
+		d.hashes[entry.Height] = entry.Hash
+	}
+
+	if len(staleKeys) > 0 {
+		if err := d.persistDb.BatchSet(staleKeys, staleValues); err != nil {
+			panic(fmt.Sprintf("failed to prune journal on disk: %v", err))
+		}
+	}
+}
+
+// load reads through the cache. Missing keys aren't cached, most lookups of UTXOs miss and would evict hot values.
+func (d *DiskDb) load(key string, decode decoder) (interface{}, error) {
+	if v, ok := d.cache.get(key); ok {
+		return v, nil
+	}
+
+	bs, err := d.persistDb.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	v, err := decode(bs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", key, err)
+	}
+	d.cache.add(key, v)
+	return v, nil
+}
+
+func decodeCoinInfo(bs []byte) (interface{}, error) {
+	ci := &types.CoinInfo{}
+	return ci, ci.FromBytes(bs)
+}
+
+func decodeUtxoCoins(bs []byte) (interface{}, error) {
+	var coins types.UtxoCoins
+	return coins, coins.FromBytes(bs)
+}
+
+func getCoinInfo(load loader, id string) (*types.CoinInfo, error) {
+	v, err := load(COINS_PREFIX+id, decodeCoinInfo)
+	if v == nil {
+		return nil, err
+	}
+	return v.(*types.CoinInfo), nil
+}
+
+func getUtxoCoins(load loader, utxo string) (types.UtxoCoins, error) {
+	v, err := load(UTXOS_PREFIX+utxo, decodeUtxoCoins)
+	if v == nil {
+		return nil, err
+	}
+	return v.(types.UtxoCoins), nil
+}
+
+func decodeBalanceValue(bs []byte) (interface{}, error) {
+	return decodeBalance(bs)
+}
+
+// getBalance returns the balance of a CAB_PREFIX or ACB_PREFIX key, 0 if none.
+func getBalance(load loader, key string) (types.Amount, error) {
+	v, err := load(key, decodeBalanceValue)
+	if v == nil {
+		return types.Amount{}, err
+	}
+	return v.(types.Amount), nil
+}
+
+// queryBalances returns the balances keyed by prefix/{key}, nil if none.
+func (d *DiskDb) queryBalances(prefix string) (map[string]types.Amount, error) {
+	keys, values, err := d.persistDb.Query(prefix)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	balances := make(map[string]types.Amount)
+	for i := range values {
+		if balances[keys[i][len(prefix):]], err = decodeBalance(values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return balances, nil
+}
+
+func (d *DiskDb) GetStatus() (int, string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.height, d.network, nil
+}
+
+func (d *DiskDb) GetCoinInfos() ([]*types.CoinInfo, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	_, values, err := d.persistDb.Query(COINS_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	var results []*types.CoinInfo
+	for i := range values {
+		ci := &types.CoinInfo{}
+		if err := ci.FromBytes(values[i]); err != nil {
+			return nil, err
+		}
+		results = append(results, ci)
+	}
+	return results, nil
+}
+
+func (d *DiskDb) GetCoinInfoById(id string) (*types.CoinInfo, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return getCoinInfo(d.load, id)
+}
+
+func (d *DiskDb) GetCoinsInUtxos(utxos []string) ([]*types.UnspentCoin, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var results []*types.UnspentCoin
+	for _, utxo := range utxos {
+		coins, err := getUtxoCoins(d.load, utxo)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, coins...)
+	}
+	return results, nil
+}
+
+// GetSpentCoins returns the coins of the UTXOs which were spent, skipping the others.
+func (d *DiskDb) GetSpentCoins(utxos []string) ([]*types.SpentCoin, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var results []*types.SpentCoin
+	for _, utxo := range utxos {
+		bs, err := d.persistDb.Get(SPENT_PREFIX + utxo)
+		if err == badger.ErrKeyNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var coins types.SpentCoins
+		if err := coins.FromBytes(bs); err != nil {
+			return nil, err
+		}
+		results = append(results, coins...)
+	}
+	return results, nil
+}
+
+func (d *DiskDb) GetBalancesByAddress(address string) (map[string]types.Amount, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.queryBalances(ACB_PREFIX + address + "/")
+}
+
+func (d *DiskDb) GetCoinsByAddress(address string) ([]*types.UnspentCoin, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	_, values, err := d.persistDb.Query(AUC_PREFIX + address + "/")
+	if err != nil {
+		return nil, err
+	}
+	var results []*types.UnspentCoin
+	for i := range values {
+		var coins types.UtxoCoins
+		if err := coins.FromBytes(values[i]); err != nil {
+			return nil, err
+		}
+		results = append(results, coins...)
+	}
+	return results, nil
+}
+
+// GetHistoryByAddress returns up to limit entries from the offset of the history of the address in the height range,
+// newest first, and the number of entries in the range. An empty coin matches entries of any coin.
+func (d *DiskDb) GetHistoryByAddress(address string, coin string, fromHeight int, toHeight int, offset int, limit int) ([]*types.HistoryEntry, int, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if toHeight < fromHeight {
+		return nil, 0, nil
+	}
+	// Keys are sorted by height and index, heights are zero padded to 10 digits.
+	seek := ""
+	if toHeight < 1e10-1 {
+		seek = historyKey(&types.HistoryEntry{Address: address, Height: toHeight + 1})
+	}
+	var results []*types.HistoryEntry
+	total := 0
+	err := d.persistDb.IterateReverse(HISTORY_PREFIX+address+"/", seek, func(key string, value []byte) (bool, error) {
+		entry := &types.HistoryEntry{}
+		if err := entry.FromBytes(value); err != nil {
+			return false, err
+		}
+		if entry.Height < fromHeight {
+			return false, nil
+		}
+		if len(coin) > 0 && entry.CoinId != coin {
+			return true, nil
+		}
+		if total >= offset && len(results) < limit {
+			results = append(results, entry)
+		}
+		total++
+		return true, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return results, total, nil
+}
+
+// GetCoinTxs returns up to limit transactions of the coin with a sequence before the given one, newest first. An empty
+// type matches transactions of any type.
+func (d *DiskDb) GetCoinTxs(id string, before int, limit int, txType string) ([]*types.CoinTx, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if before <= 0 || limit <= 0 {
+		return nil, nil
+	}
+	// Sequences are zero padded to 10 digits, a larger one lists from the latest transaction.
+	seek := ""
+	if before < 1e10 {
+		seek = coinTxKey(&types.CoinTx{CoinId: id, Seq: before})
+	}
+	var results []*types.CoinTx
+	err := d.persistDb.IterateReverse(COIN_TX_PREFIX+id+"/", seek, func(key string, value []byte) (bool, error) {
+		tx := &types.CoinTx{}
+		if err := tx.FromBytes(value); err != nil {
+			return false, err
+		}
+		if len(txType) == 0 || tx.Type == txType {
+			results = append(results, tx)
+		}
+		return len(results) < limit, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetCoinTxsByTxid returns the coin transactions recorded for the txid, one per coin it touched.
+func (d *DiskDb) GetCoinTxsByTxid(txid string) ([]*types.CoinTx, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.queryCoinTxs(TXID_PREFIX + txid + "/")
+}
+
+func (d *DiskDb) queryCoinTxs(prefix string) ([]*types.CoinTx, error) {
+	_, values, err := d.persistDb.Query(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var results []*types.CoinTx
+	for i := range values {
+		tx := &types.CoinTx{}
+		if err := tx.FromBytes(values[i]); err != nil {
+			return nil, err
+		}
+		results = append(results, tx)
+	}
+	return results, nil
+}
+
+// GetInvalidOperations returns the rejected operations of the txid, none if nothing was rejected.
+func (d *DiskDb) GetInvalidOperations(txid string) ([]*types.InvalidOperation, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	_, values, err := d.persistDb.Query(INVALID_PREFIX + txid + "/")
+	if err != nil {
+		return nil, err
+	}
+	var results []*types.InvalidOperation
+	for i := range values {
+		op := &types.InvalidOperation{}
+		if err := op.FromBytes(values[i]); err != nil {
+			return nil, err
+		}
+		results = append(results, op)
+	}
+	return results, nil
+}
+
+func holdersCacheKey(id string) string {
+	// Not a key of the disk schema, so it never collides with cached values.
+	return "\x00holders/" + id
+}
+
+// holderIndex returns the holder index of the coin, built from its balances when not cached. It's nil if the coin
+// has no holders.
+func (d *DiskDb) holderIndex(id string) (*holderIndex, error) {
+	if v, ok := d.cache.get(holdersCacheKey(id)); ok {
+		return v.(*holderIndex), nil
+	}
+
+	balances, err := d.queryBalances(CAB_PREFIX + id + "/")
+	if err != nil || balances == nil {
+		return nil, err
+	}
+	index := newHolderIndex(balances)
+	d.cache.add(holdersCacheKey(id), index)
+	return index, nil
+}
+
+// GetHolders returns the holders of the coin from the offset, largest balance first, and the total number of holders.
+func (d *DiskDb) GetHolders(id string, offset int, limit int) ([]*types.Holder, int, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	index, err := d.holderIndex(id)
+	if err != nil || index == nil {
+		return nil, 0, err
+	}
+	results, total := index.page(offset, limit)
+	return results, total, nil
+}
+
+// CountHoldersAbove returns the number of holders of the coin with a balance of at least the threshold.
+func (d *DiskDb) CountHoldersAbove(id string, threshold types.Amount) (int, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	index, err := d.holderIndex(id)
+	if err != nil || index == nil {
+		return 0, err
+	}
+	return index.countAbove(threshold), nil
+}
+
+func (d *DiskDb) GetBlockHash(height int) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.hashes[height], nil
+}
+
+// diskBatch collects the writes of a block, or of reverted blocks, so that they are committed in a single transaction.
+// Values read from the cache are shared with readers, so they are copied before being modified.
+type diskBatch struct {
+	db       *DiskDb
+	dirty    map[string]interface{}             // Decoded values to write, nil deletes the key.
+	keys     []string                           // Encoded values to write, for keys which are never read back by the batch.
+	values   [][]byte                           //
+	seqs     map[string]int                     // Next sequence of the transactions of each coin.
+	balances map[string]map[string]types.Amount // coinId -> address -> balance before the batch, to update holder indexes.
+	pending  *journalEntry
+}
+
+func (d *DiskDb) newBatch() *diskBatch {
+	return &diskBatch{
+		db:       d,
+		dirty:    make(map[string]interface{}),
+		seqs:     make(map[string]int),
+		balances: make(map[string]map[string]types.Amount),
+		pending:  newJournalEntry(),
+	}
+}
+
+func (b *diskBatch) load(key string, decode decoder) (interface{}, error) {
+	if v, ok := b.dirty[key]; ok {
+		return v, nil
+	}
+	return b.db.load(key, decode)
+}
+
+func (b *diskBatch) set(key string, value []byte) {
+	b.keys = append(b.keys, key)
+	b.values = append(b.values, value)
+}
+
+// setBalance updates the balance of the address in the coin, a zero balance removes the holder. It returns the
+// previous balance.
+func (b *diskBatch) setBalance(coin string, address string, balance types.Amount) (types.Amount, error) {
+	prev, err := getBalance(b.load, cabKey(coin, address))
+	if err != nil {
+		return types.Amount{}, err
+	}
+
+	if _, ok := b.balances[coin]; !ok {
+		b.balances[coin] = make(map[string]types.Amount)
+	}
+	if _, ok := b.balances[coin][address]; !ok {
+		b.balances[coin][address] = prev
+	}
+
+	b.dirty[cabKey(coin, address)] = balance
+	b.set(acbKey(address, coin), encodeBalance(balance))
+	return prev, nil
+}
+
+// deleteBalances removes all the holders of the coin.
+func (b *diskBatch) deleteBalances(coin string) error {
+	keys, _, err := b.db.persistDb.Query(CAB_PREFIX + coin + "/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		b.dirty[key] = nil
+	}
+	for key := range b.dirty {
+		if strings.HasPrefix(key, CAB_PREFIX+coin+"/") {
+			b.dirty[key] = nil
+		}
+	}
+	return nil
+}
+
+// seq returns the sequence of the next transaction of the coin.
+func (b *diskBatch) seq(coin string) (int, error) {
+	if seq, ok := b.seqs[coin]; ok {
+		return seq, nil
+	}
+
+	seq := 0
+	key, _, err := b.db.persistDb.Last(COIN_TX_PREFIX + coin + "/")
+	if err != nil {
+		return 0, err
+	}
+	if len(key) > 0 {
+		last, err := strconv.Atoi(key[len(COIN_TX_PREFIX+coin+"/"):])
+		if err != nil {
+			return 0, fmt.Errorf("invalid coin tx key %s: %v", key, err)
+		}
+		seq = last + 1
+	}
+	b.seqs[coin] = seq
+	return seq, nil
+}
+
+// The record* functions keep the first value seen for each key, which is the value before the block.
+
+func (b *diskBatch) recordCoin(id string) error {
+	if b.pending.hasCoin(id) {
+		return nil
+	}
+
+	ci, err := getCoinInfo(b.load, id)
+	if err != nil {
+		return err
+	}
+	if ci != nil {
+		prev := *ci
+		b.pending.Coins[id] = &prev
+	} else {
+		b.pending.NewCoins = append(b.pending.NewCoins, id)
+	}
+	return nil
+}
+
+func (b *diskBatch) recordBalance(coin string, address string, balance types.Amount) {
+	if _, ok := b.pending.Balances[coin]; !ok {
+		b.pending.Balances[coin] = make(map[string]types.Amount)
+	}
+	if _, ok := b.pending.Balances[coin][address]; !ok {
+		b.pending.Balances[coin][address] = balance
+	}
+}
+
+func (b *diskBatch) recordUtxo(utxo string, coins types.UtxoCoins) {
+	if b.pending.hasUtxo(utxo) {
+		return
+	}
+
+	if coins != nil {
+		b.pending.Utxos[utxo] = coins
+	} else {
+		b.pending.NewUtxos = append(b.pending.NewUtxos, utxo)
+	}
+}
+
+// commit writes the batch, then updates the cache.
+func (b *diskBatch) commit() error {
+	keys, values := b.keys, b.values
+	for key, v := range b.dirty {
+		var bs []byte
+		switch value := v.(type) {
+		case *types.CoinInfo:
+			bs = value.ToBytes()
+		case types.UtxoCoins:
+			bs = encodeUtxoCoins(value)
+		case types.Amount:
+			bs = encodeBalance(value)
+		}
+		keys = append(keys, key)
+		values = append(values, bs)
+	}
+
+	if err := b.db.persistDb.AtomicBatchSet(keys, values); err != nil {
+		return err
+	}
+	if err := b.db.persistDb.Sync(); err != nil {
+		return err
+	}
+
+	for i, key := range keys[len(b.keys):] {
+		if values[len(b.keys)+i] == nil {
+			b.db.cache.remove(key)
+		} else {
+			b.db.cache.add(key, b.dirty[key])
+		}
+	}
+
+	// Holder indexes are updated in place, readers are excluded by the mutex.
+	for coin, balances := range b.balances {
+		v, ok := b.db.cache.get(holdersCacheKey(coin))
+		if !ok {
+			continue
+		}
+		if ci, ok := b.dirty[COINS_PREFIX+coin]; ok && ci == nil {
+			b.db.cache.remove(holdersCacheKey(coin))
+			continue
+		}
+		for address, balance := range balances {
+			current, _ := b.dirty[cabKey(coin, address)].(types.Amount)
+			v.(*holderIndex).update(address, balance, current)
+		}
+	}
+	return nil
+}
+
+// ApplyBlock applies all the state changes of a block, together with its undo journal and the indexed height, in a
+// single transaction. Unlike MemDb, the state stays consistent if it fails.
+func (d *DiskDb) ApplyBlock(update *types.BlockUpdate) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	b := d.newBatch()
+	if err := b.applyCoinInfos(update.CoinInfos); err != nil {
+		return err
+	}
+	if err := b.applyBalances(update.Balances); err != nil {
+		return err
+	}
+	if err := b.applyUtxos(update.Utxos); err != nil {
+		return err
+	}
+	for _, entry := range update.History {
+		b.pending.History = appendUnique(b.pending.History, entry.Address)
+		b.set(historyKey(entry), entry.ToBytes())
+	}
+	for _, tx := range update.CoinTxs {
+		b.pending.CoinTxs = appendUnique(b.pending.CoinTxs, tx.CoinId)
+		seq, err := b.seq(tx.CoinId)
+		if err != nil {
+			return err
+		}
+		tx.Seq = seq
+		b.seqs[tx.CoinId] = seq + 1
+		b.set(coinTxKey(tx), tx.ToBytes())
+		b.set(txidKey(tx), tx.ToBytes())
+	}
+	for utxo, coins := range update.Spends {
+		b.pending.Spends = append(b.pending.Spends, utxo)
+		b.set(SPENT_PREFIX+utxo, coins.ToBytes())
+	}
+	rejected := make(map[string]int) // Operations of each txid rejected.
+	for _, op := range update.InvalidOps {
+		if rejected[op.Txid] == 0 {
+			b.pending.InvalidOps = append(b.pending.InvalidOps, op.Txid)
+		}
+		b.set(invalidKey(op.Txid, rejected[op.Txid]), op.ToBytes())
+		rejected[op.Txid]++
+	}
+
+	entry := b.pending
+	entry.Height = update.Height
+	entry.Hash = update.Hash
+	bs, err := entry.toBytes()
+	if err != nil {
+		return err
+	}
+	b.set(journalKey(update.Height), bs)
+	_, prune := d.hashes[update.Height-d.journalDepth]
+	if prune {
+		b.set(journalKey(update.Height-d.journalDepth), nil)
+	}
+	bs, err = encodeStatus(update.Height, d.network)
+	if err != nil {
+		return err
+	}
+	b.set(STATUS_KEY, bs)
+
+	if err := b.commit(); err != nil {
+		return err
+	}
+	d.height = update.Height
+	d.hashes[update.Height] = update.Hash
+	if prune {
+		delete(d.hashes, update.Height-d.journalDepth)
+	}
+	return nil
+}
+
+func (b *diskBatch) applyCoinInfos(updates map[string]*types.CoinInfo) error {
+	for id, ci := range updates {
+		if err := b.recordCoin(id); err != nil {
+			return err
+		}
+		b.dirty[COINS_PREFIX+id] = ci
+	}
+	return nil
+}
+
+func (b *diskBatch) applyBalances(coinAddressBalances map[string]map[string]types.Amount) error {
+	for coin, balances := range coinAddressBalances {
+		if err := b.recordCoin(coin); err != nil { // Holder count changes.
+			return err
+		}
+
+		// Holders are counted from the coin info before the block, the update may carry any count.
+		holderCount := 0
+		if prev, ok := b.pending.Coins[coin]; ok {
+			holderCount = prev.HolderCount
+		}
+		for address, delta := range balances {
+			balance, err := getBalance(b.load, cabKey(coin, address))
+			if err != nil {
+				return err
+			}
+			updated, err := balance.Add(delta)
+			if err != nil {
+				return fmt.Errorf("balance of %s in %s: %v", address, coin, err)
+			}
+			b.recordBalance(coin, address, balance)
+			if _, err := b.setBalance(coin, address, updated); err != nil {
+				return err
+			}
+			if balance.IsZero() && !updated.IsZero() {
+				holderCount++
+			} else if !balance.IsZero() && updated.IsZero() {
+				holderCount--
+			}
+		}
+
+		// Update coin info, on a copy as it may be cached.
+		ci, err := getCoinInfo(b.load, coin)
+		if err != nil {
+			return err
+		}
+		if ci == nil {
+			panic("unexpected error: coin info didn't exist")
+		}
+		updated := *ci
+		updated.HolderCount = holderCount
+		b.dirty[COINS_PREFIX+coin] = &updated
+	}
+	return nil
+}
+
+func (b *diskBatch) applyUtxos(updates map[string]map[string]*types.UnspentCoin) error {
+	for utxo, changes := range updates {
+		old, err := getUtxoCoins(b.load, utxo)
+		if err != nil {
+			return err
+		}
+		coins := old.Update(changes)
+		if old == nil && coins == nil { // Never stored, nothing to delete.
+			continue
+		}
+		b.recordUtxo(utxo, old)
+		b.setUtxo(utxo, old, coins)
+	}
+	return nil
+}
+
+// setUtxo replaces the old coins of the UTXO, nil deletes it.
+func (b *diskBatch) setUtxo(utxo string, old types.UtxoCoins, coins types.UtxoCoins) {
+	b.dirty[UTXOS_PREFIX+utxo] = coins
+	owners := old.Owners()
+	for _, owner := range coins.Owners() {
+		owners = appendUnique(owners, owner)
+	}
+	for _, owner := range owners {
+		b.set(aucKey(owner, utxo), encodeUtxoCoins(coins.Owned(owner)))
+	}
+}
+
+func (d *DiskDb) RevertBlocks(n int) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	b := d.newBatch()
+	height := d.height
+	for i := 0; i < n; i++ {
+		if _, ok := d.hashes[height]; !ok {
+			return fmt.Errorf("no journal for block %d, can't revert deeper than %d blocks", height, d.journalDepth)
+		}
+		bs, err := d.persistDb.Get(journalKey(height))
+		if err != nil {
+			return fmt.Errorf("failed to read journal of block %d: %v", height, err)
+		}
+		entry := newJournalEntry()
+		if err := entry.fromBytes(bs); err != nil {
+			return err
+		}
+		if err := b.revert(entry); err != nil {
+			return err
+		}
+		b.set(journalKey(height), nil)
+		height = entry.Height - 1
+	}
+
+	bs, err := encodeStatus(height, d.network)
+	if err != nil {
+		return err
+	}
+	b.set(STATUS_KEY, bs)
+	if err := b.commit(); err != nil {
+		return err
+	}
+	for ; d.height > height; d.height-- {
+		delete(d.hashes, d.height)
+	}
+	return nil
+}
+
+// revert restores the values the block overwrote, and deletes the records it added.
+func (b *diskBatch) revert(entry *journalEntry) error {
+	for coin, balances := range entry.Balances {
+		for address, balance := range balances {
+			if _, err := b.setBalance(coin, address, balance); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, utxo := range entry.NewUtxos {
+		old, err := getUtxoCoins(b.load, utxo)
+		if err != nil {
+			return err
+		}
+		if old != nil {
+			b.setUtxo(utxo, old, nil)
+		}
+	}
+	for utxo, coins := range entry.Utxos {
+		old, err := getUtxoCoins(b.load, utxo)
+		if err != nil {
+			return err
+		}
+		b.setUtxo(utxo, old, coins)
+	}
+
+	for _, address := range entry.History {
+		keys, _, err := b.db.persistDb.Query(fmt.Sprintf("%s%s/%010d/", HISTORY_PREFIX, address, entry.Height))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			b.set(key, nil)
+		}
+	}
+
+	// Transactions of the block are the latest ones of each coin.
+	for _, coin := range entry.CoinTxs {
+		seq, err := b.seq(coin)
+		if err != nil {
+			return err
+		}
+		for ; seq > 0; seq-- {
+			v, err := b.db.persistDb.Get(coinTxKey(&types.CoinTx{CoinId: coin, Seq: seq - 1}))
+			if err != nil {
+				return err
+			}
+			tx := &types.CoinTx{}
+			if err := tx.FromBytes(v); err != nil {
+				return err
+			}
+			if tx.Height != entry.Height {
+				break
+			}
+			b.set(coinTxKey(tx), nil)
+			b.set(txidKey(tx), nil)
+		}
+		b.seqs[coin] = seq
+	}
+
+	for _, utxo := range entry.Spends {
+		b.set(SPENT_PREFIX+utxo, nil)
+	}
+	for _, txid := range entry.InvalidOps {
+		keys, _, err := b.db.persistDb.Query(INVALID_PREFIX + txid + "/")
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			b.set(key, nil)
+		}
+	}
+
+	for _, coin := range entry.NewCoins {
+		b.dirty[COINS_PREFIX+coin] = nil
+		if err := b.deleteBalances(coin); err != nil {
+			return err
+		}
+	}
+	for coin, ci := range entry.Coins {
+		b.dirty[COINS_PREFIX+coin] = ci
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"sort"
+
+	"github.com/decentralize-everything/indexer/types"
+)
+
+// holderIndex keeps the holders of a coin sorted by balance, largest first, then by address. An update shifts part of
+// the slice, which is cheap for the holder counts of a coin, and reads need no sorting.
+type holderIndex struct {
+	holders []*types.Holder
+}
+
+// search returns the position of the holder, or where it would be inserted.
+func (h *holderIndex) search(address string, balance types.Amount) int {
+	return sort.Search(len(h.holders), func(i int) bool {
+		if c := h.holders[i].Balance.Cmp(balance); c != 0 {
+			return c < 0
+		}
+		return h.holders[i].Address >= address
+	})
+}
+
+// update moves the holder from the old balance to the new one, a zero balance means not a holder.
+func (h *holderIndex) update(address string, old types.Amount, new types.Amount) {
+	if old == new {
+		return
+	}
+	if !old.IsZero() {
+		if i := h.search(address, old); i < len(h.holders) && h.holders[i].Address == address {
+			h.holders = append(h.holders[:i], h.holders[i+1:]...)
+		}
+	}
+	if !new.IsZero() {
+		i := h.search(address, new)
+		h.holders = append(h.holders, nil)
+		copy(h.holders[i+1:], h.holders[i:])
+		h.holders[i] = &types.Holder{Address: address, Balance: new}
+	}
+}
+
+// countAbove returns the number of holders with a balance of at least the threshold.
+func (h *holderIndex) countAbove(threshold types.Amount) int {
+	return sort.Search(len(h.holders), func(i int) bool {
+		return h.holders[i].Balance.Cmp(threshold) < 0
+	})
+}
+
+// updateHolder must be called with the mutex held, before the balance changes.
+func (m *MemDb) updateHolder(coin string, address string, balance types.Amount) {
+	if _, ok := m.coinHolders[coin]; !ok {
+		m.coinHolders[coin] = &holderIndex{}
+	}
+	m.coinHolders[coin].update(address, m.coinAddressBalance[coin][address], balance)
+}
+
+// newHolderIndex indexes the holders of the balances, by address.
+func newHolderIndex(balances map[string]types.Amount) *holderIndex {
+	index := &holderIndex{}
+	for address, balance := range balances {
+		index.holders = append(index.holders, &types.Holder{Address: address, Balance: balance})
+	}
+	sort.Slice(index.holders, func(i, j int) bool {
+		if c := index.holders[i].Balance.Cmp(index.holders[j].Balance); c != 0 {
+			return c > 0
+		}
+		return index.holders[i].Address < index.holders[j].Address
+	})
+	return index
+}
+
+// page returns copies of the holders from the offset, and the total number of holders.
+func (h *holderIndex) page(offset int, limit int) ([]*types.Holder, int) {
+	if offset >= len(h.holders) {
+		return nil, len(h.holders)
+	}
+	end := min(offset+limit, len(h.holders))
+	results := make([]*types.Holder, 0, end-offset)
+	for _, holder := range h.holders[offset:end] {
+		copied := *holder
+		results = append(results, &copied)
+	}
+	return results, len(h.holders)
+}
+
+// rebuildHolders indexes the holders of all coins from scratch, e.g. after loading from disk.
+func (m *MemDb) rebuildHolders() {
+	m.coinHolders = make(map[string]*holderIndex)
+	for coin, balances := range m.coinAddressBalance {
+		m.coinHolders[coin] = newHolderIndex(balances)
+	}
+}
+
+// GetHolders returns the holders of the coin from the offset, largest balance first, and the total number of holders.
+func (m *MemDb) GetHolders(id string, offset int, limit int) ([]*types.Holder, int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	index, ok := m.coinHolders[id]
+	if !ok {
+		return nil, 0, nil
+	}
+	results, total := index.page(offset, limit)
+	return results, total, nil
+}
+
+// CountHoldersAbove returns the number of holders of the coin with a balance of at least the threshold.
+func (m *MemDb) CountHoldersAbove(id string, threshold types.Amount) (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if index, ok := m.coinHolders[id]; ok {
+		return index.countAbove(threshold), nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,26 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHolderIndex(t *testing.T) {
+	index := &holderIndex{}
+	index.update("a1", types.NewAmount(0), types.NewAmount(5))
+	index.update("a2", types.NewAmount(0), types.NewAmount(3))
+	index.update("a3", types.NewAmount(0), types.NewAmount(5))
+	index.update("a4", types.NewAmount(0), types.NewAmount(1))
+	assert.Equal(t, []*types.Holder{{Address: "a1", Balance: types.NewAmount(5)}, {Address: "a3", Balance: types.NewAmount(5)}, {Address: "a2", Balance: types.NewAmount(3)}, {Address: "a4", Balance: types.NewAmount(1)}}, index.holders)
+
+	index.update("a1", types.NewAmount(5), types.NewAmount(2))
+	index.update("a4", types.NewAmount(1), types.NewAmount(0))
+	index.update("a2", types.NewAmount(3), types.NewAmount(3))
+	assert.Equal(t, []*types.Holder{{Address: "a3", Balance: types.NewAmount(5)}, {Address: "a2", Balance: types.NewAmount(3)}, {Address: "a1", Balance: types.NewAmount(2)}}, index.holders)
+
+	assert.Equal(t, 0, index.countAbove(types.NewAmount(6)))
+	assert.Equal(t, 2, index.countAbove(types.NewAmount(3)))
+	assert.Equal(t, 3, index.countAbove(types.NewAmount(1)))
+}
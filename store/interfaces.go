@@ -9,10 +9,16 @@ type Database interface {
 	GetCoinInfos() ([]*types.CoinInfo, error)
 	GetCoinInfoById(id string) (*types.CoinInfo, error)
 	GetCoinsInUtxos(utxos []string) ([]*types.UnspentCoin, error)
-	GetBalancesByAddress(address string) (map[string]int, error)
+	GetSpentCoins(utxos []string) ([]*types.SpentCoin, error)
+	GetBalancesByAddress(address string) (map[string]types.Amount, error)
 	GetCoinsByAddress(address string) ([]*types.UnspentCoin, error)
-	CoinInfoBatchUpdate(updates map[string]*types.CoinInfo) error
-	BalanceBatchUpdate(coinAddressBalances map[string]map[string]int) error
-	UtxoBatchUpdate(updates map[string]*types.UnspentCoin) error
-	IndexedHeightUpdate(height int) error
+	GetHistoryByAddress(address string, coin string, fromHeight int, toHeight int, offset int, limit int) ([]*types.HistoryEntry, int, error)
+	GetCoinTxs(id string, before int, limit int, txType string) ([]*types.CoinTx, error)
+	GetCoinTxsByTxid(txid string) ([]*types.CoinTx, error)
+	GetInvalidOperations(txid string) ([]*types.InvalidOperation, error)
+	GetHolders(id string, offset int, limit int) ([]*types.Holder, int, error)
+	CountHoldersAbove(id string, threshold types.Amount) (int, error)
+	ApplyBlock(update *types.BlockUpdate) error
+	GetBlockHash(height int) (string, error)
+	RevertBlocks(n int) error
 }
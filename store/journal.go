@@ -0,0 +1,480 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/decentralize-everything/indexer/utils"
+)
+
+// journalEntry records the values a block overwrote, so that the block can be
+// reverted exactly. Keys which didn't exist before the block are listed
+// separately.
+type journalEntry struct {
+	Height     int
+	Hash       string
+	Coins      map[string]*types.CoinInfo
+	NewCoins   []string
+	Balances   map[string]map[string]types.Amount // coinId -> address -> previous balance, 0 if none.
+	Utxos      map[string]types.UtxoCoins
+	NewUtxos   []string
+	History    []string // Addresses with history entries of the block.
+	CoinTxs    []string // Coins with transactions of the block.
+	Spends     []string // UTXOs spent by the block.
+	InvalidOps []string // Transactions of the block rejected.
+}
+
+func newJournalEntry() *journalEntry {
+	return &journalEntry{
+		Coins:    make(map[string]*types.CoinInfo),
+		Balances: make(map[string]map[string]types.Amount),
+		Utxos:    make(map[string]types.UtxoCoins),
+	}
+}
+
+// toBytes encodes the entry as a JournalEntry of schema.proto.
+func (e *journalEntry) toBytes() ([]byte, error) {
+	w := &utils.RecordWriter{}
+	w.Int(1, e.Height)
+	w.String(2, e.Hash)
+	for id, ci := range e.Coins {
+		w.Message(3, keyValueBytes(id, ci.ToBytes()))
+	}
+	for _, id := range e.NewCoins {
+		w.String(4, id)
+	}
+	for coin, balances := range e.Balances {
+		for address, balance := range balances {
+			b := &utils.RecordWriter{}
+			b.String(1, coin)
+			b.String(2, address)
+			if !balance.IsZero() {
+				b.Message(4, balance.ToBytes())
+			}
+			w.Message(5, b.Bytes())
+		}
+	}
+	for utxo, coins := range e.Utxos {
+		u := &utils.RecordWriter{}
+		u.String(1, utxo)
+		for _, uc := range coins {
+			u.Message(3, uc.ToBytes())
+		}
+		w.Message(6, u.Bytes())
+	}
+	for i, list := range [][]string{e.NewUtxos, e.History, e.CoinTxs, e.Spends, e.InvalidOps} {
+		for _, s := range list {
+			w.String(7+i, s)
+		}
+	}
+	return w.Bytes(), nil
+}
+
+func keyValueBytes(key string, value []byte) []byte {
+	w := &utils.RecordWriter{}
+	w.String(1, key)
+	w.Message(2, value)
+	return w.Bytes()
+}
+
+func readKeyValue(bs []byte) (key string, value []byte, err error) {
+	err = utils.ReadRecord(bs, func(f utils.RecordField) error {
+		switch f.Num {
+		case 1:
+			key = f.String()
+		case 2:
+			value = f.Message()
+		}
+		return nil
+	})
+	return
+}
+
+func (e *journalEntry) fromBytes(bs []byte) error {
+	return utils.ReadRecord(bs, func(f utils.RecordField) error {
+		switch f.Num {
+		case 1:
+			e.Height = f.Int()
+		case 2:
+			e.Hash = f.String()
+		case 3:
+			id, value, err := readKeyValue(f.Message())
+			if err != nil {
+				return err
+			}
+			e.Coins[id] = &types.CoinInfo{}
+			return e.Coins[id].FromBytes(value)
+		case 4:
+			e.NewCoins = append(e.NewCoins, f.String())
+		case 5:
+			var coin, address string
+			var balance types.Amount
+			err := utils.ReadRecord(f.Message(), func(b utils.RecordField) error {
+				switch b.Num {
+				case 1:
+					coin = b.String()
+				case 2:
+					address = b.String()
+				case 3:
+					balance = types.NewAmount(int64(b.Int())) // Before amounts took 128 bits.
+				case 4:
+					return balance.FromBytes(b.Message())
+				}
+				return nil
+			})
+			if _, ok := e.Balances[coin]; !ok {
+				e.Balances[coin] = make(map[string]types.Amount)
+			}
+			e.Balances[coin][address] = balance
+			return err
+		case 6:
+			var utxo string
+			var coins types.UtxoCoins
+			err := utils.ReadRecord(f.Message(), func(u utils.RecordField) error {
+				switch u.Num {
+				case 1:
+					utxo = u.String()
+				case 2, 3: // A single coin before UTXOs held several.
+					uc := &types.UnspentCoin{}
+					coins = append(coins, uc)
+					return uc.FromBytes(u.Message())
+				}
+				return nil
+			})
+			e.Utxos[utxo] = coins
+			return err
+		case 7:
+			e.NewUtxos = append(e.NewUtxos, f.String())
+		case 8:
+			e.History = append(e.History, f.String())
+		case 9:
+			e.CoinTxs = append(e.CoinTxs, f.String())
+		case 10:
+			e.Spends = append(e.Spends, f.String())
+		case 11:
+			e.InvalidOps = append(e.InvalidOps, f.String())
+		}
+		return nil
+	})
+}
+
+func journalKey(height int) string {
+	return fmt.Sprintf("%s%010d", JOURNAL_PREFIX, height)
+}
+
+// hasCoin tells whether the value of the coin before the block is recorded.
+func (e *journalEntry) hasCoin(id string) bool {
+	if _, ok := e.Coins[id]; ok {
+		return true
+	}
+	for _, c := range e.NewCoins {
+		if c == id {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUtxo tells whether the value of the UTXO before the block is recorded.
+func (e *journalEntry) hasUtxo(utxo string) bool {
+	if _, ok := e.Utxos[utxo]; ok {
+		return true
+	}
+	for _, u := range e.NewUtxos {
+		if u == utxo {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// The record* functions keep the first value seen for each key, which is the
+// value before the block. They must be called with the mutex held.
+
+func (m *MemDb) recordCoin(id string) {
+	if m.pending.hasCoin(id) {
+		return
+	}
+
+	if ci, ok := m.coins[id]; ok {
+		prev := *ci
+		m.pending.Coins[id] = &prev
+	} else {
+		m.pending.NewCoins = append(m.pending.NewCoins, id)
+	}
+}
+
+func (m *MemDb) recordBalance(coin string, address string) {
+	if _, ok := m.pending.Balances[coin]; !ok {
+		m.pending.Balances[coin] = make(map[string]types.Amount)
+	}
+	if _, ok := m.pending.Balances[coin][address]; !ok {
+		m.pending.Balances[coin][address] = m.coinAddressBalance[coin][address]
+	}
+}
+
+func (m *MemDb) recordUtxo(utxo string) {
+	if m.pending.hasUtxo(utxo) {
+		return
+	}
+
+	if coins, ok := m.utxoCoin[utxo]; ok {
+		m.pending.Utxos[utxo] = coins
+	} else {
+		m.pending.NewUtxos = append(m.pending.NewUtxos, utxo)
+	}
+}
+
+func (m *MemDb) recordHistory(address string) {
+	m.pending.History = appendUnique(m.pending.History, address)
+}
+
+func (m *MemDb) recordCoinTx(id string) {
+	m.pending.CoinTxs = appendUnique(m.pending.CoinTxs, id)
+}
+
+// commitJournal closes the pending entry for the given block and prunes the
+// entry falling out of the journal depth. It returns the keys and values to be
+// persisted.
+func (m *MemDb) commitJournal(height int, hash string) ([]string, [][]byte, error) {
+	entry := m.pending
+	entry.Height = height
+	entry.Hash = hash
+	m.pending = newJournalEntry()
+	m.journal[height] = entry
+
+	var keys []string
+	var values [][]byte
+	if m.persistDb != nil {
+		bs, err := entry.toBytes()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, journalKey(height))
+		values = append(values, bs)
+	}
+
+	if _, ok := m.journal[height-m.journalDepth]; ok {
+		delete(m.journal, height-m.journalDepth)
+		if m.persistDb != nil {
+			keys = append(keys, journalKey(height-m.journalDepth))
+			values = append(values, nil)
+		}
+	}
+	return keys, values, nil
+}
+
+func (m *MemDb) GetBlockHash(height int) (string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if entry, ok := m.journal[height]; ok {
+		return entry.Hash, nil
+	}
+	return "", nil
+}
+
+func (m *MemDb) RevertBlocks(n int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Check all the blocks first, a partial revert would leave the memory inconsistent with the disk.
+	for height := m.height; height > m.height-n; height-- {
+		if _, ok := m.journal[height]; !ok {
+			return fmt.Errorf("no journal for block %d, can't revert deeper than %d blocks", height, m.journalDepth)
+		}
+	}
+
+	touchedCoins := make(map[string]bool)
+	touchedUtxos := make(map[string]bool)
+	touchedBalances := make(map[[2]string]bool) // coinId, address
+	touchedAucs := make(map[[2]string]bool)     // address, utxo
+	var keys []string
+	var values [][]byte
+	for i := 0; i < n; i++ {
+		entry := m.journal[m.height]
+
+		for coin, balances := range entry.Balances {
+			if _, ok := m.coinAddressBalance[coin]; !ok {
+				m.coinAddressBalance[coin] = make(map[string]types.Amount)
+			}
+			for address, balance := range balances {
+				m.updateHolder(coin, address, balance)
+				if balance.IsZero() {
+					delete(m.coinAddressBalance[coin], address)
+					m.deleteAddressBalance(address, coin)
+				} else {
+					if _, ok := m.addressCoinBalance[address]; !ok {
+						m.addressCoinBalance[address] = make(map[string]types.Amount)
+					}
+					m.coinAddressBalance[coin][address] = balance
+					m.addressCoinBalance[address][coin] = balance
+				}
+				touchedBalances[[2]string{coin, address}] = true
+			}
+			touchedCoins[coin] = true
+		}
+
+		for _, utxo := range entry.NewUtxos {
+			for _, owner := range m.setUtxo(utxo, nil) {
+				touchedAucs[[2]string{owner, utxo}] = true
+			}
+			touchedUtxos[utxo] = true
+		}
+		for utxo, coins := range entry.Utxos {
+			for _, owner := range m.setUtxo(utxo, coins) {
+				touchedAucs[[2]string{owner, utxo}] = true
+			}
+			touchedUtxos[utxo] = true
+		}
+
+		// History entries of the block are the latest ones.
+		for _, address := range entry.History {
+			history := m.addressHistory[address]
+			for len(history) > 0 && history[len(history)-1].Height == entry.Height {
+				if m.persistDb != nil {
+					keys = append(keys, historyKey(history[len(history)-1]))
+					values = append(values, nil)
+				}
+				history = history[:len(history)-1]
+			}
+			if len(history) == 0 {
+				delete(m.addressHistory, address)
+			} else {
+				m.addressHistory[address] = history
+			}
+		}
+
+		for _, coin := range entry.CoinTxs {
+			txs := m.coinTxs[coin]
+			for len(txs) > 0 && txs[len(txs)-1].Height == entry.Height {
+				if m.persistDb != nil {
+					keys = append(keys, coinTxKey(txs[len(txs)-1]), txidKey(txs[len(txs)-1]))
+					values = append(values, nil, nil)
+				}
+				delete(m.txidCoinTxs, txs[len(txs)-1].Txid)
+				txs = txs[:len(txs)-1]
+			}
+			if len(txs) == 0 {
+				delete(m.coinTxs, coin)
+			} else {
+				m.coinTxs[coin] = txs
+			}
+		}
+
+		for _, utxo := range entry.Spends {
+			delete(m.spentCoins, utxo)
+			if m.persistDb != nil {
+				keys = append(keys, SPENT_PREFIX+utxo)
+				values = append(values, nil)
+			}
+		}
+
+		for _, txid := range entry.InvalidOps {
+			if m.persistDb != nil {
+				for i := range m.invalidOps[txid] {
+					keys = append(keys, invalidKey(txid, i))
+					values = append(values, nil)
+				}
+			}
+			delete(m.invalidOps, txid)
+		}
+
+		for _, coin := range entry.NewCoins {
+			for address := range m.coinAddressBalance[coin] {
+				touchedBalances[[2]string{coin, address}] = true
+			}
+			delete(m.coins, coin)
+			delete(m.coinAddressBalance, coin)
+			delete(m.coinHolders, coin)
+			touchedCoins[coin] = true
+		}
+		for coin, ci := range entry.Coins {
+			m.coins[coin] = ci
+			touchedCoins[coin] = true
+		}
+
+		delete(m.journal, m.height)
+		if m.persistDb != nil {
+			keys = append(keys, journalKey(m.height))
+			values = append(values, nil)
+		}
+		m.height = entry.Height - 1
+	}
+
+	if m.persistDb == nil {
+		return nil
+	}
+
+	for coin := range touchedCoins {
+		keys = append(keys, COINS_PREFIX+coin)
+		if ci, ok := m.coins[coin]; ok {
+			values = append(values, ci.ToBytes())
+		} else {
+			values = append(values, nil)
+		}
+	}
+	for utxo := range touchedUtxos {
+		keys = append(keys, UTXOS_PREFIX+utxo)
+		values = append(values, encodeUtxoCoins(m.utxoCoin[utxo]))
+	}
+	for pair := range touchedBalances {
+		coin, address := pair[0], pair[1]
+		keys = append(keys, cabKey(coin, address), acbKey(address, coin))
+		values = append(values, encodeBalance(m.coinAddressBalance[coin][address]), encodeBalance(m.addressCoinBalance[address][coin]))
+	}
+	for pair := range touchedAucs {
+		address, utxo := pair[0], pair[1]
+		keys = append(keys, aucKey(address, utxo))
+		values = append(values, encodeUtxoCoins(m.addressUtxoCoin[address][utxo]))
+	}
+
+	bs, err := m.statusBytes()
+	if err != nil {
+		return err
+	}
+	keys = append(keys, STATUS_KEY)
+	values = append(values, bs)
+
+	if err := m.persistDb.AtomicBatchSet(keys, values); err != nil {
+		return err
+	}
+	return m.persistDb.Sync()
+}
+
+func (m *MemDb) loadJournal() {
+	_, values, err := m.persistDb.Query(JOURNAL_PREFIX)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load journal from disk: %v", err))
+	}
+
+	var staleKeys []string
+	var staleValues [][]byte
+	for i := range values {
+		entry := newJournalEntry()
+		if err := entry.fromBytes(values[i]); err != nil {
+			panic(fmt.Sprintf("failed to decode journal from disk: %v", err))
+		}
+		if entry.Height <= m.height-m.journalDepth || entry.Height > m.height {
+			staleKeys = append(staleKeys, journalKey(entry.Height))
+			staleValues = append(staleValues, nil)
+			continue
+		}
+		m.journal[entry.Height] = entry
+	}
+
+	if len(staleKeys) > 0 {
+		if err := m.persistDb.BatchSet(staleKeys, staleValues); err != nil {
+			panic(fmt.Sprintf("failed to prune journal on disk: %v", err))
+		}
+	}
+}
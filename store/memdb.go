@@ -1,13 +1,13 @@
 package store
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/decentralize-everything/indexer/types"
+	"github.com/decentralize-everything/indexer/utils"
 	"go.uber.org/zap"
 )
 
@@ -18,60 +18,102 @@ Data volume estimation:
 - 3 addressUtxoCoin < 100k addresses
 - 4 addressCoinBalance < 100k addresses
 - 5 coinAddressBalance < 1k coins
+- 6 addressHistory, one entry per address and coin of each transaction, ~200 bytes each
+- 7 coinTxs, one per coin of each transaction, ~150 bytes each, shared by txidCoinTxs
+- 8 spentCoins, one per spent UTXO which carried coins
+- 9 invalidOps, one per rejected operation
+Items 1-5 follow the live state, items 6-9 are append-only and grow with the chain, e.g. 10m transactions take several
+GB. Once they outgrow the memory, use DiskDb, which keeps them in the database file only.
 */
 type MemDb struct {
 	mutex              sync.RWMutex
 	network            string
 	height             int
 	coins              map[string]*types.CoinInfo
-	utxoCoin           map[string]*types.UnspentCoin
-	addressUtxoCoin    map[string]map[string]*types.UnspentCoin
-	addressCoinBalance map[string]map[string]int
-	coinAddressBalance map[string]map[string]int
+	utxoCoin           map[string]types.UtxoCoins
+	addressUtxoCoin    map[string]map[string]types.UtxoCoins // The coins of the address in each UTXO.
+	addressCoinBalance map[string]map[string]types.Amount
+	coinAddressBalance map[string]map[string]types.Amount
+	addressHistory     map[string][]*types.HistoryEntry // In the order applied.
+	coinTxs            map[string][]*types.CoinTx       // In the order applied.
+	txidCoinTxs        map[string][]*types.CoinTx       // Derived from coinTxs, not persisted.
+	coinHolders        map[string]*holderIndex          // Derived from coinAddressBalance, not persisted.
+	spentCoins         map[string]types.SpentCoins
+	invalidOps         map[string][]*types.InvalidOperation // In the order applied.
+	journal            map[int]*journalEntry
+	journalDepth       int
+	pending            *journalEntry
 
 	/*
 		Data schema:
-		- height: {"height" : {height}}
+		- height: {"status" : {status}}
 		- coins: {"coins/{coinId}" : {coinInfo}}
-		- utxoCoin: {"utxos/{utxo}" : {unspentCoin}}
-		- addressUtxoCoin: {"a-u-c/{address}" : {"{utxo}" : {unspentCoin}}}
-		- addressCoinBalance: {"a-c-b/{address}" : {"{coinId}" : {balance}}}
-		- coinAddressBalance: {"c-a-b/{coinId}" : {"{address}" : {balance}}}
+		- utxoCoin: {"utxos/{utxo}" : {utxoCoins}}
+		- version: {"version" : {version}}, see migrate.go
+		- addressUtxoCoin: {"a-u-c/{address}/{utxo}" : {utxoCoins}}, the coins of the address in the UTXO
+		- addressCoinBalance: {"a-c-b/{address}/{coinId}" : {balance}}, balance in decimal
+		- coinAddressBalance: {"c-a-b/{coinId}/{address}" : {balance}}, balance in decimal
+		- addressHistory: {"hist/{address}/{height}/{index}" : {historyEntry}}, height and index are zero padded to 10 and 6 digits
+		- coinTxs: {"c-tx/{coinId}/{seq}" : {coinTx}}, seq is zero padded to 10 digits
+		- spentCoins: {"spent/{utxo}" : {spentCoins}}
+		- invalidOps: {"invalid/{txid}/{index}" : {invalidOperation}}, index is zero padded to 4 digits
+		- txidCoinTxs: {"txid/{txid}/{coinId}" : {coinTx}}, read by DiskDb only
+		Maps are stored one key per entry, so a block only writes the entries it changes.
+		- journal: {"undo/{height}" : {journalEntry}}, height is zero padded to 10 digits
+		Records are encoded with the messages of schema.proto.
 	*/
 	persistDb *BadgerDB
 	logger    *zap.Logger
 }
 
 var (
-	STATUS_KEY   = "status"
-	COINS_PREFIX = "coins/"
-	UTXOS_PREFIX = "utxos/"
-	AUC_PREFIX   = "a-u-c/"
-	ACB_PREFIX   = "a-c-b/"
-	CAB_PREFIX   = "c-a-b/"
+	STATUS_KEY     = "status"
+	COINS_PREFIX   = "coins/"
+	UTXOS_PREFIX   = "utxos/"
+	AUC_PREFIX     = "a-u-c/"
+	ACB_PREFIX     = "a-c-b/"
+	CAB_PREFIX     = "c-a-b/"
+	HISTORY_PREFIX = "hist/"
+	COIN_TX_PREFIX = "c-tx/"
+	SPENT_PREFIX   = "spent/"
+	INVALID_PREFIX = "invalid/"
+	TXID_PREFIX    = "txid/"
+	JOURNAL_PREFIX = "undo/"
 )
 
 var _ Database = (*MemDb)(nil)
 
-func NewMemDb(persistPath string, network string, debug bool, logger *zap.Logger) *MemDb {
+// NewMemDb creates the store, keeping undo journals for the latest journalDepth blocks.
+func NewMemDb(persistPath string, network string, journalDepth int, debug bool, logger *zap.Logger) *MemDb {
 	db := &MemDb{
 		network:            network,
 		coins:              make(map[string]*types.CoinInfo),
-		utxoCoin:           make(map[string]*types.UnspentCoin),
-		addressUtxoCoin:    make(map[string]map[string]*types.UnspentCoin),
-		addressCoinBalance: make(map[string]map[string]int),
-		coinAddressBalance: make(map[string]map[string]int),
+		utxoCoin:           make(map[string]types.UtxoCoins),
+		addressUtxoCoin:    make(map[string]map[string]types.UtxoCoins),
+		addressCoinBalance: make(map[string]map[string]types.Amount),
+		coinAddressBalance: make(map[string]map[string]types.Amount),
+		addressHistory:     make(map[string][]*types.HistoryEntry),
+		coinTxs:            make(map[string][]*types.CoinTx),
+		txidCoinTxs:        make(map[string][]*types.CoinTx),
+		coinHolders:        make(map[string]*holderIndex),
+		spentCoins:         make(map[string]types.SpentCoins),
+		invalidOps:         make(map[string][]*types.InvalidOperation),
+		journal:            make(map[int]*journalEntry),
+		journalDepth:       journalDepth,
+		pending:            newJournalEntry(),
 		logger:             logger,
 	}
 
 	if len(persistPath) > 0 {
 		db.persistDb = NewBadgerDB(persistPath)
+		migrate(db.persistDb, logger)
 		db.loadIntoMem()
 	}
 
 	if debug {
 		db.fillTestData()
 	}
+	db.rebuildHolders()
 	return db
 }
 
@@ -116,14 +158,24 @@ func (m *MemDb) GetCoinsInUtxos(utxoCoin []string) ([]*types.UnspentCoin, error)
 
 	var results []*types.UnspentCoin
 	for _, utxo := range utxoCoin {
-		if uc, ok := m.utxoCoin[utxo]; ok {
-			results = append(results, uc)
-		}
+		results = append(results, m.utxoCoin[utxo]...)
+	}
+	return results, nil
+}
+
+// GetSpentCoins returns the coins of the UTXOs which were spent, skipping the others.
+func (m *MemDb) GetSpentCoins(utxos []string) ([]*types.SpentCoin, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var results []*types.SpentCoin
+	for _, utxo := range utxos {
+		results = append(results, m.spentCoins[utxo]...)
 	}
 	return results, nil
 }
 
-func (m *MemDb) GetBalancesByAddress(address string) (map[string]int, error) {
+func (m *MemDb) GetBalancesByAddress(address string) (map[string]types.Amount, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
@@ -133,68 +185,159 @@ func (m *MemDb) GetBalancesByAddress(address string) (map[string]int, error) {
 	return nil, nil
 }
 
+// GetHistoryByAddress returns up to limit entries from the offset of the history of the address in the height range,
+// newest first, and the number of entries in the range. An empty coin matches entries of any coin.
+func (m *MemDb) GetHistoryByAddress(address string, coin string, fromHeight int, toHeight int, offset int, limit int) ([]*types.HistoryEntry, int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	history := m.addressHistory[address]
+	var results []*types.HistoryEntry
+	total := 0
+	for i := len(history) - 1; i >= 0 && history[i].Height >= fromHeight; i-- {
+		entry := history[i]
+		if entry.Height > toHeight || (len(coin) > 0 && entry.CoinId != coin) {
+			continue
+		}
+		if total >= offset && len(results) < limit {
+			results = append(results, entry)
+		}
+		total++
+	}
+	return results, total, nil
+}
+
+// GetCoinTxs returns up to limit transactions of the coin with a sequence before the given one, newest first. An empty
+// type matches transactions of any type.
+func (m *MemDb) GetCoinTxs(id string, before int, limit int, txType string) ([]*types.CoinTx, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	txs := m.coinTxs[id]
+	var results []*types.CoinTx
+	for i := min(before, len(txs)) - 1; i >= 0 && len(results) < limit; i-- {
+		if len(txType) == 0 || txs[i].Type == txType {
+			results = append(results, txs[i])
+		}
+	}
+	return results, nil
+}
+
+// GetCoinTxsByTxid returns the coin transactions recorded for the txid, one per coin it touched.
+func (m *MemDb) GetCoinTxsByTxid(txid string) ([]*types.CoinTx, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return append([]*types.CoinTx(nil), m.txidCoinTxs[txid]...), nil
+}
+
+// GetInvalidOperations returns the rejected operations of the txid, none if nothing was rejected.
+func (m *MemDb) GetInvalidOperations(txid string) ([]*types.InvalidOperation, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return append([]*types.InvalidOperation(nil), m.invalidOps[txid]...), nil
+}
+
 func (m *MemDb) GetCoinsByAddress(address string) ([]*types.UnspentCoin, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
 	if coins, ok := m.addressUtxoCoin[address]; ok {
 		var results []*types.UnspentCoin
-		for _, coin := range coins {
-			results = append(results, coin)
+		for _, owned := range coins {
+			results = append(results, owned...)
 		}
 		return results, nil
 	}
 	return nil, nil
 }
 
-func (m *MemDb) CoinInfoBatchUpdate(updates map[string]*types.CoinInfo) error {
+// ApplyBlock applies all the state changes of a block, together with its undo
+// journal and the indexed height, in a single transaction. If it fails, the
+// in-memory state is no longer consistent with the disk, and the caller should
+// stop indexing and reload from disk.
+func (m *MemDb) ApplyBlock(update *types.BlockUpdate) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	var keys []string
-	var values [][]byte
-	for id, ci := range updates {
-		m.coins[id] = ci
-		if m.persistDb != nil {
-			keys = append(keys, COINS_PREFIX+id)
-			values = append(values, ci.ToBytes())
-		}
+	// Coin infos go first, holder counts are updated on the new ones.
+	keys, values := m.coinInfoBatchUpdate(update.CoinInfos)
+	k, v, err := m.balanceBatchUpdate(update.Balances)
+	if err != nil {
+		return err
+	}
+	keys, values = append(keys, k...), append(values, v...)
+	k, v = m.utxoBatchUpdate(update.Utxos)
+	keys, values = append(keys, k...), append(values, v...)
+	k, v = m.historyBatchUpdate(update.History)
+	keys, values = append(keys, k...), append(values, v...)
+	k, v = m.coinTxBatchUpdate(update.CoinTxs)
+	keys, values = append(keys, k...), append(values, v...)
+	k, v = m.spentBatchUpdate(update.Spends)
+	keys, values = append(keys, k...), append(values, v...)
+	k, v = m.invalidBatchUpdate(update.InvalidOps)
+	keys, values = append(keys, k...), append(values, v...)
+	k, v, err = m.indexedHeightUpdate(update.Height, update.Hash)
+	if err != nil {
+		return err
 	}
+	keys, values = append(keys, k...), append(values, v...)
 
 	if m.persistDb == nil {
 		return nil
 	}
 
-	if err := m.persistDb.BatchSet(keys, values); err != nil {
+	if err := m.persistDb.AtomicBatchSet(keys, values); err != nil {
 		return err
 	}
-	return nil
+	return m.persistDb.Sync()
 }
 
-func (m *MemDb) BalanceBatchUpdate(coinAddressBalances map[string]map[string]int) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+func (m *MemDb) coinInfoBatchUpdate(updates map[string]*types.CoinInfo) ([]string, [][]byte) {
+	var keys []string
+	var values [][]byte
+	for id, ci := range updates {
+		m.recordCoin(id)
+		m.coins[id] = ci
+		if m.persistDb != nil {
+			keys = append(keys, COINS_PREFIX+id)
+			values = append(values, ci.ToBytes())
+		}
+	}
+	return keys, values
+}
 
-	uniqueAddresses := make(map[string]bool)
-	uniqueCoins := make(map[string]bool)
+func (m *MemDb) balanceBatchUpdate(coinAddressBalances map[string]map[string]types.Amount) ([]string, [][]byte, error) {
+	var keys []string
+	var values [][]byte
 	for coin, balances := range coinAddressBalances {
 		if _, ok := m.coinAddressBalance[coin]; !ok {
-			m.coinAddressBalance[coin] = make(map[string]int)
+			m.coinAddressBalance[coin] = make(map[string]types.Amount)
 		}
-		for address, balance := range balances {
-			m.coinAddressBalance[coin][address] += balance
-			if m.coinAddressBalance[coin][address] == 0 {
+		m.recordCoin(coin) // Holder count changes.
+		for address, delta := range balances {
+			balance, err := m.coinAddressBalance[coin][address].Add(delta)
+			if err != nil {
+				return nil, nil, fmt.Errorf("balance of %s in %s: %v", address, coin, err)
+			}
+			m.recordBalance(coin, address)
+			m.updateHolder(coin, address, balance)
+			if balance.IsZero() {
 				delete(m.coinAddressBalance[coin], address)
-				delete(m.addressCoinBalance[address], coin)
+				m.deleteAddressBalance(address, coin)
 			} else {
 				if _, ok := m.addressCoinBalance[address]; !ok {
-					m.addressCoinBalance[address] = make(map[string]int)
+					m.addressCoinBalance[address] = make(map[string]types.Amount)
 				}
-				m.addressCoinBalance[address][coin] += balance
+				m.coinAddressBalance[coin][address] = balance
+				m.addressCoinBalance[address][coin] = balance
 			}
 
 			if m.persistDb != nil {
-				uniqueAddresses[address] = true
+				bs := encodeBalance(balance)
+				keys = append(keys, cabKey(coin, address), acbKey(address, coin))
+				values = append(values, bs, bs)
 			}
 		}
 
@@ -206,113 +349,227 @@ func (m *MemDb) BalanceBatchUpdate(coinAddressBalances map[string]map[string]int
 		}
 
 		if m.persistDb != nil {
-			uniqueCoins[coin] = true
+			// The holder count changed after the coin info was encoded.
+			keys = append(keys, COINS_PREFIX+coin)
+			values = append(values, m.coins[coin].ToBytes())
 		}
 	}
+	return keys, values, nil
+}
 
-	if m.persistDb == nil {
-		return nil
+// deleteAddressBalance drops the balance, and the address once it holds no coin.
+func (m *MemDb) deleteAddressBalance(address string, coin string) {
+	delete(m.addressCoinBalance[address], coin)
+	if len(m.addressCoinBalance[address]) == 0 {
+		delete(m.addressCoinBalance, address)
 	}
+}
+
+// deleteAddressUtxo drops the UTXO, and the address once it holds no UTXO.
+func (m *MemDb) deleteAddressUtxo(address string, utxo string) {
+	delete(m.addressUtxoCoin[address], utxo)
+	if len(m.addressUtxoCoin[address]) == 0 {
+		delete(m.addressUtxoCoin, address)
+	}
+}
 
+func (m *MemDb) utxoBatchUpdate(updates map[string]map[string]*types.UnspentCoin) ([]string, [][]byte) {
 	var keys []string
 	var values [][]byte
-	for coin := range uniqueCoins {
-		keys = append(keys, CAB_PREFIX+coin)
-		var data bytes.Buffer
-		if err := gob.NewEncoder(&data).Encode(m.coinAddressBalance[coin]); err != nil {
-			return err
+	for utxo, changes := range updates {
+		old := m.utxoCoin[utxo]
+		coins := old.Update(changes)
+		if old == nil && coins == nil { // Never stored, nothing to delete.
+			continue
+		}
+		m.recordUtxo(utxo)
+		owners := m.setUtxo(utxo, coins)
+
+		if m.persistDb != nil {
+			keys = append(keys, UTXOS_PREFIX+utxo)
+			values = append(values, encodeUtxoCoins(coins))
+			for _, owner := range owners {
+				keys = append(keys, aucKey(owner, utxo))
+				values = append(values, encodeUtxoCoins(m.addressUtxoCoin[owner][utxo]))
+			}
 		}
-		values = append(values, data.Bytes())
 	}
-	for address := range uniqueAddresses {
-		keys = append(keys, ACB_PREFIX+address)
-		var data bytes.Buffer
-		if err := gob.NewEncoder(&data).Encode(m.addressCoinBalance[address]); err != nil {
-			return err
+	return keys, values
+}
+
+// setUtxo replaces the coins of the UTXO, nil deletes it. It returns the owners of the coins before and after.
+func (m *MemDb) setUtxo(utxo string, coins types.UtxoCoins) []string {
+	owners := m.utxoCoin[utxo].Owners()
+	for _, owner := range owners {
+		m.deleteAddressUtxo(owner, utxo)
+	}
+	if coins == nil {
+		delete(m.utxoCoin, utxo)
+		return owners
+	}
+
+	m.utxoCoin[utxo] = coins
+	for _, owner := range coins.Owners() {
+		if _, ok := m.addressUtxoCoin[owner]; !ok {
+			m.addressUtxoCoin[owner] = make(map[string]types.UtxoCoins)
 		}
-		values = append(values, data.Bytes())
+		m.addressUtxoCoin[owner][utxo] = coins.Owned(owner)
+		owners = appendUnique(owners, owner)
 	}
+	return owners
+}
 
-	if err := m.persistDb.BatchSet(keys, values); err != nil {
-		return err
+func (m *MemDb) historyBatchUpdate(history []*types.HistoryEntry) ([]string, [][]byte) {
+	var keys []string
+	var values [][]byte
+	for _, entry := range history {
+		m.recordHistory(entry.Address)
+		m.addressHistory[entry.Address] = append(m.addressHistory[entry.Address], entry)
+		if m.persistDb != nil {
+			keys = append(keys, historyKey(entry))
+			values = append(values, entry.ToBytes())
+		}
 	}
-	return nil
+	return keys, values
 }
 
-func (m *MemDb) UtxoBatchUpdate(updates map[string]*types.UnspentCoin) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+func historyKey(entry *types.HistoryEntry) string {
+	return fmt.Sprintf("%s%s/%010d/%06d", HISTORY_PREFIX, entry.Address, entry.Height, entry.Index)
+}
 
+func (m *MemDb) coinTxBatchUpdate(coinTxs []*types.CoinTx) ([]string, [][]byte) {
 	var keys []string
 	var values [][]byte
-	uniqueAddresses := make(map[string]bool)
-	for utxo, uc := range updates {
-		old := m.utxoCoin[utxo]
-		if uc == nil {
-			delete(m.utxoCoin, utxo)
-			delete(m.addressUtxoCoin[old.Owner], old.Utxo)
-		} else {
-			m.utxoCoin[utxo] = uc
-			if _, ok := m.addressUtxoCoin[uc.Owner]; !ok {
-				m.addressUtxoCoin[uc.Owner] = make(map[string]*types.UnspentCoin)
-			}
-			m.addressUtxoCoin[uc.Owner][uc.Utxo] = uc
+	for _, tx := range coinTxs {
+		m.recordCoinTx(tx.CoinId)
+		tx.Seq = len(m.coinTxs[tx.CoinId])
+		m.coinTxs[tx.CoinId] = append(m.coinTxs[tx.CoinId], tx)
+		m.txidCoinTxs[tx.Txid] = append(m.txidCoinTxs[tx.Txid], tx)
+		if m.persistDb != nil {
+			keys = append(keys, coinTxKey(tx), txidKey(tx))
+			values = append(values, tx.ToBytes(), tx.ToBytes())
 		}
+	}
+	return keys, values
+}
 
+func (m *MemDb) spentBatchUpdate(spends map[string]types.SpentCoins) ([]string, [][]byte) {
+	var keys []string
+	var values [][]byte
+	for utxo, coins := range spends {
+		m.pending.Spends = append(m.pending.Spends, utxo)
+		m.spentCoins[utxo] = coins
 		if m.persistDb != nil {
-			keys = append(keys, UTXOS_PREFIX+utxo)
-			if uc == nil {
-				values = append(values, nil)
-				uniqueAddresses[old.Owner] = true
-			} else {
-				values = append(values, uc.ToBytes())
-				uniqueAddresses[uc.Owner] = true
-			}
+			keys = append(keys, SPENT_PREFIX+utxo)
+			values = append(values, coins.ToBytes())
 		}
 	}
+	return keys, values
+}
 
-	if m.persistDb == nil {
-		return nil
+func (m *MemDb) invalidBatchUpdate(ops []*types.InvalidOperation) ([]string, [][]byte) {
+	var keys []string
+	var values [][]byte
+	for _, op := range ops {
+		index := len(m.invalidOps[op.Txid])
+		if index == 0 {
+			m.pending.InvalidOps = append(m.pending.InvalidOps, op.Txid)
+		}
+		m.invalidOps[op.Txid] = append(m.invalidOps[op.Txid], op)
+		if m.persistDb != nil {
+			keys = append(keys, invalidKey(op.Txid, index))
+			values = append(values, op.ToBytes())
+		}
 	}
+	return keys, values
+}
 
-	// Collect unique addresses' updates.
-	for address := range uniqueAddresses {
-		keys = append(keys, AUC_PREFIX+address)
-		var data bytes.Buffer
-		if err := gob.NewEncoder(&data).Encode(m.addressUtxoCoin[address]); err != nil {
-			return err
-		}
-		values = append(values, data.Bytes())
+func cabKey(coin string, address string) string {
+	return CAB_PREFIX + coin + "/" + address
+}
+
+func acbKey(address string, coin string) string {
+	return ACB_PREFIX + address + "/" + coin
+}
+
+func aucKey(address string, utxo string) string {
+	return AUC_PREFIX + address + "/" + utxo
+}
+
+// encodeUtxoCoins returns nil for a UTXO without coins, which deletes the key.
+func encodeUtxoCoins(coins types.UtxoCoins) []byte {
+	if len(coins) == 0 {
+		return nil
 	}
+	return coins.ToBytes()
+}
 
-	if err := m.persistDb.BatchSet(keys, values); err != nil {
-		return err
+// encodeBalance returns nil for a zero balance, which deletes the key.
+func encodeBalance(balance types.Amount) []byte {
+	if balance.IsZero() {
+		return nil
 	}
-	return nil
+	return []byte(balance.String())
 }
 
-func (m *MemDb) IndexedHeightUpdate(height int) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+func decodeBalance(bs []byte) (types.Amount, error) {
+	return types.ParseAmount(string(bs))
+}
+
+func coinTxKey(tx *types.CoinTx) string {
+	return fmt.Sprintf("%s%s/%010d", COIN_TX_PREFIX, tx.CoinId, tx.Seq)
+}
 
+func txidKey(tx *types.CoinTx) string {
+	return TXID_PREFIX + tx.Txid + "/" + tx.CoinId
+}
+
+// invalidKey is the key of the index-th operation of the txid rejected, a transaction can carry several operations.
+func invalidKey(txid string, index int) string {
+	return fmt.Sprintf("%s%s/%04d", INVALID_PREFIX, txid, index)
+}
+
+func (m *MemDb) indexedHeightUpdate(height int, hash string) ([]string, [][]byte, error) {
 	m.height = height
+	keys, values, err := m.commitJournal(height, hash)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	if m.persistDb != nil {
-		var keys []string
-		var values [][]byte
-		keys = append(keys, STATUS_KEY)
-		var data bytes.Buffer
-		if err := gob.NewEncoder(&data).Encode(map[string]interface{}{"height": m.height, "network": m.network}); err != nil {
-			return err
+		bs, err := m.statusBytes()
+		if err != nil {
+			return nil, nil, err
 		}
-		values = append(values, data.Bytes())
+		keys = append(keys, STATUS_KEY)
+		values = append(values, bs)
+	}
+	return keys, values, nil
+}
 
-		if err := m.persistDb.BatchSet(keys, values); err != nil {
-			return err
+func (m *MemDb) statusBytes() ([]byte, error) {
+	return encodeStatus(m.height, m.network)
+}
+
+// encodeStatus encodes the status as a Status of schema.proto.
+func encodeStatus(height int, network string) ([]byte, error) {
+	w := &utils.RecordWriter{}
+	w.Int(1, height)
+	w.String(2, network)
+	return w.Bytes(), nil
+}
+
+func decodeStatus(bs []byte) (height int, network string, err error) {
+	err = utils.ReadRecord(bs, func(f utils.RecordField) error {
+		switch f.Num {
+		case 1:
+			height = f.Int()
+		case 2:
+			network = f.String()
 		}
-		m.persistDb.Sync()
-	}
-	return nil
+		return nil
+	})
+	return
 }
 
 func (m *MemDb) loadIntoMem() {
@@ -327,12 +584,9 @@ func (m *MemDb) loadIntoMem() {
 	if err != nil || v == nil {
 		m.height = 0
 	} else {
-		status := make(map[string]interface{})
-		if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&status); err != nil {
+		if m.height, m.network, err = decodeStatus(v); err != nil {
 			panic(fmt.Sprintf("failed to decode height from disk: %v", err))
 		}
-		m.height = status["height"].(int)
-		m.network = status["network"].(string)
 	}
 
 	if m.height == 0 {
@@ -353,70 +607,131 @@ func (m *MemDb) loadIntoMem() {
 	}
 
 	// Load utxoCoin.
-	_, values, err = m.persistDb.Query(UTXOS_PREFIX)
+	keys, values, err := m.persistDb.Query(UTXOS_PREFIX)
 	if err != nil {
 		panic(fmt.Sprintf("failed to load utxoCoin from disk: %v", err))
 	}
 	for i := range values {
-		uc := &types.UnspentCoin{}
-		if err := uc.FromBytes(values[i]); err != nil {
+		var coins types.UtxoCoins
+		if err := coins.FromBytes(values[i]); err != nil {
 			panic(fmt.Sprintf("failed to decode utxoCoin from disk: %v", err))
 		}
-		m.utxoCoin[uc.Utxo] = uc
+		m.utxoCoin[keys[i][len(UTXOS_PREFIX):]] = coins
 	}
 
 	// Load addressUtxoCoin.
-	keys, values, err := m.persistDb.Query(AUC_PREFIX)
+	keys, values, err = m.persistDb.Query(AUC_PREFIX)
 	if err != nil {
 		panic(fmt.Sprintf("failed to load addressUtxoCoin from disk: %v", err))
 	}
 	for i := range values {
-		address := keys[i][len(AUC_PREFIX):]
-		utxoCoin := make(map[string]*types.UnspentCoin)
-		if err := gob.NewDecoder(bytes.NewReader(values[i])).Decode(&utxoCoin); err != nil {
+		address, utxo, _ := strings.Cut(keys[i][len(AUC_PREFIX):], "/")
+		var coins types.UtxoCoins
+		if err := coins.FromBytes(values[i]); err != nil {
 			panic(fmt.Sprintf("failed to decode addressUtxoCoin from disk: %v", err))
 		}
-		m.addressUtxoCoin[address] = utxoCoin
+		if _, ok := m.addressUtxoCoin[address]; !ok {
+			m.addressUtxoCoin[address] = make(map[string]types.UtxoCoins)
+		}
+		m.addressUtxoCoin[address][utxo] = coins
 	}
 
 	// Load addressCoinBalance.
-	keys, values, err = m.persistDb.Query(ACB_PREFIX)
-	if err != nil {
+	if err := m.loadBalances(ACB_PREFIX, m.addressCoinBalance); err != nil {
 		panic(fmt.Sprintf("failed to load addressCoinBalance from disk: %v", err))
 	}
+
+	// Load coinAddressBalance.
+	if err := m.loadBalances(CAB_PREFIX, m.coinAddressBalance); err != nil {
+		panic(fmt.Sprintf("failed to load coinAddressBalance from disk: %v", err))
+	}
+
+	// Load addressHistory, keys are sorted by address, height and index.
+	_, values, err = m.persistDb.Query(HISTORY_PREFIX)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load addressHistory from disk: %v", err))
+	}
 	for i := range values {
-		address := keys[i][len(ACB_PREFIX):]
-		balance := make(map[string]int)
-		if err := gob.NewDecoder(bytes.NewReader(values[i])).Decode(&balance); err != nil {
-			panic(fmt.Sprintf("failed to decode addressCoinBalance from disk: %v", err))
+		entry := &types.HistoryEntry{}
+		if err := entry.FromBytes(values[i]); err != nil {
+			panic(fmt.Sprintf("failed to decode addressHistory from disk: %v", err))
 		}
-		m.addressCoinBalance[address] = balance
+		m.addressHistory[entry.Address] = append(m.addressHistory[entry.Address], entry)
 	}
 
-	// Load coinAddressBalance.
-	keys, values, err = m.persistDb.Query(CAB_PREFIX)
+	// Load coinTxs, keys are sorted by coin and sequence.
+	_, values, err = m.persistDb.Query(COIN_TX_PREFIX)
 	if err != nil {
-		panic(fmt.Sprintf("failed to load coinAddressBalance from disk: %v", err))
+		panic(fmt.Sprintf("failed to load coinTxs from disk: %v", err))
 	}
 	for i := range values {
-		coin := keys[i][len(CAB_PREFIX):]
-		balance := make(map[string]int)
-		if err := gob.NewDecoder(bytes.NewReader(values[i])).Decode(&balance); err != nil {
-			panic(fmt.Sprintf("failed to decode coinAddressBalance from disk: %v", err))
+		tx := &types.CoinTx{}
+		if err := tx.FromBytes(values[i]); err != nil {
+			panic(fmt.Sprintf("failed to decode coinTxs from disk: %v", err))
 		}
-		m.coinAddressBalance[coin] = balance
+		m.coinTxs[tx.CoinId] = append(m.coinTxs[tx.CoinId], tx)
+		m.txidCoinTxs[tx.Txid] = append(m.txidCoinTxs[tx.Txid], tx)
+	}
+
+	// Load spentCoins.
+	keys, values, err = m.persistDb.Query(SPENT_PREFIX)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load spentCoins from disk: %v", err))
 	}
+	for i := range values {
+		var coins types.SpentCoins
+		if err := coins.FromBytes(values[i]); err != nil {
+			panic(fmt.Sprintf("failed to decode spentCoins from disk: %v", err))
+		}
+		m.spentCoins[keys[i][len(SPENT_PREFIX):]] = coins
+	}
+
+	// Load invalidOps.
+	_, values, err = m.persistDb.Query(INVALID_PREFIX)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load invalidOps from disk: %v", err))
+	}
+	for i := range values {
+		op := &types.InvalidOperation{}
+		if err := op.FromBytes(values[i]); err != nil {
+			panic(fmt.Sprintf("failed to decode invalidOps from disk: %v", err))
+		}
+		m.invalidOps[op.Txid] = append(m.invalidOps[op.Txid], op)
+	}
+
+	// Load journal.
+	m.loadJournal()
+}
+
+// loadBalances reads the balances keyed by prefix/{outer}/{inner} into balances[outer][inner].
+func (m *MemDb) loadBalances(prefix string, balances map[string]map[string]types.Amount) error {
+	keys, values, err := m.persistDb.Query(prefix)
+	if err != nil {
+		return err
+	}
+	for i := range values {
+		outer, inner, _ := strings.Cut(keys[i][len(prefix):], "/")
+		balance, err := decodeBalance(values[i])
+		if err != nil {
+			return err
+		}
+		if _, ok := balances[outer]; !ok {
+			balances[outer] = make(map[string]types.Amount)
+		}
+		balances[outer][inner] = balance
+	}
+	return nil
 }
 
 func (m *MemDb) fillTestData() {
 	m.coins["TESTCA"] = &types.CoinInfo{
 		Id:          "TESTCA",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      1,
 		HolderCount:  100,
 		CreatedAt:    1703500000,
@@ -425,12 +740,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCB"] = &types.CoinInfo{
 		Id:          "TESTCB",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      2,
 		HolderCount:  99,
 		CreatedAt:    1703600000,
@@ -439,12 +754,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCC"] = &types.CoinInfo{
 		Id:          "TESTCC",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      3,
 		HolderCount:  98,
 		CreatedAt:    1703700000,
@@ -453,12 +768,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCD"] = &types.CoinInfo{
 		Id:          "TESTCD",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      4,
 		HolderCount:  97,
 		CreatedAt:    1703800000,
@@ -467,12 +782,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCE"] = &types.CoinInfo{
 		Id:          "TESTCE",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      5,
 		HolderCount:  96,
 		CreatedAt:    1703900000,
@@ -481,12 +796,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCF"] = &types.CoinInfo{
 		Id:          "TESTCF",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      6,
 		HolderCount:  95,
 		CreatedAt:    1704000000,
@@ -495,12 +810,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCG"] = &types.CoinInfo{
 		Id:          "TESTCG",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      7,
 		HolderCount:  94,
 		CreatedAt:    1704100000,
@@ -509,12 +824,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCH"] = &types.CoinInfo{
 		Id:          "TESTCH",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      8,
 		HolderCount:  93,
 		CreatedAt:    1704200000,
@@ -523,12 +838,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCI"] = &types.CoinInfo{
 		Id:          "TESTCI",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      9,
 		HolderCount:  92,
 		CreatedAt:    1703100000,
@@ -537,12 +852,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCJ"] = &types.CoinInfo{
 		Id:          "TESTCJ",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      10,
 		HolderCount:  91,
 		CreatedAt:    1703200000,
@@ -551,12 +866,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCK"] = &types.CoinInfo{
 		Id:          "TESTCK",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      11,
 		HolderCount:  90,
 		CreatedAt:    1703300000,
@@ -565,12 +880,12 @@ func (m *MemDb) fillTestData() {
 	}
 	m.coins["TESTCL"] = &types.CoinInfo{
 		Id:          "TESTCL",
-		TotalSupply: 5,
-		Args: map[string]interface{}{
-			"max":   uint64(100),
-			"sats":  uint64(10000),
-			"limit": 1,
-		},
+		TotalSupply: types.NewAmount(5),
+		Args: types.CoinArgs{Carv: &types.CarvArgs{
+			Max:   100,
+			Sats:  10000,
+			Limit: 1,
+		}},
 		TxCount:      12,
 		HolderCount:  89,
 		CreatedAt:    1703400000,
@@ -578,53 +893,53 @@ func (m *MemDb) fillTestData() {
 		DeployHeight: 800004,
 	}
 
-	m.coinAddressBalance["TESTCA"] = map[string]int{
-		"addr1": 1,
-		"addr2": 2,
+	m.coinAddressBalance["TESTCA"] = map[string]types.Amount{
+		"addr1": types.NewAmount(1),
+		"addr2": types.NewAmount(2),
 	}
-	m.coinAddressBalance["TESTCB"] = map[string]int{
-		"addr1": 3,
-		"addr2": 4,
+	m.coinAddressBalance["TESTCB"] = map[string]types.Amount{
+		"addr1": types.NewAmount(3),
+		"addr2": types.NewAmount(4),
 	}
-	m.addressCoinBalance["addr1"] = map[string]int{
-		"TESTCA": 1,
-		"TESTCB": 3,
+	m.addressCoinBalance["addr1"] = map[string]types.Amount{
+		"TESTCA": types.NewAmount(1),
+		"TESTCB": types.NewAmount(3),
 	}
-	m.addressCoinBalance["addr2"] = map[string]int{
-		"TESTCA": 2,
-		"TESTCB": 4,
+	m.addressCoinBalance["addr2"] = map[string]types.Amount{
+		"TESTCA": types.NewAmount(2),
+		"TESTCB": types.NewAmount(4),
 	}
 
-	m.utxoCoin["1111:0"] = &types.UnspentCoin{
+	m.utxoCoin["1111:0"] = types.UtxoCoins{{
 		CoinId: "TESTCA",
 		Owner:  "addr1",
-		Amount: 1,
+		Amount: types.NewAmount(1),
 		Utxo:   "1111:0",
-	}
-	m.utxoCoin["1112:0"] = &types.UnspentCoin{
+	}}
+	m.utxoCoin["1112:0"] = types.UtxoCoins{{
 		CoinId: "TESTCA",
 		Owner:  "addr2",
-		Amount: 2,
+		Amount: types.NewAmount(2),
 		Utxo:   "1112:0",
-	}
-	m.utxoCoin["1113:0"] = &types.UnspentCoin{
+	}}
+	m.utxoCoin["1113:0"] = types.UtxoCoins{{
 		CoinId: "TESTCB",
 		Owner:  "addr1",
-		Amount: 3,
+		Amount: types.NewAmount(3),
 		Utxo:   "1113:0",
-	}
-	m.utxoCoin["1114:0"] = &types.UnspentCoin{
+	}}
+	m.utxoCoin["1114:0"] = types.UtxoCoins{{
 		CoinId: "TESTCB",
 		Owner:  "addr2",
-		Amount: 4,
+		Amount: types.NewAmount(4),
 		Utxo:   "1114:0",
-	}
+	}}
 
-	m.addressUtxoCoin["addr1"] = map[string]*types.UnspentCoin{
+	m.addressUtxoCoin["addr1"] = map[string]types.UtxoCoins{
 		"1111:0": m.utxoCoin["1111:0"],
 		"1113:0": m.utxoCoin["1113:0"],
 	}
-	m.addressUtxoCoin["addr2"] = map[string]*types.UnspentCoin{
+	m.addressUtxoCoin["addr2"] = map[string]types.UtxoCoins{
 		"1112:0": m.utxoCoin["1112:0"],
 		"1114:0": m.utxoCoin["1114:0"],
 	}
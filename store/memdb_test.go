@@ -11,41 +11,6 @@ import (
 	"go.uber.org/zap"
 )
 
-func TestMemDbBalanceBatchUpdate(t *testing.T) {
-	db := NewMemDb("", "testnet", false, nil)
-	db.coins["c1"] = &types.CoinInfo{}
-	db.coins["c2"] = &types.CoinInfo{}
-	db.coinAddressBalance["c1"] = map[string]int{
-		"a1": 1,
-		"a2": 2,
-	}
-	db.addressCoinBalance["a1"] = map[string]int{
-		"c1": 1,
-	}
-	db.addressCoinBalance["a2"] = map[string]int{
-		"c1": 2,
-	}
-
-	db.BalanceBatchUpdate(map[string]map[string]int{
-		"c1": {
-			"a1": -1,
-			"a2": 1,
-		},
-		"c2": {
-			"a1": 1,
-		},
-	})
-
-	assert.Equal(t, db.coinAddressBalance["c1"]["a2"], 3)
-	assert.Equal(t, db.coinAddressBalance["c2"]["a1"], 1)
-	assert.Equal(t, db.addressCoinBalance["a2"]["c1"], 3)
-	assert.Equal(t, db.addressCoinBalance["a1"]["c2"], 1)
-	assert.Equal(t, len(db.coinAddressBalance["c1"]), 1)
-	assert.Equal(t, len(db.addressCoinBalance["a1"]), 1)
-	assert.Equal(t, db.coins["c1"].HolderCount, 1)
-	assert.Equal(t, db.coins["c2"].HolderCount, 1)
-}
-
 func TestEncodeMapStringInt(t *testing.T) {
 	m := map[string]int{
 		"a": 1,
@@ -66,7 +31,7 @@ func TestEncodeMapStringInt(t *testing.T) {
 }
 
 func TestEncodeMapStringUnspentCoin(t *testing.T) {
-	m := map[string]*types.UnspentCoin{
+	m := map[string]*gobUnspentCoin{
 		"a": {
 			CoinId: "c1",
 			Amount: 1,
@@ -82,7 +47,7 @@ func TestEncodeMapStringUnspentCoin(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	m2 := map[string]*types.UnspentCoin{}
+	m2 := map[string]*gobUnspentCoin{}
 	if err := gob.NewDecoder(bytes.NewReader(data.Bytes())).Decode(&m2); err != nil {
 		t.Fatal(err)
 	}
@@ -96,33 +61,36 @@ func TestMemDbSaveLoadCoinInfo(t *testing.T) {
 		os.RemoveAll("./memdb-test-coins/")
 	}()
 
-	db := NewMemDb("./memdb-test-coins/", "testnet", false, logger)
-	db.CoinInfoBatchUpdate(map[string]*types.CoinInfo{
-		"c1": {
-			Id:          "c1",
-			TotalSupply: 1,
-			Args: map[string]interface{}{
-				"max": uint64(100),
+	db := NewMemDb("./memdb-test-coins/", "testnet", 100, false, logger)
+	db.ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		Hash:   "h1",
+		CoinInfos: map[string]*types.CoinInfo{
+			"c1": {
+				Id:          "c1",
+				TotalSupply: types.NewAmount(1),
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max: 100,
+				}},
+				TxCount:     1,
+				HolderCount: 1,
+				CreatedAt:   2,
 			},
-			TxCount:     1,
-			HolderCount: 1,
-			CreatedAt:   2,
-		},
-		"c2": {
-			Id:          "c2",
-			TotalSupply: 1,
-			Args: map[string]interface{}{
-				"max": uint64(100),
+			"c2": {
+				Id:          "c2",
+				TotalSupply: types.NewAmount(1),
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max: 100,
+				}},
+				TxCount:     1,
+				HolderCount: 1,
+				CreatedAt:   2,
 			},
-			TxCount:     1,
-			HolderCount: 1,
-			CreatedAt:   2,
 		},
 	})
-	db.IndexedHeightUpdate(1)
 	db.Close()
 
-	db2 := NewMemDb("./memdb-test-coins/", "testnet", false, logger)
+	db2 := NewMemDb("./memdb-test-coins/", "testnet", 100, false, logger)
 	db2.persistDb.Close()
 
 	assert.Equal(t, db.coins, db2.coins)
@@ -134,25 +102,28 @@ func TestMemDbSaveLoadUtxo(t *testing.T) {
 		os.RemoveAll("./memdb-test-utxos/")
 	}()
 
-	db := NewMemDb("./memdb-test-utxos/", "testnet", false, logger)
-	db.UtxoBatchUpdate(map[string]*types.UnspentCoin{
-		"u1": {
-			CoinId: "c1",
-			Owner:  "a1",
-			Amount: 1,
-			Utxo:   "u1",
-		},
-		"u2": {
-			CoinId: "c2",
-			Owner:  "a2",
-			Amount: 2,
-			Utxo:   "u2",
+	db := NewMemDb("./memdb-test-utxos/", "testnet", 100, false, logger)
+	db.ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		Hash:   "h1",
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			"u1": {"c1": {
+				CoinId: "c1",
+				Owner:  "a1",
+				Amount: types.NewAmount(1),
+				Utxo:   "u1",
+			}},
+			"u2": {"c2": {
+				CoinId: "c2",
+				Owner:  "a2",
+				Amount: types.NewAmount(2),
+				Utxo:   "u2",
+			}},
 		},
 	})
-	db.IndexedHeightUpdate(1)
 	db.Close()
 
-	db2 := NewMemDb("./memdb-test-utxos/", "testnet", false, logger)
+	db2 := NewMemDb("./memdb-test-utxos/", "testnet", 100, false, logger)
 	db2.persistDb.Close()
 
 	assert.Equal(t, db.utxoCoin, db2.utxoCoin)
@@ -165,42 +136,45 @@ func TestMemDbSaveLoadBalance(t *testing.T) {
 		os.RemoveAll("./memdb-test-balances/")
 	}()
 
-	db := NewMemDb("./memdb-test-balances/", "testnet", false, logger)
-	db.CoinInfoBatchUpdate(map[string]*types.CoinInfo{
-		"c1": {
-			Id:          "c1",
-			TotalSupply: 1,
-			Args: map[string]interface{}{
-				"max": uint64(100),
+	db := NewMemDb("./memdb-test-balances/", "testnet", 100, false, logger)
+	db.ApplyBlock(&types.BlockUpdate{
+		Height: 1,
+		Hash:   "h1",
+		CoinInfos: map[string]*types.CoinInfo{
+			"c1": {
+				Id:          "c1",
+				TotalSupply: types.NewAmount(1),
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max: 100,
+				}},
+				TxCount:     1,
+				HolderCount: 1,
+				CreatedAt:   2,
 			},
-			TxCount:     1,
-			HolderCount: 1,
-			CreatedAt:   2,
-		},
-		"c2": {
-			Id:          "c2",
-			TotalSupply: 1,
-			Args: map[string]interface{}{
-				"max": uint64(100),
+			"c2": {
+				Id:          "c2",
+				TotalSupply: types.NewAmount(1),
+				Args: types.CoinArgs{Carv: &types.CarvArgs{
+					Max: 100,
+				}},
+				TxCount:     1,
+				HolderCount: 1,
+				CreatedAt:   2,
 			},
-			TxCount:     1,
-			HolderCount: 1,
-			CreatedAt:   2,
-		},
-	})
-	db.BalanceBatchUpdate(map[string]map[string]int{
-		"c1": {
-			"a1": 1,
-			"a2": 2,
 		},
-		"c2": {
-			"a1": 1,
+		Balances: map[string]map[string]types.Amount{
+			"c1": {
+				"a1": types.NewAmount(1),
+				"a2": types.NewAmount(2),
+			},
+			"c2": {
+				"a1": types.NewAmount(1),
+			},
 		},
 	})
-	db.IndexedHeightUpdate(1)
 	db.Close()
 
-	db2 := NewMemDb("./memdb-test-balances/", "testnet", false, logger)
+	db2 := NewMemDb("./memdb-test-balances/", "testnet", 100, false, logger)
 	db2.persistDb.Close()
 
 	assert.Equal(t, db.coinAddressBalance, db2.coinAddressBalance)
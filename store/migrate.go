@@ -0,0 +1,509 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/dgraph-io/badger"
+	"go.uber.org/zap"
+)
+
+var (
+	VERSION_KEY          = "version"
+	MIGRATE_PROGRESS_KEY = "migrating"
+	DB_VERSION           = 4
+	MIGRATE_BATCH_SIZE   = 10000
+	MIGRATE_BATCH_BYTES  = 4 << 20
+)
+
+// migrations[v] upgrades a database of version v to v+1. A migration interrupted by a crash must be safe to run again,
+// the version is only written after it completes.
+var migrations = []func(db *BadgerDB, logger *zap.Logger) error{
+	splitMaps,     // 0: a-u-c/, a-c-b/ and c-a-b/ maps gob encoded under a single key, no txid/ index.
+	encodeRecords, // 1: records gob encoded.
+	indexInvalid,  // 2: a single invalid/ operation per txid.
+	groupCoins,    // 3: a single coin per utxos/, a-u-c/ and spent/ key.
+}
+
+// migrate upgrades the database in place to DB_VERSION.
+func migrate(db *BadgerDB, logger *zap.Logger) {
+	version := 0
+	v, err := db.Get(VERSION_KEY)
+	if err == nil {
+		if version, err = strconv.Atoi(string(v)); err != nil {
+			panic(fmt.Sprintf("failed to decode database version: %v", err))
+		}
+	} else if err != badger.ErrKeyNotFound {
+		panic(fmt.Sprintf("failed to read database version: %v", err))
+	} else if _, err := db.Get(STATUS_KEY); err == badger.ErrKeyNotFound {
+		version = DB_VERSION // A new database.
+		setVersion(db, version)
+	}
+
+	if version > DB_VERSION {
+		panic(fmt.Sprintf("database version %d is newer than the supported version %d", version, DB_VERSION))
+	}
+	for ; version < DB_VERSION; version++ {
+		logger.Info("migrating database", zap.Int("from", version), zap.Int("to", version+1))
+		if err := migrations[version](db, logger); err != nil {
+			panic(fmt.Sprintf("failed to migrate database from version %d: %v", version, err))
+		}
+		setVersion(db, version+1)
+	}
+}
+
+// setVersion writes the version, and clears the progress of the migration to it.
+func setVersion(db *BadgerDB, version int) {
+	keys := []string{VERSION_KEY, MIGRATE_PROGRESS_KEY}
+	if err := db.AtomicBatchSet(keys, [][]byte{[]byte(strconv.Itoa(version)), nil}); err != nil {
+		panic(fmt.Sprintf("failed to write database version: %v", err))
+	}
+}
+
+// migrationBatch buffers the writes of a migration. Writes are flushed in order, so a map is only deleted after all
+// of its entries are written.
+type migrationBatch struct {
+	db     *BadgerDB
+	keys   []string
+	values [][]byte
+}
+
+func (b *migrationBatch) set(key string, value []byte) error {
+	b.keys = append(b.keys, key)
+	b.values = append(b.values, value)
+	if len(b.keys) < MIGRATE_BATCH_SIZE {
+		return nil
+	}
+	return b.flush()
+}
+
+func (b *migrationBatch) flush() error {
+	if len(b.keys) == 0 {
+		return nil
+	}
+	err := b.db.BatchSet(b.keys, b.values)
+	b.keys, b.values = nil, nil
+	return err
+}
+
+// splitMaps stores the maps one key per entry, and indexes the coin transactions by txid. Holder counts are
+// recounted, they were persisted before being updated.
+func splitMaps(db *BadgerDB, logger *zap.Logger) error {
+	batch := &migrationBatch{db: db}
+
+	// A map is keyed by a single segment after the prefix, its entries by two.
+	err := db.Iterate(AUC_PREFIX, func(key string, value []byte) error {
+		address := key[len(AUC_PREFIX):]
+		if strings.Contains(address, "/") {
+			return nil
+		}
+		utxoCoin := make(map[string]*gobUnspentCoin)
+		if err := gobDecode(value, &utxoCoin); err != nil {
+			return fmt.Errorf("failed to decode %s: %v", key, err)
+		}
+		for utxo, uc := range utxoCoin {
+			bs, err := gobEncode(uc)
+			if err != nil {
+				return err
+			}
+			if err := batch.set(aucKey(address, utxo), bs); err != nil {
+				return err
+			}
+		}
+		return batch.set(key, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range []string{ACB_PREFIX, CAB_PREFIX} {
+		err := db.Iterate(prefix, func(key string, value []byte) error {
+			outer := key[len(prefix):]
+			if strings.Contains(outer, "/") {
+				return nil
+			}
+			balances := make(map[string]int)
+			if err := gobDecode(value, &balances); err != nil {
+				return fmt.Errorf("failed to decode %s: %v", key, err)
+			}
+			for inner, balance := range balances {
+				if err := batch.set(prefix+outer+"/"+inner, encodeBalance(types.NewAmount(int64(balance)))); err != nil {
+					return err
+				}
+			}
+
+			if prefix == CAB_PREFIX {
+				bs, err := db.Get(COINS_PREFIX + outer)
+				if err == nil {
+					ci := &gobCoinInfo{}
+					if err := gobDecode(bs, ci); err != nil {
+						return err
+					}
+					ci.HolderCount = len(balances)
+					if bs, err = gobEncode(ci); err != nil {
+						return err
+					}
+					if err := batch.set(COINS_PREFIX+outer, bs); err != nil {
+						return err
+					}
+				} else if err != badger.ErrKeyNotFound {
+					return err
+				}
+			}
+			return batch.set(key, nil)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = db.Iterate(COIN_TX_PREFIX, func(key string, value []byte) error {
+		tx := &types.CoinTx{}
+		if err := gobDecode(value, tx); err != nil {
+			return fmt.Errorf("failed to decode %s: %v", key, err)
+		}
+		return batch.set(txidKey(tx), value)
+	})
+	if err != nil {
+		return err
+	}
+	return batch.flush()
+}
+
+// indexInvalid moves the rejected operation of each txid to the first index, as a transaction can carry several.
+func indexInvalid(db *BadgerDB, logger *zap.Logger) error {
+	batch := &migrationBatch{db: db}
+	err := db.Iterate(INVALID_PREFIX, func(key string, value []byte) error {
+		txid := key[len(INVALID_PREFIX):]
+		if strings.Contains(txid, "/") {
+			return nil
+		}
+		if err := batch.set(invalidKey(txid, 0), value); err != nil {
+			return err
+		}
+		return batch.set(key, nil)
+	})
+	if err != nil {
+		return err
+	}
+	return batch.flush()
+}
+
+// encodeRecords converts the records from gob to the encoding of schema.proto.
+func encodeRecords(db *BadgerDB, logger *zap.Logger) error {
+	return convertRecords(db, logger, []string{""}, encodeRecord)
+}
+
+// groupCoins converts the coin of each UTXO into the list of coins UTXOs hold since.
+func groupCoins(db *BadgerDB, logger *zap.Logger) error {
+	return convertRecords(db, logger, []string{AUC_PREFIX, SPENT_PREFIX, UTXOS_PREFIX}, func(key string, value []byte) ([]byte, error) {
+		if strings.HasPrefix(key, SPENT_PREFIX) {
+			sc := &types.SpentCoin{}
+			if err := sc.FromBytes(value); err != nil {
+				return nil, err
+			}
+			return types.SpentCoins{sc}.ToBytes(), nil
+		}
+		uc := &types.UnspentCoin{}
+		if err := uc.FromBytes(value); err != nil {
+			return nil, err
+		}
+		return types.UtxoCoins{uc}.ToBytes(), nil
+	})
+}
+
+// convertRecords rewrites the records under the prefixes, which must be sorted, skipping those convert returns nil
+// for. Records can't tell their encoding, so the last converted key is stored with each batch and a resumed migration
+// continues after it.
+func convertRecords(db *BadgerDB, logger *zap.Logger, prefixes []string, convert func(key string, value []byte) ([]byte, error)) error {
+	progress, err := db.Get(MIGRATE_PROGRESS_KEY)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	if len(progress) > 0 {
+		logger.Info("resuming migration", zap.String("after", string(progress)))
+	}
+
+	var keys []string
+	var values [][]byte
+	size := 0
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		err := db.AtomicBatchSet(append(keys, MIGRATE_PROGRESS_KEY), append(values, []byte(keys[len(keys)-1])))
+		keys, values, size = nil, nil, 0
+		return err
+	}
+
+	for _, prefix := range prefixes {
+		err = db.Iterate(prefix, func(key string, value []byte) error {
+			if key <= string(progress) {
+				return nil
+			}
+			bs, err := convert(key, value)
+			if err != nil {
+				return fmt.Errorf("failed to convert %s: %v", key, err)
+			}
+			if bs == nil {
+				return nil
+			}
+			keys = append(keys, key)
+			values = append(values, bs)
+			size += len(key) + len(bs)
+			if len(keys) < MIGRATE_BATCH_SIZE && size < MIGRATE_BATCH_BYTES {
+				return nil
+			}
+			return flush()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+// record is implemented by the types stored under a key prefix.
+type record interface {
+	ToBytes() []byte
+}
+
+// legacyRecord is implemented by the gob encoded types which changed since.
+type legacyRecord interface {
+	convert() (record, error)
+}
+
+func encodeRecord(key string, value []byte) ([]byte, error) {
+	if key == STATUS_KEY {
+		status := make(map[string]interface{})
+		if err := gobDecode(value, &status); err != nil {
+			return nil, err
+		}
+		height, _ := status["height"].(int)
+		network, _ := status["network"].(string)
+		return encodeStatus(height, network)
+	}
+	if strings.HasPrefix(key, JOURNAL_PREFIX) {
+		legacy := &gobJournalEntry{}
+		if err := gobDecode(value, legacy); err != nil {
+			return nil, err
+		}
+		entry, err := legacy.journalEntry()
+		if err != nil {
+			return nil, err
+		}
+		return entry.toBytes()
+	}
+
+	// Records with amounts are decoded into their legacy types, the others into their current types.
+	var legacy legacyRecord
+	var r record
+	switch {
+	case strings.HasPrefix(key, COINS_PREFIX):
+		legacy = &gobCoinInfo{}
+	case strings.HasPrefix(key, UTXOS_PREFIX), strings.HasPrefix(key, AUC_PREFIX):
+		legacy = &gobUnspentCoin{}
+	case strings.HasPrefix(key, SPENT_PREFIX):
+		legacy = &gobSpentCoin{}
+	case strings.HasPrefix(key, HISTORY_PREFIX):
+		legacy = &gobHistoryEntry{}
+	case strings.HasPrefix(key, COIN_TX_PREFIX), strings.HasPrefix(key, TXID_PREFIX):
+		r = &types.CoinTx{}
+	case strings.HasPrefix(key, INVALID_PREFIX):
+		r = &types.InvalidOperation{}
+	default:
+		return nil, nil
+	}
+	if legacy != nil {
+		if err := gobDecode(value, legacy); err != nil {
+			return nil, err
+		}
+		var err error
+		if r, err = legacy.convert(); err != nil {
+			return nil, err
+		}
+	} else if err := gobDecode(value, r); err != nil {
+		return nil, err
+	}
+	return r.ToBytes(), nil
+}
+
+// gobCoinInfo is a CoinInfo as gob encoded before version 2, with untyped args and an int total supply.
+type gobCoinInfo struct {
+	Id           string
+	Protocol     string
+	TotalSupply  int
+	Args         map[string]interface{}
+	TxCount      int
+	HolderCount  int
+	CreatedAt    int
+	DeployTx     string
+	DeployHeight int
+}
+
+func (c *gobCoinInfo) coinInfo() (*types.CoinInfo, error) {
+	values := make(map[string]uint64, len(c.Args))
+	for key, value := range c.Args {
+		switch v := value.(type) {
+		case uint64:
+			values[key] = v
+		case uint32:
+			values[key] = uint64(v)
+		case int:
+			if v < 0 {
+				return nil, fmt.Errorf("negative argument %s of coin %s: %d", key, c.Id, v)
+			}
+			values[key] = uint64(v)
+		default:
+			return nil, fmt.Errorf("argument %s of coin %s has unexpected type %T", key, c.Id, value)
+		}
+	}
+	return &types.CoinInfo{
+		Id:           c.Id,
+		Protocol:     c.Protocol,
+		TotalSupply:  types.NewAmount(int64(c.TotalSupply)),
+		Args:         types.NewCoinArgs(c.Protocol, values),
+		TxCount:      c.TxCount,
+		HolderCount:  c.HolderCount,
+		CreatedAt:    c.CreatedAt,
+		DeployTx:     c.DeployTx,
+		DeployHeight: c.DeployHeight,
+	}, nil
+}
+
+func (c *gobCoinInfo) convert() (record, error) {
+	return c.coinInfo()
+}
+
+// gobUnspentCoin is an UnspentCoin as gob encoded before version 2, with an int amount.
+type gobUnspentCoin struct {
+	CoinId   string
+	Protocol string
+	Owner    string
+	Amount   int
+	Utxo     string
+}
+
+func (c *gobUnspentCoin) unspentCoin() *types.UnspentCoin {
+	return &types.UnspentCoin{
+		CoinId:   c.CoinId,
+		Protocol: c.Protocol,
+		Owner:    c.Owner,
+		Amount:   types.NewAmount(int64(c.Amount)),
+		Utxo:     c.Utxo,
+	}
+}
+
+func (c *gobUnspentCoin) convert() (record, error) {
+	return c.unspentCoin(), nil
+}
+
+// gobSpentCoin is a SpentCoin as gob encoded before version 2, gob names the embedded coin after its type.
+type gobSpentCoin struct {
+	UnspentCoin gobUnspentCoin
+	SpentTxid   string
+	SpentHeight int
+}
+
+func (c *gobSpentCoin) convert() (record, error) {
+	return &types.SpentCoin{
+		UnspentCoin: *c.UnspentCoin.unspentCoin(),
+		SpentTxid:   c.SpentTxid,
+		SpentHeight: c.SpentHeight,
+	}, nil
+}
+
+// gobHistoryEntry is a HistoryEntry as gob encoded before version 2, with an int delta.
+type gobHistoryEntry struct {
+	Txid      string
+	Height    int
+	Index     int
+	Time      int
+	CoinId    string
+	Protocol  string
+	Address   string
+	Delta     int
+	Direction string
+	Utxo      string
+	IsMint    bool
+}
+
+func (e *gobHistoryEntry) convert() (record, error) {
+	return &types.HistoryEntry{
+		Txid:      e.Txid,
+		Height:    e.Height,
+		Index:     e.Index,
+		Time:      e.Time,
+		CoinId:    e.CoinId,
+		Protocol:  e.Protocol,
+		Address:   e.Address,
+		Delta:     types.NewAmount(int64(e.Delta)),
+		Direction: e.Direction,
+		Utxo:      e.Utxo,
+		IsMint:    e.IsMint,
+	}, nil
+}
+
+// gobJournalEntry is a journalEntry as gob encoded before version 2.
+type gobJournalEntry struct {
+	Height     int
+	Hash       string
+	Coins      map[string]*gobCoinInfo
+	NewCoins   []string
+	Balances   map[string]map[string]int
+	Utxos      map[string]*gobUnspentCoin
+	NewUtxos   []string
+	History    []string
+	CoinTxs    []string
+	Spends     []string
+	InvalidOps []string
+}
+
+func (e *gobJournalEntry) journalEntry() (*journalEntry, error) {
+	entry := &journalEntry{
+		Height:     e.Height,
+		Hash:       e.Hash,
+		Coins:      make(map[string]*types.CoinInfo, len(e.Coins)),
+		NewCoins:   e.NewCoins,
+		Balances:   make(map[string]map[string]types.Amount, len(e.Balances)),
+		Utxos:      make(map[string]types.UtxoCoins, len(e.Utxos)),
+		NewUtxos:   e.NewUtxos,
+		History:    e.History,
+		CoinTxs:    e.CoinTxs,
+		Spends:     e.Spends,
+		InvalidOps: e.InvalidOps,
+	}
+	for id, legacy := range e.Coins {
+		ci, err := legacy.coinInfo()
+		if err != nil {
+			return nil, err
+		}
+		entry.Coins[id] = ci
+	}
+	for coin, balances := range e.Balances {
+		entry.Balances[coin] = make(map[string]types.Amount, len(balances))
+		for address, balance := range balances {
+			entry.Balances[coin][address] = types.NewAmount(int64(balance))
+		}
+	}
+	for utxo, legacy := range e.Utxos {
+		entry.Utxos[utxo] = types.UtxoCoins{legacy.unspentCoin()}
+	}
+	return entry, nil
+}
+
+func gobEncode(v interface{}) ([]byte, error) {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(v); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+func gobDecode(bs []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(bs)).Decode(v)
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// writeVersion0 writes the state of applyTestBlocks after block 1 in the layout before versioning, with the holder
+// count persisted before being updated and records gob encoded.
+func writeVersion0(t *testing.T, path string) {
+	db := NewBadgerDB(path)
+	defer db.Close()
+
+	uc := &gobUnspentCoin{CoinId: "c1", Owner: "a1", Amount: 2, Utxo: "u1"}
+	status, _ := gobEncode(map[string]interface{}{"height": 1, "network": "testnet"})
+	auc, _ := gobEncode(map[string]*gobUnspentCoin{"u1": uc})
+	acb, _ := gobEncode(map[string]int{"c1": 2})
+	cab, _ := gobEncode(map[string]int{"a1": 2})
+	journal, _ := gobEncode(&gobJournalEntry{
+		Height:     1,
+		Hash:       "h1",
+		NewCoins:   []string{"c1"},
+		Balances:   map[string]map[string]int{"c1": {"a1": 0}},
+		NewUtxos:   []string{"u1"},
+		CoinTxs:    []string{"c1"},
+		InvalidOps: []string{"t2"},
+	})
+	ci, _ := gobEncode(&gobCoinInfo{Id: "c1", TotalSupply: 2, Args: map[string]interface{}{"max": uint64(100)}, TxCount: 2})
+	utxo, _ := gobEncode(uc)
+	tx := &types.CoinTx{Txid: "t1", Height: 1, CoinId: "c1", Type: "mint"}
+	coinTx, _ := gobEncode(tx)
+	invalid, _ := gobEncode(&types.InvalidOperation{Txid: "t2", Height: 1, CoinId: "c1", Reason: "r"})
+
+	assert.Nil(t, db.AtomicBatchSet(
+		[]string{STATUS_KEY, COINS_PREFIX + "c1", UTXOS_PREFIX + "u1", AUC_PREFIX + "a1", ACB_PREFIX + "a1", CAB_PREFIX + "c1", coinTxKey(tx), INVALID_PREFIX + "t2", journalKey(1)},
+		[][]byte{status, ci, utxo, auc, acb, cab, coinTx, invalid, journal},
+	))
+}
+
+func TestMigrateVersion0(t *testing.T) {
+	for _, s := range testStores {
+		t.Run(s.name, func(t *testing.T) {
+			path := t.TempDir()
+			writeVersion0(t, path)
+
+			db := s.open(path, 100)
+			height, network, _ := db.GetStatus()
+			assert.Equal(t, 1, height)
+			assert.Equal(t, "testnet", network)
+			ci, _ := db.GetCoinInfoById("c1")
+			assert.Equal(t, 1, ci.HolderCount)
+			assert.Equal(t, types.CoinArgs{Carv: &types.CarvArgs{Max: 100}}, ci.Args)
+			balances, _ := db.GetBalancesByAddress("a1")
+			assert.Equal(t, map[string]types.Amount{"c1": types.NewAmount(2)}, balances)
+			utxos, _ := db.GetCoinsByAddress("a1")
+			assert.Equal(t, []*types.UnspentCoin{{CoinId: "c1", Owner: "a1", Amount: types.NewAmount(2), Utxo: "u1"}}, utxos)
+			holders, _, _ := db.GetHolders("c1", 0, 10)
+			assert.Equal(t, []*types.Holder{{Address: "a1", Balance: types.NewAmount(2)}}, holders)
+			txs, _ := db.GetCoinTxsByTxid("t1")
+			assert.Equal(t, 1, len(txs))
+			ops, _ := db.GetInvalidOperations("t2")
+			assert.Equal(t, []*types.InvalidOperation{{Txid: "t2", Height: 1, CoinId: "c1", Reason: "r"}}, ops)
+			assert.Nil(t, db.RevertBlocks(1))
+			balances, _ = db.GetBalancesByAddress("a1")
+			assert.Equal(t, 0, len(balances))
+			ops, _ = db.GetInvalidOperations("t2")
+			assert.Equal(t, 0, len(ops))
+			db.Close()
+
+			raw := NewBadgerDB(path)
+			defer raw.Close()
+			version, _ := raw.Get(VERSION_KEY)
+			assert.Equal(t, "4", string(version))
+			for _, key := range []string{INVALID_PREFIX + "t2", AUC_PREFIX + "a1", ACB_PREFIX + "a1", CAB_PREFIX + "c1", cabKey("c1", "a1"), aucKey("a1", "u1")} {
+				_, err := raw.Get(key)
+				assert.Equal(t, badger.ErrKeyNotFound, err, key)
+			}
+		})
+	}
+}
+
+// A migration interrupted after some maps were split runs again from the start.
+func TestMigrateInterrupted(t *testing.T) {
+	path := t.TempDir()
+	writeVersion0(t, path)
+	db := NewBadgerDB(path)
+	batch := &migrationBatch{db: db}
+	uc, _ := gobEncode(&gobUnspentCoin{CoinId: "c1", Owner: "a1", Amount: 2, Utxo: "u1"})
+	assert.Nil(t, batch.set(aucKey("a1", "u1"), uc))
+	assert.Nil(t, batch.set(AUC_PREFIX+"a1", nil))
+	assert.Nil(t, batch.flush())
+	db.Close()
+
+	mem := NewMemDb(path, "testnet", 100, false, zap.NewNop())
+	defer mem.Close()
+	assert.Equal(t, 1, len(mem.addressUtxoCoin["a1"]))
+	assert.Equal(t, map[string]types.Amount{"a1": types.NewAmount(2)}, mem.coinAddressBalance["c1"])
+	assert.Equal(t, 1, mem.coins["c1"].HolderCount)
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	path := t.TempDir()
+	db := NewDiskDb(path, "testnet", 100, 10, zap.NewNop())
+	db.Close()
+
+	raw := NewBadgerDB(path)
+	defer raw.Close()
+	version, _ := raw.Get(VERSION_KEY)
+	assert.Equal(t, "4", string(version))
+}
+
+// A conversion of the records interrupted after a batch resumes after the last converted key.
+func TestMigrateEncodeRecordsResumed(t *testing.T) {
+	path := t.TempDir()
+	writeVersion0(t, path)
+	db := NewBadgerDB(path)
+	assert.Nil(t, splitMaps(db, zap.NewNop()))
+	setVersion(db, 1)
+
+	batchSize := MIGRATE_BATCH_SIZE
+	MIGRATE_BATCH_SIZE = 2
+	defer func() { MIGRATE_BATCH_SIZE = batchSize }()
+	keys, values, err := db.Query(AUC_PREFIX)
+	assert.Nil(t, err)
+	bs, err := encodeRecord(keys[0], values[0])
+	assert.Nil(t, err)
+	assert.Nil(t, db.AtomicBatchSet([]string{keys[0], MIGRATE_PROGRESS_KEY}, [][]byte{bs, []byte(keys[0])}))
+	db.Close()
+
+	mem := NewMemDb(path, "testnet", 100, false, zap.NewNop())
+	assert.Equal(t, 1, len(mem.addressUtxoCoin["a1"]))
+	assert.Equal(t, types.NewAmount(2), mem.coins["c1"].TotalSupply)
+	assert.Equal(t, 1, len(mem.journal))
+	mem.Close()
+
+	raw := NewBadgerDB(path)
+	defer raw.Close()
+	_, err = raw.Get(MIGRATE_PROGRESS_KEY)
+	assert.Equal(t, badger.ErrKeyNotFound, err)
+}
+
+// Coins stored one per key are grouped by UTXO.
+func TestMigrateGroupCoins(t *testing.T) {
+	for _, s := range testStores {
+		t.Run(s.name, func(t *testing.T) {
+			path := t.TempDir()
+			writeVersion0(t, path)
+			raw := NewBadgerDB(path)
+			for _, migration := range migrations[:3] {
+				assert.Nil(t, migration(raw, zap.NewNop()))
+			}
+			setVersion(raw, 3)
+			sc := &types.SpentCoin{
+				UnspentCoin: types.UnspentCoin{CoinId: "c1", Owner: "a0", Amount: types.NewAmount(1), Utxo: "u0"},
+				SpentTxid:   "t1",
+				SpentHeight: 1,
+			}
+			assert.Nil(t, raw.AtomicBatchSet([]string{SPENT_PREFIX + "u0"}, [][]byte{sc.ToBytes()}))
+			raw.Close()
+
+			db := s.open(path, 100)
+			defer db.Close()
+			coins, _ := db.GetCoinsInUtxos([]string{"u1"})
+			assert.Equal(t, []*types.UnspentCoin{{CoinId: "c1", Owner: "a1", Amount: types.NewAmount(2), Utxo: "u1"}}, coins)
+			coins, _ = db.GetCoinsByAddress("a1")
+			assert.Equal(t, 1, len(coins))
+			spent, _ := db.GetSpentCoins([]string{"u0"})
+			assert.Equal(t, []*types.SpentCoin{sc}, spent)
+		})
+	}
+}
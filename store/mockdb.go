@@ -39,39 +39,40 @@ func (m *MockDatabase) EXPECT() *MockDatabaseMockRecorder {
 	return m.recorder
 }
 
-// BalanceBatchUpdate mocks base method.
-func (m *MockDatabase) BalanceBatchUpdate(coinAddressBalances map[string]map[string]int) error {
+// ApplyBlock mocks base method.
+func (m *MockDatabase) ApplyBlock(update *types.BlockUpdate) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "BalanceBatchUpdate", coinAddressBalances)
+	ret := m.ctrl.Call(m, "ApplyBlock", update)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// BalanceBatchUpdate indicates an expected call of BalanceBatchUpdate.
-func (mr *MockDatabaseMockRecorder) BalanceBatchUpdate(coinAddressBalances any) *gomock.Call {
+// ApplyBlock indicates an expected call of ApplyBlock.
+func (mr *MockDatabaseMockRecorder) ApplyBlock(update any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BalanceBatchUpdate", reflect.TypeOf((*MockDatabase)(nil).BalanceBatchUpdate), coinAddressBalances)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyBlock", reflect.TypeOf((*MockDatabase)(nil).ApplyBlock), update)
 }
 
-// CoinInfoBatchUpdate mocks base method.
-func (m *MockDatabase) CoinInfoBatchUpdate(updates map[string]*types.CoinInfo) error {
+// CountHoldersAbove mocks base method.
+func (m *MockDatabase) CountHoldersAbove(id string, threshold types.Amount) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CoinInfoBatchUpdate", updates)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "CountHoldersAbove", id, threshold)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// CoinInfoBatchUpdate indicates an expected call of CoinInfoBatchUpdate.
-func (mr *MockDatabaseMockRecorder) CoinInfoBatchUpdate(updates any) *gomock.Call {
+// CountHoldersAbove indicates an expected call of CountHoldersAbove.
+func (mr *MockDatabaseMockRecorder) CountHoldersAbove(id, threshold any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CoinInfoBatchUpdate", reflect.TypeOf((*MockDatabase)(nil).CoinInfoBatchUpdate), updates)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountHoldersAbove", reflect.TypeOf((*MockDatabase)(nil).CountHoldersAbove), id, threshold)
 }
 
 // GetBalancesByAddress mocks base method.
-func (m *MockDatabase) GetBalancesByAddress(address string) (map[string]int, error) {
+func (m *MockDatabase) GetBalancesByAddress(address string) (map[string]types.Amount, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetBalancesByAddress", address)
-	ret0, _ := ret[0].(map[string]int)
+	ret0, _ := ret[0].(map[string]types.Amount)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -82,6 +83,21 @@ func (mr *MockDatabaseMockRecorder) GetBalancesByAddress(address any) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalancesByAddress", reflect.TypeOf((*MockDatabase)(nil).GetBalancesByAddress), address)
 }
 
+// GetBlockHash mocks base method.
+func (m *MockDatabase) GetBlockHash(height int) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBlockHash", height)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBlockHash indicates an expected call of GetBlockHash.
+func (mr *MockDatabaseMockRecorder) GetBlockHash(height any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBlockHash", reflect.TypeOf((*MockDatabase)(nil).GetBlockHash), height)
+}
+
 // GetCoinInfoById mocks base method.
 func (m *MockDatabase) GetCoinInfoById(id string) (*types.CoinInfo, error) {
 	m.ctrl.T.Helper()
@@ -112,6 +128,36 @@ func (mr *MockDatabaseMockRecorder) GetCoinInfos() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCoinInfos", reflect.TypeOf((*MockDatabase)(nil).GetCoinInfos))
 }
 
+// GetCoinTxs mocks base method.
+func (m *MockDatabase) GetCoinTxs(id string, before, limit int, txType string) ([]*types.CoinTx, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCoinTxs", id, before, limit, txType)
+	ret0, _ := ret[0].([]*types.CoinTx)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCoinTxs indicates an expected call of GetCoinTxs.
+func (mr *MockDatabaseMockRecorder) GetCoinTxs(id, before, limit, txType any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCoinTxs", reflect.TypeOf((*MockDatabase)(nil).GetCoinTxs), id, before, limit, txType)
+}
+
+// GetCoinTxsByTxid mocks base method.
+func (m *MockDatabase) GetCoinTxsByTxid(txid string) ([]*types.CoinTx, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCoinTxsByTxid", txid)
+	ret0, _ := ret[0].([]*types.CoinTx)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCoinTxsByTxid indicates an expected call of GetCoinTxsByTxid.
+func (mr *MockDatabaseMockRecorder) GetCoinTxsByTxid(txid any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCoinTxsByTxid", reflect.TypeOf((*MockDatabase)(nil).GetCoinTxsByTxid), txid)
+}
+
 // GetCoinsByAddress mocks base method.
 func (m *MockDatabase) GetCoinsByAddress(address string) ([]*types.UnspentCoin, error) {
 	m.ctrl.T.Helper()
@@ -142,6 +188,68 @@ func (mr *MockDatabaseMockRecorder) GetCoinsInUtxos(utxos any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCoinsInUtxos", reflect.TypeOf((*MockDatabase)(nil).GetCoinsInUtxos), utxos)
 }
 
+// GetHistoryByAddress mocks base method.
+func (m *MockDatabase) GetHistoryByAddress(address, coin string, fromHeight, toHeight, offset, limit int) ([]*types.HistoryEntry, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHistoryByAddress", address, coin, fromHeight, toHeight, offset, limit)
+	ret0, _ := ret[0].([]*types.HistoryEntry)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetHistoryByAddress indicates an expected call of GetHistoryByAddress.
+func (mr *MockDatabaseMockRecorder) GetHistoryByAddress(address, coin, fromHeight, toHeight, offset, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHistoryByAddress", reflect.TypeOf((*MockDatabase)(nil).GetHistoryByAddress), address, coin, fromHeight, toHeight, offset, limit)
+}
+
+// GetHolders mocks base method.
+func (m *MockDatabase) GetHolders(id string, offset, limit int) ([]*types.Holder, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHolders", id, offset, limit)
+	ret0, _ := ret[0].([]*types.Holder)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetHolders indicates an expected call of GetHolders.
+func (mr *MockDatabaseMockRecorder) GetHolders(id, offset, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHolders", reflect.TypeOf((*MockDatabase)(nil).GetHolders), id, offset, limit)
+}
+
+// GetInvalidOperations mocks base method.
+func (m *MockDatabase) GetInvalidOperations(txid string) ([]*types.InvalidOperation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetInvalidOperations", txid)
+	ret0, _ := ret[0].([]*types.InvalidOperation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInvalidOperations indicates an expected call of GetInvalidOperations.
+func (mr *MockDatabaseMockRecorder) GetInvalidOperations(txid any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInvalidOperations", reflect.TypeOf((*MockDatabase)(nil).GetInvalidOperations), txid)
+}
+
+// GetSpentCoins mocks base method.
+func (m *MockDatabase) GetSpentCoins(utxos []string) ([]*types.SpentCoin, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSpentCoins", utxos)
+	ret0, _ := ret[0].([]*types.SpentCoin)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSpentCoins indicates an expected call of GetSpentCoins.
+func (mr *MockDatabaseMockRecorder) GetSpentCoins(utxos any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSpentCoins", reflect.TypeOf((*MockDatabase)(nil).GetSpentCoins), utxos)
+}
+
 // GetStatus mocks base method.
 func (m *MockDatabase) GetStatus() (int, string, error) {
 	m.ctrl.T.Helper()
@@ -158,30 +266,16 @@ func (mr *MockDatabaseMockRecorder) GetStatus() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStatus", reflect.TypeOf((*MockDatabase)(nil).GetStatus))
 }
 
-// IndexedHeightUpdate mocks base method.
-func (m *MockDatabase) IndexedHeightUpdate(height int) error {
-	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "IndexedHeightUpdate", height)
-	ret0, _ := ret[0].(error)
-	return ret0
-}
-
-// IndexedHeightUpdate indicates an expected call of IndexedHeightUpdate.
-func (mr *MockDatabaseMockRecorder) IndexedHeightUpdate(height any) *gomock.Call {
-	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IndexedHeightUpdate", reflect.TypeOf((*MockDatabase)(nil).IndexedHeightUpdate), height)
-}
-
-// UtxoBatchUpdate mocks base method.
-func (m *MockDatabase) UtxoBatchUpdate(updates map[string]*types.UnspentCoin) error {
+// RevertBlocks mocks base method.
+func (m *MockDatabase) RevertBlocks(n int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UtxoBatchUpdate", updates)
+	ret := m.ctrl.Call(m, "RevertBlocks", n)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// UtxoBatchUpdate indicates an expected call of UtxoBatchUpdate.
-func (mr *MockDatabaseMockRecorder) UtxoBatchUpdate(updates any) *gomock.Call {
+// RevertBlocks indicates an expected call of RevertBlocks.
+func (mr *MockDatabaseMockRecorder) RevertBlocks(n any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UtxoBatchUpdate", reflect.TypeOf((*MockDatabase)(nil).UtxoBatchUpdate), updates)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevertBlocks", reflect.TypeOf((*MockDatabase)(nil).RevertBlocks), n)
 }
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/decentralize-everything/indexer/types"
+)
+
+// Overlay is a copy-on-write view of a database. Applied blocks change the overlay only, reads fall back to the base
+// for anything not changed. It's not safe for concurrent use, and holder counts of coin infos are not maintained.
+type Overlay struct {
+	base     Database
+	coins    map[string]*types.CoinInfo
+	balances map[string]map[string]types.Amount // Balance deltas, by address and coin ID.
+	utxos    map[string]types.UtxoCoins         // Nil marks a spent UTXO.
+}
+
+var _ Database = (*Overlay)(nil)
+
+func NewOverlay(base Database) *Overlay {
+	return &Overlay{
+		base:     base,
+		coins:    make(map[string]*types.CoinInfo),
+		balances: make(map[string]map[string]types.Amount),
+		utxos:    make(map[string]types.UtxoCoins),
+	}
+}
+
+func (o *Overlay) GetStatus() (int, string, error) {
+	return o.base.GetStatus()
+}
+
+func (o *Overlay) GetCoinInfos() ([]*types.CoinInfo, error) {
+	infos, err := o.base.GetCoinInfos()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*types.CoinInfo
+	for _, ci := range infos {
+		if _, ok := o.coins[ci.Id]; !ok {
+			results = append(results, ci)
+		}
+	}
+	for _, ci := range o.coins {
+		results = append(results, ci)
+	}
+	return results, nil
+}
+
+func (o *Overlay) GetCoinInfoById(id string) (*types.CoinInfo, error) {
+	if ci, ok := o.coins[id]; ok {
+		return ci, nil
+	}
+	return o.base.GetCoinInfoById(id)
+}
+
+func (o *Overlay) GetCoinsInUtxos(utxos []string) ([]*types.UnspentCoin, error) {
+	var results []*types.UnspentCoin
+	var unchanged []string
+	for _, utxo := range utxos {
+		if coins, ok := o.utxos[utxo]; !ok {
+			unchanged = append(unchanged, utxo)
+		} else {
+			results = append(results, coins...)
+		}
+	}
+	if len(unchanged) == 0 {
+		return results, nil
+	}
+
+	coins, err := o.base.GetCoinsInUtxos(unchanged)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, coins...), nil
+}
+
+// GetSpentCoins returns the spent coins of the base, spends applied on the overlay are not included.
+func (o *Overlay) GetSpentCoins(utxos []string) ([]*types.SpentCoin, error) {
+	return o.base.GetSpentCoins(utxos)
+}
+
+func (o *Overlay) GetBalancesByAddress(address string) (map[string]types.Amount, error) {
+	balances, err := o.base.GetBalancesByAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := o.balances[address]; !ok {
+		return balances, nil
+	}
+
+	// The base map must not be changed.
+	results := make(map[string]types.Amount)
+	for coin, balance := range balances {
+		results[coin] = balance
+	}
+	for coin, delta := range o.balances[address] {
+		balance, err := results[coin].Add(delta)
+		if err != nil {
+			return nil, err
+		}
+		results[coin] = balance
+		if balance.IsZero() {
+			delete(results, coin)
+		}
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	return results, nil
+}
+
+func (o *Overlay) GetCoinsByAddress(address string) ([]*types.UnspentCoin, error) {
+	coins, err := o.base.GetCoinsByAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*types.UnspentCoin
+	for _, coin := range coins {
+		if _, ok := o.utxos[coin.Utxo]; !ok {
+			results = append(results, coin)
+		}
+	}
+	for _, coins := range o.utxos {
+		results = append(results, coins.Owned(address)...)
+	}
+	return results, nil
+}
+
+// GetHistoryByAddress returns the history of the base, history isn't kept by the overlay.
+func (o *Overlay) GetHistoryByAddress(address string, coin string, fromHeight int, toHeight int, offset int, limit int) ([]*types.HistoryEntry, int, error) {
+	return o.base.GetHistoryByAddress(address, coin, fromHeight, toHeight, offset, limit)
+}
+
+// GetCoinTxs returns the transactions of the base, transactions aren't kept by the overlay.
+func (o *Overlay) GetCoinTxs(id string, before int, limit int, txType string) ([]*types.CoinTx, error) {
+	return o.base.GetCoinTxs(id, before, limit, txType)
+}
+
+// GetCoinTxsByTxid returns the transactions of the base.
+func (o *Overlay) GetCoinTxsByTxid(txid string) ([]*types.CoinTx, error) {
+	return o.base.GetCoinTxsByTxid(txid)
+}
+
+// GetInvalidOperations returns the rejected operations of the base, rejections aren't kept by the overlay.
+func (o *Overlay) GetInvalidOperations(txid string) ([]*types.InvalidOperation, error) {
+	return o.base.GetInvalidOperations(txid)
+}
+
+// GetHolders returns the holders of the base, holders aren't ranked by the overlay.
+func (o *Overlay) GetHolders(id string, offset int, limit int) ([]*types.Holder, int, error) {
+	return o.base.GetHolders(id, offset, limit)
+}
+
+// CountHoldersAbove counts the holders of the base.
+func (o *Overlay) CountHoldersAbove(id string, threshold types.Amount) (int, error) {
+	return o.base.CountHoldersAbove(id, threshold)
+}
+
+// ApplyBlock keeps the changes in the overlay, the indexed height, block hash, history, coin transactions and
+// rejected operations are ignored.
+func (o *Overlay) ApplyBlock(update *types.BlockUpdate) error {
+	for id, ci := range update.CoinInfos {
+		o.coins[id] = ci
+	}
+	for coin, balances := range update.Balances {
+		for address, delta := range balances {
+			if _, ok := o.balances[address]; !ok {
+				o.balances[address] = make(map[string]types.Amount)
+			}
+			sum, err := o.balances[address][coin].Add(delta)
+			if err != nil {
+				return fmt.Errorf("balance of %s in %s: %v", address, coin, err)
+			}
+			o.balances[address][coin] = sum
+		}
+	}
+	for utxo, changes := range update.Utxos {
+		coins, ok := o.utxos[utxo]
+		if !ok {
+			base, err := o.base.GetCoinsInUtxos([]string{utxo})
+			if err != nil {
+				return err
+			}
+			coins = base
+		}
+		o.utxos[utxo] = coins.Update(changes)
+	}
+	return nil
+}
+
+func (o *Overlay) GetBlockHash(height int) (string, error) {
+	return o.base.GetBlockHash(height)
+}
+
+func (o *Overlay) RevertBlocks(n int) error {
+	return fmt.Errorf("overlay can't be reverted")
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverlay(t *testing.T) {
+	base := NewMemDb("", "testnet", 100, false, nil)
+	base.ApplyBlock(&types.BlockUpdate{
+		Height:    1,
+		CoinInfos: map[string]*types.CoinInfo{"c1": {Id: "c1", TotalSupply: types.NewAmount(3)}},
+		Balances:  map[string]map[string]types.Amount{"c1": {"a1": types.NewAmount(3)}},
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			"u1": {"c1": {CoinId: "c1", Owner: "a1", Amount: types.NewAmount(1), Utxo: "u1"}},
+			"u2": {"c1": {CoinId: "c1", Owner: "a1", Amount: types.NewAmount(2), Utxo: "u2"}},
+		},
+	})
+
+	overlay := NewOverlay(base)
+	assert.Nil(t, overlay.ApplyBlock(&types.BlockUpdate{
+		Height:    2,
+		CoinInfos: map[string]*types.CoinInfo{"c1": {Id: "c1", TotalSupply: types.NewAmount(4)}, "c2": {Id: "c2"}},
+		Balances:  map[string]map[string]types.Amount{"c1": {"a1": types.NewAmount(-2), "a2": types.NewAmount(3)}},
+		Utxos: map[string]map[string]*types.UnspentCoin{
+			"u2": {"c1": nil},
+			"u3": {"c1": {CoinId: "c1", Owner: "a2", Amount: types.NewAmount(3), Utxo: "u3"}},
+		},
+	}))
+
+	ci, _ := overlay.GetCoinInfoById("c1")
+	assert.Equal(t, types.NewAmount(4), ci.TotalSupply)
+	infos, _ := overlay.GetCoinInfos()
+	assert.Equal(t, 2, len(infos))
+	coins, _ := overlay.GetCoinsInUtxos([]string{"u1", "u2", "u3"})
+	assert.Equal(t, []string{"u3", "u1"}, []string{coins[0].Utxo, coins[1].Utxo})
+	balances, _ := overlay.GetBalancesByAddress("a1")
+	assert.Equal(t, map[string]types.Amount{"c1": types.NewAmount(1)}, balances)
+	balances, _ = overlay.GetBalancesByAddress("a2")
+	assert.Equal(t, map[string]types.Amount{"c1": types.NewAmount(3)}, balances)
+	coins, _ = overlay.GetCoinsByAddress("a1")
+	assert.Equal(t, 1, len(coins))
+	assert.Equal(t, "u1", coins[0].Utxo)
+	height, _, _ := overlay.GetStatus()
+	assert.Equal(t, 1, height)
+
+	// The base is untouched.
+	ci, _ = base.GetCoinInfoById("c1")
+	assert.Equal(t, types.NewAmount(3), ci.TotalSupply)
+	balances, _ = base.GetBalancesByAddress("a1")
+	assert.Equal(t, map[string]types.Amount{"c1": types.NewAmount(3)}, balances)
+	coins, _ = base.GetCoinsInUtxos([]string{"u2", "u3"})
+	assert.Equal(t, 1, len(coins))
+	assert.NotNil(t, overlay.RevertBlocks(1))
+}
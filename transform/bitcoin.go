@@ -1,6 +1,9 @@
 package transform
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/decentralize-everything/indexer/extract"
 	"github.com/decentralize-everything/indexer/protocol"
 	"github.com/decentralize-everything/indexer/store"
@@ -14,13 +17,26 @@ type BitcoinTransformer struct {
 	logger    *zap.Logger
 }
 
-func NewBitcoinTransformer(db store.Database, logger *zap.Logger) *BitcoinTransformer {
+// NewBitcoinTransformer creates a transformer running the parsers of the given protocols, in the given order.
+func NewBitcoinTransformer(db store.Database, protocols []string, logger *zap.Logger) *BitcoinTransformer {
+	var parsers []protocol.Parser
+	for _, name := range protocols {
+		switch name {
+		case "carv":
+			parsers = append(parsers, protocol.NewCarvProtocol(db, logger))
+		case "runes":
+			parsers = append(parsers, protocol.NewRuneProtocol(db, logger))
+		case "brc20":
+			parsers = append(parsers, protocol.NewBrc20Protocol(db, logger))
+		default:
+			panic("unsupported protocol: " + name)
+		}
+	}
+
 	return &BitcoinTransformer{
-		protocols: []protocol.Parser{
-			protocol.NewCarvProtocol(db, logger),
-		},
-		db:     db,
-		logger: logger,
+		protocols: parsers,
+		db:        db,
+		logger:    logger,
 	}
 }
 
@@ -29,11 +45,28 @@ func (t *BitcoinTransformer) Transform(block extract.Block) (*types.BatchUpdate,
 		Block: block,
 	}
 
+	for _, parser := range t.protocols {
+		if p, ok := parser.(protocol.BlockParser); ok {
+			p.StartBlock(block.GetHeight())
+		}
+	}
 	for _, tx := range block.GetTxs() {
-		for _, protocol := range t.protocols {
-			newCoinEvents, balanceChangeEvents, err := protocol.Parse(tx)
+		for _, parser := range t.protocols {
+			newCoinEvents, balanceChangeEvents, err := parser.Parse(tx)
 			if err != nil {
-				t.logger.Warn("protocol.Parse", zap.Error(err))
+				// Anything but a broken protocol rule is a failure of the store, the block is transformed again.
+				var invalid *protocol.InvalidOperationError
+				if !errors.As(err, &invalid) {
+					return nil, fmt.Errorf("failed to parse tx %s: %w", tx.GetTxid(), err)
+				}
+				t.logger.Warn("parser.Parse", zap.Error(err))
+				batchUpdate.InvalidOps = append(batchUpdate.InvalidOps, &types.InvalidOperation{
+					Txid:     tx.GetTxid(),
+					Height:   block.GetHeight(),
+					CoinId:   invalid.CoinId,
+					Protocol: invalid.Protocol,
+					Reason:   invalid.Reason,
+				})
 			}
 
 			if len(newCoinEvents) > 0 || len(balanceChangeEvents) > 0 {
@@ -1,11 +1,18 @@
 package transform
 
 import (
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/decentralize-everything/indexer/extract/mempool"
 	"github.com/decentralize-everything/indexer/store"
+	"github.com/decentralize-everything/indexer/types"
+	"github.com/decentralize-everything/indexer/utils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
 	"go.uber.org/zap"
 )
 
@@ -32,9 +39,9 @@ import (
 
 func TestBitcoinTransformerWithMempoolClient(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
-	db := store.NewMemDb("", "testnet", false, nil)
+	db := store.NewMemDb("", "testnet", 100, false, nil)
 	btcClient := mempool.NewBitcoinClient(&chaincfg.MainNetParams)
-	btcTransformer := NewBitcoinTransformer(db, logger)
+	btcTransformer := NewBitcoinTransformer(db, []string{"carv"}, logger)
 	for i := 820000; i < 820010; i++ {
 		blockHash, err := btcClient.GetBlockHash(i)
 		if err != nil {
@@ -51,3 +58,52 @@ func TestBitcoinTransformerWithMempoolClient(t *testing.T) {
 		}
 	}
 }
+
+func TestBitcoinTransformerInvalidOps(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	db := store.NewMemDb("", "testnet", 100, true, logger)
+	btcTransformer := NewBitcoinTransformer(db, []string{"carv"}, logger)
+	meta := hex.EncodeToString(utils.VarintEncodeArray([]uint64{utils.Base26Encode("TESTCA")}))
+	mint := func(txid string, value float64) mempool.Transaction {
+		return mempool.Transaction{
+			Txid: txid,
+			Vout: []mempool.Vout{
+				{Value: value, Address: "addr3"},
+				{Asm: fmt.Sprintf("OP_RETURN OP_PUSHBYTES_1 43 OP_PUSHBYTES_%d %s", len(meta)/2, meta)},
+			},
+		}
+	}
+
+	batchUpdate, err := btcTransformer.Transform(&mempool.Block{
+		Height: 800010,
+		Tx: []mempool.Transaction{
+			mint("t1", 20000),
+			mint("t2", 10000),
+			{Txid: "t3", Vout: []mempool.Vout{{Value: 10000, Address: "addr3"}}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, batchUpdate.TxUpdates, 1)
+	assert.Equal(t, "t2", batchUpdate.TxUpdates[0].Txid)
+	assert.Equal(t, []*types.InvalidOperation{{
+		Txid:     "t1",
+		Height:   800010,
+		CoinId:   "TESTCA",
+		Protocol: "carv",
+		Reason:   "mint Carv Coin TESTCA exceed mint limit, delta = 2, limit = 1",
+	}}, batchUpdate.InvalidOps)
+}
+
+// A failure of the store fails the block, which is transformed again, instead of dropping its operations.
+func TestBitcoinTransformerStoreError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	db := store.NewMockDatabase(ctrl)
+	db.EXPECT().GetCoinsInUtxos(gomock.Any()).Return(nil, errors.New("store closed"))
+	btcTransformer := NewBitcoinTransformer(db, []string{"carv"}, zap.NewNop())
+
+	_, err := btcTransformer.Transform(&mempool.Block{
+		Height: 800010,
+		Tx:     []mempool.Transaction{{Txid: "t1", Vout: []mempool.Vout{{Value: 10000, Address: "addr3"}}}},
+	})
+	assert.EqualError(t, err, "failed to parse tx t1: store closed")
+}
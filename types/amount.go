@@ -0,0 +1,243 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+
+	"github.com/decentralize-everything/indexer/utils"
+)
+
+// Amount is an amount of a coin, positive or negative, with a magnitude of up to 128 bits as Runes amounts. The zero
+// value is 0, and amounts compare with ==. Arithmetic returns an error rather than overflowing.
+type Amount struct {
+	neg bool // Never set for 0.
+	hi  uint64
+	lo  uint64
+}
+
+var (
+	MAX_AMOUNT = Amount{hi: math.MaxUint64, lo: math.MaxUint64}
+)
+
+func NewAmount(v int64) Amount {
+	if v < 0 {
+		// Negating the magnitude as uint64 is correct for math.MinInt64 too.
+		return Amount{neg: true, lo: -uint64(v)}
+	}
+	return Amount{lo: uint64(v)}
+}
+
+func AmountFromUint64(v uint64) Amount {
+	return Amount{lo: v}
+}
+
+// ParseAmount parses a decimal amount, with an optional sign.
+func ParseAmount(s string) (Amount, error) {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("invalid amount: %q", s)
+	}
+	return amountFromBig(v)
+}
+
+func amountFromBig(v *big.Int) (Amount, error) {
+	abs := new(big.Int).Abs(v)
+	if abs.BitLen() > 128 {
+		return Amount{}, fmt.Errorf("amount overflow: %s", v)
+	}
+	lo := new(big.Int).And(abs, new(big.Int).SetUint64(math.MaxUint64)).Uint64()
+	hi := new(big.Int).Rsh(abs, 64).Uint64()
+	return Amount{neg: v.Sign() < 0, hi: hi, lo: lo}, nil
+}
+
+func (a Amount) big() *big.Int {
+	v := new(big.Int).SetUint64(a.hi)
+	v.Lsh(v, 64).Or(v, new(big.Int).SetUint64(a.lo))
+	if a.neg {
+		v.Neg(v)
+	}
+	return v
+}
+
+func (a Amount) String() string {
+	if a.hi == 0 {
+		if a.neg {
+			return fmt.Sprintf("-%d", a.lo)
+		}
+		return fmt.Sprintf("%d", a.lo)
+	}
+	return a.big().String()
+}
+
+func (a Amount) IsZero() bool {
+	return a.hi == 0 && a.lo == 0
+}
+
+// Sign returns -1, 0 or 1.
+func (a Amount) Sign() int {
+	switch {
+	case a.IsZero():
+		return 0
+	case a.neg:
+		return -1
+	}
+	return 1
+}
+
+func (a Amount) Neg() Amount {
+	if a.IsZero() {
+		return a
+	}
+	a.neg = !a.neg
+	return a
+}
+
+// Cmp returns -1, 0 or 1 as a is less than, equal to or greater than b.
+func (a Amount) Cmp(b Amount) int {
+	if a.Sign() != b.Sign() {
+		if a.Sign() < b.Sign() {
+			return -1
+		}
+		return 1
+	}
+	c := cmpMagnitude(a, b)
+	if a.neg {
+		return -c
+	}
+	return c
+}
+
+func cmpMagnitude(a Amount, b Amount) int {
+	switch {
+	case a.hi != b.hi:
+		if a.hi < b.hi {
+			return -1
+		}
+		return 1
+	case a.lo != b.lo:
+		if a.lo < b.lo {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// Add returns a + b, or an error if the magnitude of the sum doesn't fit in 128 bits.
+func (a Amount) Add(b Amount) (Amount, error) {
+	if a.neg == b.neg {
+		lo, carry := bits.Add64(a.lo, b.lo, 0)
+		hi, carry := bits.Add64(a.hi, b.hi, carry)
+		if carry != 0 {
+			return Amount{}, fmt.Errorf("amount overflow: %s + %s", a, b)
+		}
+		return Amount{neg: a.neg, hi: hi, lo: lo}, nil
+	}
+
+	// Signs differ, subtract the smaller magnitude from the larger one.
+	if cmpMagnitude(a, b) < 0 {
+		a, b = b, a
+	}
+	lo, borrow := bits.Sub64(a.lo, b.lo, 0)
+	hi, _ := bits.Sub64(a.hi, b.hi, borrow)
+	sum := Amount{neg: a.neg, hi: hi, lo: lo}
+	if sum.IsZero() {
+		return Amount{}, nil
+	}
+	return sum, nil
+}
+
+// Sub returns a - b, or an error if the magnitude of the difference doesn't fit in 128 bits.
+func (a Amount) Sub(b Amount) (Amount, error) {
+	return a.Add(b.Neg())
+}
+
+// Mul returns a * b, or an error if the magnitude of the product doesn't fit in 128 bits.
+func (a Amount) Mul(b Amount) (Amount, error) {
+	product, err := amountFromBig(new(big.Int).Mul(a.big(), b.big()))
+	if err != nil {
+		return Amount{}, fmt.Errorf("amount overflow: %s * %s", a, b)
+	}
+	return product, nil
+}
+
+// Uint64 returns the amount as an uint64, false if it's negative or too large.
+func (a Amount) Uint64() (uint64, bool) {
+	return a.lo, !a.neg && a.hi == 0
+}
+
+// Float64 returns the nearest float64, for ratios.
+func (a Amount) Float64() float64 {
+	f := float64(a.hi)*math.Exp2(64) + float64(a.lo)
+	if a.neg {
+		return -f
+	}
+	return f
+}
+
+// MarshalJSON writes the amount as a decimal string, as JSON numbers lose precision in most decoders.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON reads a decimal string, or a number.
+func (a *Amount) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(bs, &n); err != nil {
+			return fmt.Errorf("invalid amount: %s", bs)
+		}
+		s = n.String()
+	}
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// ToBytes encodes the amount as an Amount of schema.proto.
+func (a Amount) ToBytes() []byte {
+	w := &utils.RecordWriter{}
+	w.Uint(1, a.lo)
+	w.Uint(2, a.hi)
+	w.Bool(3, a.neg)
+	return w.Bytes()
+}
+
+func (a *Amount) FromBytes(bs []byte) error {
+	*a = Amount{}
+	err := utils.ReadRecord(bs, func(f utils.RecordField) error {
+		switch f.Num {
+		case 1:
+			a.lo = f.Uint()
+		case 2:
+			a.hi = f.Uint()
+		case 3:
+			a.neg = f.Bool()
+		}
+		return nil
+	})
+	if a.IsZero() {
+		a.neg = false
+	}
+	return err
+}
+
+// writeAmount writes the amount as an embedded Amount, omitted if zero.
+func writeAmount(w *utils.RecordWriter, num int, a Amount) {
+	if !a.IsZero() {
+		w.Message(num, a.ToBytes())
+	}
+}
+
+func readAmount(f utils.RecordField) (Amount, error) {
+	var a Amount
+	err := a.FromBytes(f.Message())
+	return a, err
+}
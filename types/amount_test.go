@@ -0,0 +1,82 @@
+package types
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAmountArithmetic(t *testing.T) {
+	a, err := AmountFromUint64(math.MaxUint64).Add(NewAmount(1))
+	assert.Nil(t, err)
+	assert.Equal(t, "18446744073709551616", a.String())
+	_, ok := a.Uint64()
+	assert.False(t, ok)
+
+	a, err = a.Sub(NewAmount(2))
+	assert.Nil(t, err)
+	assert.Equal(t, AmountFromUint64(math.MaxUint64-1), a)
+
+	a, err = NewAmount(3).Sub(NewAmount(5))
+	assert.Nil(t, err)
+	assert.Equal(t, NewAmount(-2), a)
+	assert.Equal(t, -1, a.Sign())
+	assert.Equal(t, -1, a.Cmp(NewAmount(-1)))
+	assert.Equal(t, 1, NewAmount(1).Cmp(a))
+
+	a, err = NewAmount(-2).Add(NewAmount(2))
+	assert.Nil(t, err)
+	assert.Equal(t, Amount{}, a)
+	assert.Equal(t, NewAmount(0), NewAmount(0).Neg())
+	assert.Equal(t, "-9223372036854775808", NewAmount(math.MinInt64).String())
+
+	_, err = MAX_AMOUNT.Add(NewAmount(1))
+	assert.NotNil(t, err)
+	_, err = MAX_AMOUNT.Neg().Sub(NewAmount(1))
+	assert.NotNil(t, err)
+	a, err = MAX_AMOUNT.Add(NewAmount(-1))
+	assert.Nil(t, err)
+	assert.Equal(t, -1, a.Cmp(MAX_AMOUNT))
+
+	a, err = AmountFromUint64(math.MaxUint64).Mul(AmountFromUint64(math.MaxUint64))
+	assert.Nil(t, err)
+	assert.Equal(t, "340282366920938463426481119284349108225", a.String())
+	a, err = NewAmount(-3).Mul(NewAmount(2))
+	assert.Nil(t, err)
+	assert.Equal(t, NewAmount(-6), a)
+	_, err = MAX_AMOUNT.Mul(NewAmount(2))
+	assert.NotNil(t, err)
+}
+
+func TestAmountParse(t *testing.T) {
+	a, err := ParseAmount("340282366920938463463374607431768211455")
+	assert.Nil(t, err)
+	assert.Equal(t, MAX_AMOUNT, a)
+	a, err = ParseAmount("-42")
+	assert.Nil(t, err)
+	assert.Equal(t, NewAmount(-42), a)
+	_, err = ParseAmount("340282366920938463463374607431768211456")
+	assert.NotNil(t, err)
+	_, err = ParseAmount("1.5")
+	assert.NotNil(t, err)
+}
+
+func TestAmountCodec(t *testing.T) {
+	for _, a := range []Amount{{}, NewAmount(-7), MAX_AMOUNT, MAX_AMOUNT.Neg()} {
+		var decoded Amount
+		assert.Nil(t, decoded.FromBytes(a.ToBytes()))
+		assert.Equal(t, a, decoded)
+
+		bs, err := json.Marshal(a)
+		assert.Nil(t, err)
+		assert.Equal(t, `"`+a.String()+`"`, string(bs))
+		assert.Nil(t, json.Unmarshal(bs, &decoded))
+		assert.Equal(t, a, decoded)
+	}
+
+	var a Amount
+	assert.Nil(t, json.Unmarshal([]byte("12"), &a))
+	assert.Equal(t, NewAmount(12), a)
+}
@@ -0,0 +1,259 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/decentralize-everything/indexer/utils"
+)
+
+// CoinArgs are the parameters a coin was deployed with. Only the args of the protocol of the coin are set.
+type CoinArgs struct {
+	Carv  *CarvArgs
+	Runes *RunesArgs
+	Brc20 *Brc20Args
+}
+
+type CarvArgs struct {
+	Max   uint64 `json:"max"`
+	Sats  uint64 `json:"sats"`
+	Limit uint64 `json:"limit"`
+}
+
+// RunesArgs are in units of the smallest division of the rune. Max is the premine plus Cap mints of Limit, which are
+// open from block MintStart until block MintEnd, excluded, or with no end if MintEnd is 0.
+type RunesArgs struct {
+	Max          Amount `json:"max"`
+	Limit        Amount `json:"limit"`
+	Premine      Amount `json:"premine"`
+	Divisibility uint64 `json:"divisibility"`
+	Spacers      uint64 `json:"spacers"`
+	Symbol       uint64 `json:"symbol"`
+	Cap          uint64 `json:"cap"`
+	MintStart    uint64 `json:"mint_start"`
+	MintEnd      uint64 `json:"mint_end"`
+}
+
+// Brc20Args are in units of the smallest decimal of the tick.
+type Brc20Args struct {
+	Max      Amount `json:"max"`
+	Limit    Amount `json:"limit"`
+	Decimals uint64 `json:"decimals"`
+}
+
+// NewCoinArgs builds the args of the protocol from named values, as coins were stored before args were typed. Coins
+// indexed before protocols were recorded are all Carv.
+func NewCoinArgs(protocol string, values map[string]uint64) CoinArgs {
+	switch protocol {
+	case "", "carv":
+		return CoinArgs{Carv: &CarvArgs{Max: values["max"], Sats: values["sats"], Limit: values["limit"]}}
+	case "runes":
+		return CoinArgs{Runes: &RunesArgs{
+			Max:          AmountFromUint64(values["max"]),
+			Limit:        AmountFromUint64(values["limit"]),
+			Premine:      AmountFromUint64(values["premine"]),
+			Divisibility: values["divisibility"],
+			Spacers:      values["spacers"],
+			Symbol:       values["symbol"],
+		}}
+	case "brc20":
+		return CoinArgs{Brc20: &Brc20Args{
+			Max:      AmountFromUint64(values["max"]),
+			Limit:    AmountFromUint64(values["limit"]),
+			Decimals: values["decimals"],
+		}}
+	}
+	return CoinArgs{}
+}
+
+// Max returns the max supply, 0 if no args are set.
+func (a CoinArgs) Max() Amount {
+	switch {
+	case a.Carv != nil:
+		return AmountFromUint64(a.Carv.Max)
+	case a.Runes != nil:
+		return a.Runes.Max
+	case a.Brc20 != nil:
+		return a.Brc20.Max
+	}
+	return Amount{}
+}
+
+// Limit returns the max amount of a mint, 0 if no args are set.
+func (a CoinArgs) Limit() Amount {
+	switch {
+	case a.Carv != nil:
+		return AmountFromUint64(a.Carv.Limit)
+	case a.Runes != nil:
+		return a.Runes.Limit
+	case a.Brc20 != nil:
+		return a.Brc20.Limit
+	}
+	return Amount{}
+}
+
+// MarshalJSON writes the args of the protocol with the protocol as "type", e.g.
+// {"type":"carv","max":100,"sats":10000,"limit":1}, null if no args are set. Amounts are decimal strings.
+func (a CoinArgs) MarshalJSON() ([]byte, error) {
+	switch {
+	case a.Carv != nil:
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			*CarvArgs
+		}{"carv", a.Carv})
+	case a.Runes != nil:
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			*RunesArgs
+		}{"runes", a.Runes})
+	case a.Brc20 != nil:
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			*Brc20Args
+		}{"brc20", a.Brc20})
+	}
+	return []byte("null"), nil
+}
+
+func (a *CoinArgs) UnmarshalJSON(bs []byte) error {
+	*a = CoinArgs{}
+	if string(bs) == "null" {
+		return nil
+	}
+	var union struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(bs, &union); err != nil {
+		return err
+	}
+	switch union.Type {
+	case "carv":
+		a.Carv = &CarvArgs{}
+		return json.Unmarshal(bs, a.Carv)
+	case "runes":
+		a.Runes = &RunesArgs{}
+		return json.Unmarshal(bs, a.Runes)
+	case "brc20":
+		a.Brc20 = &Brc20Args{}
+		return json.Unmarshal(bs, a.Brc20)
+	}
+	return fmt.Errorf("unknown coin args type: %q", union.Type)
+}
+
+// encodeArgs writes the args of the protocol as a CarvArgs, RunesArgs or Brc20Args field of CoinInfo.
+func encodeArgs(w *utils.RecordWriter, a CoinArgs) {
+	args := &utils.RecordWriter{}
+	switch {
+	case a.Carv != nil:
+		args.Uint(1, a.Carv.Max)
+		args.Uint(2, a.Carv.Sats)
+		args.Uint(3, a.Carv.Limit)
+		w.Message(4, args.Bytes())
+	case a.Runes != nil:
+		args.Uint(4, a.Runes.Divisibility)
+		args.Uint(5, a.Runes.Spacers)
+		args.Uint(6, a.Runes.Symbol)
+		writeAmount(args, 7, a.Runes.Max)
+		writeAmount(args, 8, a.Runes.Limit)
+		writeAmount(args, 9, a.Runes.Premine)
+		args.Uint(10, a.Runes.Cap)
+		args.Uint(11, a.Runes.MintStart)
+		args.Uint(12, a.Runes.MintEnd)
+		w.Message(11, args.Bytes())
+	case a.Brc20 != nil:
+		args.Uint(3, a.Brc20.Decimals)
+		writeAmount(args, 4, a.Brc20.Max)
+		writeAmount(args, 5, a.Brc20.Limit)
+		w.Message(12, args.Bytes())
+	}
+}
+
+// decodeArgs reads the CarvArgs, RunesArgs or Brc20Args field of CoinInfo.
+func decodeArgs(f utils.RecordField, a *CoinArgs) error {
+	switch f.Num {
+	case 4:
+		args := &CarvArgs{}
+		a.Carv = args
+		return utils.ReadRecord(f.Message(), func(arg utils.RecordField) error {
+			switch arg.Num {
+			case 1:
+				args.Max = arg.Uint()
+			case 2:
+				args.Sats = arg.Uint()
+			case 3:
+				args.Limit = arg.Uint()
+			}
+			return nil
+		})
+	case 11:
+		args := &RunesArgs{}
+		a.Runes = args
+		return utils.ReadRecord(f.Message(), func(arg utils.RecordField) error {
+			var err error
+			switch arg.Num {
+			case 1: // Before amounts took 128 bits.
+				args.Max = AmountFromUint64(arg.Uint())
+			case 2:
+				args.Limit = AmountFromUint64(arg.Uint())
+			case 3:
+				args.Premine = AmountFromUint64(arg.Uint())
+			case 4:
+				args.Divisibility = arg.Uint()
+			case 5:
+				args.Spacers = arg.Uint()
+			case 6:
+				args.Symbol = arg.Uint()
+			case 7:
+				args.Max, err = readAmount(arg)
+			case 8:
+				args.Limit, err = readAmount(arg)
+			case 9:
+				args.Premine, err = readAmount(arg)
+			case 10:
+				args.Cap = arg.Uint()
+			case 11:
+				args.MintStart = arg.Uint()
+			case 12:
+				args.MintEnd = arg.Uint()
+			}
+			return err
+		})
+	case 12:
+		args := &Brc20Args{}
+		a.Brc20 = args
+		return utils.ReadRecord(f.Message(), func(arg utils.RecordField) error {
+			var err error
+			switch arg.Num {
+			case 1: // Before amounts took 128 bits.
+				args.Max = AmountFromUint64(arg.Uint())
+			case 2:
+				args.Limit = AmountFromUint64(arg.Uint())
+			case 3:
+				args.Decimals = arg.Uint()
+			case 4:
+				args.Max, err = readAmount(arg)
+			case 5:
+				args.Limit, err = readAmount(arg)
+			}
+			return err
+		})
+	}
+	return nil
+}
+
+// decodeArg reads an Arg entry of CoinInfo, written for Runes and BRC-20 coins before their args were typed.
+func decodeArg(f utils.RecordField, values map[string]uint64) error {
+	var key string
+	var value uint64
+	err := utils.ReadRecord(f.Message(), func(arg utils.RecordField) error {
+		switch arg.Num {
+		case 1:
+			key = arg.String()
+		case 2:
+			value = arg.Uint()
+		}
+		return nil
+	})
+	values[key] = value
+	return err
+}
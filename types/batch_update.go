@@ -9,6 +9,20 @@ type TxUpdate struct {
 }
 
 type BatchUpdate struct {
-	Block     extract.Block
-	TxUpdates []*TxUpdate
+	Block      extract.Block
+	TxUpdates  []*TxUpdate
+	InvalidOps []*InvalidOperation // Transactions rejected by the parsers.
+}
+
+// BlockUpdate is the merged state change of a block, applied to the store as a whole.
+type BlockUpdate struct {
+	Height     int
+	Hash       string
+	CoinInfos  map[string]*CoinInfo
+	Balances   map[string]map[string]Amount       // Balance deltas, by coin ID and address.
+	Utxos      map[string]map[string]*UnspentCoin // Coins created or spent, by UTXO and coin ID. Nil marks a spent coin.
+	Spends     map[string]SpentCoins              // Coins of the spent UTXOs, with the spending transactions.
+	History    []*HistoryEntry                    // In transaction order.
+	CoinTxs    []*CoinTx                          // In transaction order.
+	InvalidOps []*InvalidOperation                // Transactions rejected by the parsers or the updater.
 }
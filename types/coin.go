@@ -1,14 +1,19 @@
 package types
 
 import (
-	"bytes"
-	"encoding/gob"
+	"sort"
+
+	"github.com/decentralize-everything/indexer/utils"
 )
 
+// Records are encoded in the protobuf wire format, with the messages defined in store/schema.proto. Field numbers are
+// never reused, new fields get new numbers.
+
 type CoinInfo struct {
 	Id           string
-	TotalSupply  int
-	Args         map[string]interface{}
+	Protocol     string
+	TotalSupply  Amount
+	Args         CoinArgs
 	TxCount      int
 	HolderCount  int
 	CreatedAt    int
@@ -17,32 +22,235 @@ type CoinInfo struct {
 }
 
 func (m *CoinInfo) ToBytes() []byte {
-	var data bytes.Buffer
-	if err := gob.NewEncoder(&data).Encode(m); err != nil {
-		panic(err)
-	}
-	return data.Bytes()
+	w := &utils.RecordWriter{}
+	w.String(1, m.Id)
+	w.String(2, m.Protocol)
+	writeAmount(w, 13, m.TotalSupply)
+	encodeArgs(w, m.Args)
+	w.Int(6, m.TxCount)
+	w.Int(7, m.HolderCount)
+	w.Int(8, m.CreatedAt)
+	w.String(9, m.DeployTx)
+	w.Int(10, m.DeployHeight)
+	return w.Bytes()
 }
 
 func (m *CoinInfo) FromBytes(bs []byte) error {
-	return gob.NewDecoder(bytes.NewReader(bs)).Decode(m)
+	var legacyArgs map[string]uint64
+	err := utils.ReadRecord(bs, func(f utils.RecordField) error {
+		switch f.Num {
+		case 1:
+			m.Id = f.String()
+		case 2:
+			m.Protocol = f.String()
+		case 3: // Before amounts took 128 bits.
+			m.TotalSupply = NewAmount(int64(f.Int()))
+		case 13:
+			var err error
+			m.TotalSupply, err = readAmount(f)
+			return err
+		case 4, 11, 12:
+			return decodeArgs(f, &m.Args)
+		case 5:
+			if legacyArgs == nil {
+				legacyArgs = make(map[string]uint64)
+			}
+			return decodeArg(f, legacyArgs)
+		case 6:
+			m.TxCount = f.Int()
+		case 7:
+			m.HolderCount = f.Int()
+		case 8:
+			m.CreatedAt = f.Int()
+		case 9:
+			m.DeployTx = f.String()
+		case 10:
+			m.DeployHeight = f.Int()
+		}
+		return nil
+	})
+	if legacyArgs != nil {
+		m.Args = NewCoinArgs(m.Protocol, legacyArgs)
+	}
+	return err
 }
 
 type UnspentCoin struct {
-	CoinId string
-	Owner  string
-	Amount int
-	Utxo   string
+	CoinId   string
+	Protocol string
+	Owner    string
+	Amount   Amount
+	Utxo     string
 }
 
 func (m *UnspentCoin) ToBytes() []byte {
-	var data bytes.Buffer
-	if err := gob.NewEncoder(&data).Encode(m); err != nil {
-		panic(err)
-	}
-	return data.Bytes()
+	w := &utils.RecordWriter{}
+	w.String(1, m.CoinId)
+	w.String(2, m.Protocol)
+	w.String(3, m.Owner)
+	w.String(5, m.Utxo)
+	writeAmount(w, 6, m.Amount)
+	return w.Bytes()
 }
 
 func (m *UnspentCoin) FromBytes(bs []byte) error {
-	return gob.NewDecoder(bytes.NewReader(bs)).Decode(m)
+	return utils.ReadRecord(bs, func(f utils.RecordField) error {
+		switch f.Num {
+		case 1:
+			m.CoinId = f.String()
+		case 2:
+			m.Protocol = f.String()
+		case 3:
+			m.Owner = f.String()
+		case 4: // Before amounts took 128 bits.
+			m.Amount = NewAmount(int64(f.Int()))
+		case 5:
+			m.Utxo = f.String()
+		case 6:
+			var err error
+			m.Amount, err = readAmount(f)
+			return err
+		}
+		return nil
+	})
+}
+
+// UtxoCoins are the coins held by a UTXO, one per coin ID. A UTXO holds the coins of every protocol sent to it, and
+// several runes.
+type UtxoCoins []*UnspentCoin
+
+func (m UtxoCoins) ToBytes() []byte {
+	w := &utils.RecordWriter{}
+	for _, uc := range m {
+		w.Message(1, uc.ToBytes())
+	}
+	return w.Bytes()
+}
+
+func (m *UtxoCoins) FromBytes(bs []byte) error {
+	*m = nil
+	return utils.ReadRecord(bs, func(f utils.RecordField) error {
+		if f.Num != 1 {
+			return nil
+		}
+		uc := &UnspentCoin{}
+		*m = append(*m, uc)
+		return uc.FromBytes(f.Message())
+	})
+}
+
+// Owners returns the owners of the coins, in the order of the coins.
+func (m UtxoCoins) Owners() []string {
+	var owners []string
+	seen := make(map[string]bool)
+	for _, uc := range m {
+		if !seen[uc.Owner] {
+			seen[uc.Owner] = true
+			owners = append(owners, uc.Owner)
+		}
+	}
+	return owners
+}
+
+// Owned returns the coins of the owner, nil if none.
+func (m UtxoCoins) Owned(owner string) UtxoCoins {
+	var results UtxoCoins
+	for _, uc := range m {
+		if uc.Owner == owner {
+			results = append(results, uc)
+		}
+	}
+	return results
+}
+
+// Update returns the coins with the changes applied, by coin ID, where nil marks a spent coin. New coins are added in
+// the order of their IDs. It returns nil if no coin is left, and never modifies m, which may be shared with readers.
+func (m UtxoCoins) Update(changes map[string]*UnspentCoin) UtxoCoins {
+	var results UtxoCoins
+	for _, uc := range m {
+		if changed, ok := changes[uc.CoinId]; !ok {
+			results = append(results, uc)
+		} else if changed != nil {
+			results = append(results, changed)
+		}
+	}
+
+	var added []string
+	for id, uc := range changes {
+		if uc != nil && !m.has(id) {
+			added = append(added, id)
+		}
+	}
+	sort.Strings(added)
+	for _, id := range added {
+		results = append(results, changes[id])
+	}
+	return results
+}
+
+func (m UtxoCoins) has(id string) bool {
+	for _, uc := range m {
+		if uc.CoinId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// SpentCoin is a coin which was spent by a transaction.
+type SpentCoin struct {
+	UnspentCoin
+	SpentTxid   string
+	SpentHeight int
+}
+
+func (m *SpentCoin) ToBytes() []byte {
+	w := &utils.RecordWriter{}
+	w.Message(1, m.UnspentCoin.ToBytes())
+	w.String(2, m.SpentTxid)
+	w.Int(3, m.SpentHeight)
+	return w.Bytes()
+}
+
+func (m *SpentCoin) FromBytes(bs []byte) error {
+	return utils.ReadRecord(bs, func(f utils.RecordField) error {
+		switch f.Num {
+		case 1:
+			return m.UnspentCoin.FromBytes(f.Message())
+		case 2:
+			m.SpentTxid = f.String()
+		case 3:
+			m.SpentHeight = f.Int()
+		}
+		return nil
+	})
+}
+
+// SpentCoins are the coins of a spent UTXO.
+type SpentCoins []*SpentCoin
+
+func (m SpentCoins) ToBytes() []byte {
+	w := &utils.RecordWriter{}
+	for _, sc := range m {
+		w.Message(1, sc.ToBytes())
+	}
+	return w.Bytes()
+}
+
+func (m *SpentCoins) FromBytes(bs []byte) error {
+	*m = nil
+	return utils.ReadRecord(bs, func(f utils.RecordField) error {
+		if f.Num != 1 {
+			return nil
+		}
+		sc := &SpentCoin{}
+		*m = append(*m, sc)
+		return sc.FromBytes(f.Message())
+	})
+}
+
+// Holder is an address holding a coin.
+type Holder struct {
+	Address string `json:"address"`
+	Balance Amount `json:"balance"`
 }
@@ -1,17 +1,20 @@
 package types
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
+
+	"github.com/decentralize-everything/indexer/utils"
 )
 
 func TestCoinInfoCodec(t *testing.T) {
 	ci := &CoinInfo{
 		Id:          "CARV",
-		TotalSupply: 1,
-		Args: map[string]interface{}{
-			"max": uint64(100),
-		},
+		TotalSupply: NewAmount(1),
+		Args: CoinArgs{Carv: &CarvArgs{
+			Max: 100,
+		}},
 		TxCount:     1,
 		HolderCount: 1,
 		CreatedAt:   2,
@@ -32,7 +35,7 @@ func TestUnspentCoinCodec(t *testing.T) {
 	uc := &UnspentCoin{
 		CoinId: "CARV",
 		Owner:  "1234",
-		Amount: 1,
+		Amount: NewAmount(1),
 		Utxo:   "5678",
 	}
 
@@ -46,3 +49,104 @@ func TestUnspentCoinCodec(t *testing.T) {
 		t.Fatal("not equal")
 	}
 }
+
+func TestUtxoCoins(t *testing.T) {
+	coins := UtxoCoins{
+		{CoinId: "CARV", Protocol: "carv", Owner: "1234", Amount: NewAmount(1), Utxo: "5678:0"},
+		{CoinId: "runes:1", Protocol: "runes", Owner: "1234", Amount: NewAmount(2), Utxo: "5678:0"},
+	}
+	coins2 := UtxoCoins{}
+	if err := coins2.FromBytes(coins.ToBytes()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(coins, coins2) {
+		t.Fatal("not equal")
+	}
+
+	updated := coins.Update(map[string]*UnspentCoin{
+		"CARV":    nil,
+		"runes:3": {CoinId: "runes:3", Protocol: "runes", Owner: "9abc", Amount: NewAmount(3), Utxo: "5678:0"},
+		"runes:2": {CoinId: "runes:2", Protocol: "runes", Owner: "1234", Amount: NewAmount(4), Utxo: "5678:0"},
+	})
+	if len(coins) != 2 || coins[0].CoinId != "CARV" {
+		t.Fatal("updated in place")
+	}
+	ids := []string{}
+	for _, uc := range updated {
+		ids = append(ids, uc.CoinId)
+	}
+	if !reflect.DeepEqual(ids, []string{"runes:1", "runes:2", "runes:3"}) {
+		t.Fatalf("unexpected coins %v", ids)
+	}
+	if !reflect.DeepEqual(updated.Owners(), []string{"1234", "9abc"}) || len(updated.Owned("9abc")) != 1 {
+		t.Fatal("unexpected owners")
+	}
+	if updated.Update(map[string]*UnspentCoin{"runes:1": nil, "runes:2": nil, "runes:3": nil}) != nil {
+		t.Fatal("spent coins left")
+	}
+}
+
+func TestCoinInfoArgsCodec(t *testing.T) {
+	for _, args := range []CoinArgs{
+		{Carv: &CarvArgs{Max: 100, Sats: 10000, Limit: 1}},
+		{Runes: &RunesArgs{Max: MAX_AMOUNT, Limit: NewAmount(1000), Premine: NewAmount(1000), Divisibility: 2, Symbol: 'R', Cap: 10, MintStart: 840000, MintEnd: 850000}},
+		{Brc20: &Brc20Args{Max: NewAmount(1000), Limit: NewAmount(100), Decimals: 1}},
+		{},
+	} {
+		ci := &CoinInfo{Id: "COIN", Args: args}
+		ci2 := &CoinInfo{}
+		if err := ci2.FromBytes(ci.ToBytes()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ci, ci2) {
+			t.Fatalf("args %+v, want %+v", ci2.Args, args)
+		}
+	}
+}
+
+// Runes and BRC-20 args were written as Arg entries before they were typed.
+func TestCoinInfoLegacyArgs(t *testing.T) {
+	w := &utils.RecordWriter{}
+	w.String(1, "ordi")
+	w.String(2, "brc20")
+	for _, arg := range []struct {
+		key   string
+		value uint64
+	}{{"max", 1000}, {"limit", 100}, {"decimals", 1}} {
+		a := &utils.RecordWriter{}
+		a.String(1, arg.key)
+		a.Uint(2, arg.value)
+		w.Message(5, a.Bytes())
+	}
+
+	ci := &CoinInfo{}
+	if err := ci.FromBytes(w.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	want := CoinArgs{Brc20: &Brc20Args{Max: NewAmount(1000), Limit: NewAmount(100), Decimals: 1}}
+	if !reflect.DeepEqual(want, ci.Args) {
+		t.Fatalf("args %+v, want %+v", ci.Args, want)
+	}
+}
+
+func TestCoinArgsJSON(t *testing.T) {
+	args := CoinArgs{Carv: &CarvArgs{Max: 100, Sats: 10000, Limit: 1}}
+	bs, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != `{"type":"carv","max":100,"sats":10000,"limit":1}` {
+		t.Fatalf("unexpected json: %s", bs)
+	}
+
+	var args2 CoinArgs
+	if err := json.Unmarshal(bs, &args2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, args2) {
+		t.Fatalf("args %+v, want %+v", args2, args)
+	}
+	if err := json.Unmarshal([]byte(`{"type":"unknown"}`), &args2); err == nil {
+		t.Fatal("unknown type accepted")
+	}
+}
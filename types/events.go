@@ -1,10 +1,10 @@
 package types
 
 type NewCoinEvent struct {
-	ChainId  string                 `json:"chain_id"`
-	Protocol string                 `json:"protocol"`
-	CoinId   string                 `json:"coin_id"`
-	Args     map[string]interface{} `json:"args"`
+	ChainId  string   `json:"chain_id"`
+	Protocol string   `json:"protocol"`
+	CoinId   string   `json:"coin_id"`
+	Args     CoinArgs `json:"args"`
 }
 
 type BalanceChangeEvent struct {
@@ -12,7 +12,7 @@ type BalanceChangeEvent struct {
 	Protocol string `json:"protocol"`
 	CoinId   string `json:"coin_id"`
 	Address  string `json:"address"`
-	Delta    int    `json:"delta"`
+	Delta    Amount `json:"delta"`
 	Utxo     string `json:"utxo"`
 	IsMint   bool   `json:"is_mint"`
 }
@@ -0,0 +1,152 @@
+package types
+
+import (
+	"github.com/decentralize-everything/indexer/utils"
+)
+
+// HistoryEntry is a balance change of an address, recorded when its block is applied.
+type HistoryEntry struct {
+	Txid      string `json:"txid"`
+	Height    int    `json:"height"`
+	Index     int    `json:"index"` // Position among the entries of the block.
+	Time      int    `json:"time"`
+	CoinId    string `json:"coin_id"`
+	Protocol  string `json:"protocol"`
+	Address   string `json:"address"`
+	Delta     Amount `json:"delta"`
+	Direction string `json:"direction"` // "in" or "out".
+	Utxo      string `json:"utxo"`
+	IsMint    bool   `json:"is_mint"`
+}
+
+func (m *HistoryEntry) ToBytes() []byte {
+	w := &utils.RecordWriter{}
+	w.String(1, m.Txid)
+	w.Int(2, m.Height)
+	w.Int(3, m.Index)
+	w.Int(4, m.Time)
+	w.String(5, m.CoinId)
+	w.String(6, m.Protocol)
+	w.String(7, m.Address)
+	w.String(9, m.Direction)
+	w.String(10, m.Utxo)
+	w.Bool(11, m.IsMint)
+	writeAmount(w, 12, m.Delta)
+	return w.Bytes()
+}
+
+func (m *HistoryEntry) FromBytes(bs []byte) error {
+	return utils.ReadRecord(bs, func(f utils.RecordField) error {
+		switch f.Num {
+		case 1:
+			m.Txid = f.String()
+		case 2:
+			m.Height = f.Int()
+		case 3:
+			m.Index = f.Int()
+		case 4:
+			m.Time = f.Int()
+		case 5:
+			m.CoinId = f.String()
+		case 6:
+			m.Protocol = f.String()
+		case 7:
+			m.Address = f.String()
+		case 8: // Before amounts took 128 bits.
+			m.Delta = NewAmount(int64(f.Int()))
+		case 9:
+			m.Direction = f.String()
+		case 10:
+			m.Utxo = f.String()
+		case 11:
+			m.IsMint = f.Bool()
+		case 12:
+			var err error
+			m.Delta, err = readAmount(f)
+			return err
+		}
+		return nil
+	})
+}
+
+// CoinTx is a transaction of a coin, recorded when its block is applied.
+type CoinTx struct {
+	Seq      int    `json:"seq"` // Position in the transactions of the coin, set by the store.
+	Txid     string `json:"txid"`
+	Height   int    `json:"height"`
+	Time     int    `json:"time"`
+	CoinId   string `json:"coin_id"`
+	Protocol string `json:"protocol"`
+	Type     string `json:"type"` // "deploy", "mint" or "transfer".
+}
+
+func (m *CoinTx) ToBytes() []byte {
+	w := &utils.RecordWriter{}
+	w.Int(1, m.Seq)
+	w.String(2, m.Txid)
+	w.Int(3, m.Height)
+	w.Int(4, m.Time)
+	w.String(5, m.CoinId)
+	w.String(6, m.Protocol)
+	w.String(7, m.Type)
+	return w.Bytes()
+}
+
+func (m *CoinTx) FromBytes(bs []byte) error {
+	return utils.ReadRecord(bs, func(f utils.RecordField) error {
+		switch f.Num {
+		case 1:
+			m.Seq = f.Int()
+		case 2:
+			m.Txid = f.String()
+		case 3:
+			m.Height = f.Int()
+		case 4:
+			m.Time = f.Int()
+		case 5:
+			m.CoinId = f.String()
+		case 6:
+			m.Protocol = f.String()
+		case 7:
+			m.Type = f.String()
+		}
+		return nil
+	})
+}
+
+// InvalidOperation is a transaction carrying protocol metadata which was rejected, recorded when its block is applied.
+type InvalidOperation struct {
+	Txid     string `json:"txid"`
+	Height   int    `json:"height"`
+	CoinId   string `json:"coin_id"` // Empty if unknown, e.g. the metadata can't be decoded.
+	Protocol string `json:"protocol"`
+	Reason   string `json:"reason"`
+}
+
+func (m *InvalidOperation) ToBytes() []byte {
+	w := &utils.RecordWriter{}
+	w.String(1, m.Txid)
+	w.Int(2, m.Height)
+	w.String(3, m.CoinId)
+	w.String(4, m.Protocol)
+	w.String(5, m.Reason)
+	return w.Bytes()
+}
+
+func (m *InvalidOperation) FromBytes(bs []byte) error {
+	return utils.ReadRecord(bs, func(f utils.RecordField) error {
+		switch f.Num {
+		case 1:
+			m.Txid = f.String()
+		case 2:
+			m.Height = f.Int()
+		case 3:
+			m.CoinId = f.String()
+		case 4:
+			m.Protocol = f.String()
+		case 5:
+			m.Reason = f.String()
+		}
+		return nil
+	})
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RecordWriter encodes a record in the protobuf wire format, so that stored records can be read from other languages
+// with their message definitions. Zero values are omitted, as in proto3.
+type RecordWriter struct {
+	buf []byte
+}
+
+func (w *RecordWriter) String(num int, s string) {
+	if len(s) > 0 {
+		w.buf = protowire.AppendTag(w.buf, protowire.Number(num), protowire.BytesType)
+		w.buf = protowire.AppendString(w.buf, s)
+	}
+}
+
+// Int writes an int64 field.
+func (w *RecordWriter) Int(num int, v int) {
+	w.Uint(num, uint64(int64(v)))
+}
+
+// Uint writes a uint64 field.
+func (w *RecordWriter) Uint(num int, v uint64) {
+	if v != 0 {
+		w.buf = protowire.AppendTag(w.buf, protowire.Number(num), protowire.VarintType)
+		w.buf = protowire.AppendVarint(w.buf, v)
+	}
+}
+
+func (w *RecordWriter) Bool(num int, v bool) {
+	if v {
+		w.Uint(num, 1)
+	}
+}
+
+// Message writes an embedded message, which is written even if empty.
+func (w *RecordWriter) Message(num int, bs []byte) {
+	w.buf = protowire.AppendTag(w.buf, protowire.Number(num), protowire.BytesType)
+	w.buf = protowire.AppendBytes(w.buf, bs)
+}
+
+func (w *RecordWriter) Bytes() []byte {
+	return w.buf
+}
+
+// RecordField is a field read from a record, converted by the reader to the type of the field.
+type RecordField struct {
+	Num    int
+	varint uint64
+	bytes  []byte
+}
+
+func (f RecordField) String() string {
+	return string(f.bytes)
+}
+
+func (f RecordField) Int() int {
+	return int(int64(f.varint))
+}
+
+func (f RecordField) Uint() uint64 {
+	return f.varint
+}
+
+func (f RecordField) Bool() bool {
+	return f.varint != 0
+}
+
+// Message returns the bytes of an embedded message.
+func (f RecordField) Message() []byte {
+	return f.bytes
+}
+
+// ReadRecord calls fn on the fields of the record in order. Fields of other wire types are skipped, so that records
+// can be read by code older than the writer.
+func ReadRecord(bs []byte, fn func(f RecordField) error) error {
+	for len(bs) > 0 {
+		num, typ, n := protowire.ConsumeTag(bs)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		bs = bs[n:]
+
+		f := RecordField{Num: int(num)}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(bs)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(bs)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, bs)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		bs = bs[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
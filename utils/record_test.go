@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	inner := &RecordWriter{}
+	inner.String(1, "a")
+	w := &RecordWriter{}
+	w.String(1, "txid")
+	w.Int(2, -5)
+	w.Uint(3, 0)
+	w.Bool(4, true)
+	w.Message(5, inner.Bytes())
+
+	fields := make(map[int]RecordField)
+	assert.Nil(t, ReadRecord(w.Bytes(), func(f RecordField) error {
+		fields[f.Num] = f
+		return nil
+	}))
+	assert.Equal(t, 4, len(fields))
+	assert.Equal(t, "txid", fields[1].String())
+	assert.Equal(t, -5, fields[2].Int())
+	assert.True(t, fields[4].Bool())
+	assert.Nil(t, ReadRecord(fields[5].Message(), func(f RecordField) error {
+		assert.Equal(t, "a", f.String())
+		return nil
+	}))
+
+	assert.NotNil(t, ReadRecord(w.Bytes()[:3], func(f RecordField) error { return nil }))
+}